@@ -0,0 +1,225 @@
+package alert
+
+import (
+	"LabMD-backend/metrics"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	StatePending  = "pending"
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+
+	notifyQueueSize = 64
+)
+
+type Alert struct {
+	Rule        string            `json:"rule"`
+	Severity    string            `json:"severity"`
+	Summary     string            `json:"summary,omitempty"`
+	Host        string            `json:"host"`
+	Metric      string            `json:"metric"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Op          string            `json:"op"`
+	Threshold   float64           `json:"threshold"`
+	Value       float64           `json:"value"`
+	State       string            `json:"state"`
+	ActiveSince time.Time         `json:"activeSince"`
+	FiredAt     time.Time         `json:"firedAt,omitzero"`
+	ResolvedAt  time.Time         `json:"resolvedAt,omitzero"`
+}
+
+type Notifier interface {
+	Notify(alert Alert) error
+}
+
+type Config struct {
+	Host        string
+	Rules       []Rule
+	RepeatAfter time.Duration // Re-notify still-firing alerts, 0 = never
+}
+
+type Engine struct {
+	mu        sync.RWMutex
+	host      string
+	rules     []compiledRule
+	repeat    time.Duration
+	active    map[string]*Alert
+	notified  map[string]time.Time
+	notifiers []Notifier
+	queue     chan Alert
+	logf      func(string, ...any)
+}
+
+func NewEngine(config Config, logf func(string, ...any)) *Engine {
+	e := &Engine{
+		host:     config.Host,
+		repeat:   config.RepeatAfter,
+		active:   make(map[string]*Alert),
+		notified: make(map[string]time.Time),
+		queue:    make(chan Alert, notifyQueueSize),
+		logf:     logf,
+	}
+	for _, rule := range config.Rules {
+		compiled, err := compileRule(rule)
+		if err != nil {
+			e.log("[WARN] Skipping %v", err)
+			continue
+		}
+		e.rules = append(e.rules, compiled)
+	}
+
+	go e.dispatch()
+	return e
+}
+
+func (e *Engine) AddNotifier(n Notifier) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.notifiers = append(e.notifiers, n)
+}
+
+func (e *Engine) RuleCount() int {
+	return len(e.rules)
+}
+
+// Evaluate checks every rule against the current metric families. Series
+// that no longer match or have disappeared are resolved.
+func (e *Engine) Evaluate(families []metrics.Family, now time.Time) {
+	byName := make(map[string]metrics.Family, len(families))
+	for _, family := range families {
+		byName[family.Name] = family
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	seen := make(map[string]bool)
+	for _, rule := range e.rules {
+		for _, metric := range byName[rule.family].Metrics {
+			if !rule.matches(metric.Labels) || !rule.compare(metric.Value, rule.Threshold) {
+				continue
+			}
+
+			key := seriesKey(rule.Name, metric.Labels)
+			seen[key] = true
+
+			current, ok := e.active[key]
+			if !ok {
+				current = &Alert{
+					Rule:        rule.Name,
+					Severity:    rule.Severity,
+					Summary:     rule.Summary,
+					Host:        e.host,
+					Metric:      rule.family,
+					Labels:      labelMap(metric.Labels),
+					Op:          rule.Op,
+					Threshold:   rule.Threshold,
+					State:       StatePending,
+					ActiveSince: now,
+				}
+				e.active[key] = current
+			}
+			current.Value = metric.Value
+
+			if current.State == StatePending && now.Sub(current.ActiveSince) >= rule.duration {
+				current.State = StateFiring
+				current.FiredAt = now
+				e.enqueue(key, *current, now)
+			} else if current.State == StateFiring && e.repeat > 0 && now.Sub(e.notified[key]) >= e.repeat {
+				e.enqueue(key, *current, now)
+			}
+		}
+	}
+
+	for key, current := range e.active {
+		if seen[key] {
+			continue
+		}
+		if current.State == StateFiring {
+			current.State = StateResolved
+			current.ResolvedAt = now
+			e.enqueue(key, *current, now)
+		}
+		delete(e.active, key)
+		delete(e.notified, key)
+	}
+}
+
+// Active returns pending and firing alerts, most severe first.
+func (e *Engine) Active() []Alert {
+	e.mu.RLock()
+	alerts := make([]Alert, 0, len(e.active))
+	for _, current := range e.active {
+		alerts = append(alerts, *current)
+	}
+	e.mu.RUnlock()
+
+	rank := map[string]int{SeverityCritical: 0, SeverityWarning: 1, SeverityInfo: 2}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].State != alerts[j].State {
+			return alerts[i].State == StateFiring
+		}
+		if rank[alerts[i].Severity] != rank[alerts[j].Severity] {
+			return rank[alerts[i].Severity] < rank[alerts[j].Severity]
+		}
+		return alerts[i].ActiveSince.Before(alerts[j].ActiveSince)
+	})
+	return alerts
+}
+
+func (e *Engine) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		json.NewEncoder(w).Encode(struct {
+			Alerts []Alert `json:"alerts"`
+		}{e.Active()})
+	}
+}
+
+// enqueue must be called with e.mu held.
+func (e *Engine) enqueue(key string, alert Alert, now time.Time) {
+	e.notified[key] = now
+	select {
+	case e.queue <- alert:
+	default:
+		e.log("[WARN] Notification queue full, dropping %s (%s)", alert.Rule, alert.State)
+	}
+}
+
+func (e *Engine) dispatch() {
+	for alert := range e.queue {
+		e.mu.RLock()
+		notifiers := append([]Notifier(nil), e.notifiers...)
+		e.mu.RUnlock()
+
+		e.log("%s %s [%s] %s=%g", alert.State, alert.Rule, alert.Severity, alert.Metric, alert.Value)
+		for _, n := range notifiers {
+			if err := n.Notify(alert); err != nil {
+				e.log("[WARN] Notification for %s failed: %v", alert.Rule, err)
+			}
+		}
+	}
+}
+
+func (e *Engine) log(format string, args ...any) {
+	if e.logf != nil {
+		e.logf("[Alert] "+format, args...)
+	}
+}
+
+func labelMap(labels []metrics.Label) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(labels))
+	for _, label := range labels {
+		m[label.Name] = label.Value
+	}
+	return m
+}
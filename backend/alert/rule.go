@@ -0,0 +1,150 @@
+package alert
+
+import (
+	"LabMD-backend/metrics"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Rule fires when a metric series satisfies Op Threshold continuously for
+// the duration given in For. Metric is a /metrics series name with an
+// optional label selector, e.g. labmd_partition_used_percent{mount="/home"}.
+type Rule struct {
+	Name      string  `json:"name"`
+	Metric    string  `json:"metric"`
+	Op        string  `json:"op"`
+	Threshold float64 `json:"threshold"`
+	For       string  `json:"for"`
+	Severity  string  `json:"severity"`
+	Summary   string  `json:"summary"`
+}
+
+type compiledRule struct {
+	Rule
+	family   string
+	selector map[string]string
+	duration time.Duration
+	compare  func(value, threshold float64) bool
+}
+
+var (
+	selectorPattern = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(?:\{(.*)\})?$`)
+	matcherPattern  = regexp.MustCompile(`^\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*=\s*"((?:[^"\\]|\\.)*)"\s*$`)
+)
+
+var comparisons = map[string]func(value, threshold float64) bool{
+	">":  func(v, t float64) bool { return v > t },
+	">=": func(v, t float64) bool { return v >= t },
+	"<":  func(v, t float64) bool { return v < t },
+	"<=": func(v, t float64) bool { return v <= t },
+	"==": func(v, t float64) bool { return v == t },
+	"!=": func(v, t float64) bool { return v != t },
+}
+
+func compileRule(rule Rule) (compiledRule, error) {
+	compiled := compiledRule{Rule: rule, selector: map[string]string{}}
+	if rule.Name == "" {
+		return compiled, fmt.Errorf("rule without name")
+	}
+
+	match := selectorPattern.FindStringSubmatch(strings.TrimSpace(rule.Metric))
+	if match == nil {
+		return compiled, fmt.Errorf("rule %s: invalid metric %q", rule.Name, rule.Metric)
+	}
+	compiled.family = match[1]
+	if match[2] != "" {
+		for _, part := range splitMatchers(match[2]) {
+			m := matcherPattern.FindStringSubmatch(part)
+			if m == nil {
+				return compiled, fmt.Errorf("rule %s: invalid label matcher %q", rule.Name, part)
+			}
+			compiled.selector[m[1]] = strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(m[2])
+		}
+	}
+
+	compare, ok := comparisons[rule.Op]
+	if !ok {
+		return compiled, fmt.Errorf("rule %s: unknown comparison %q", rule.Name, rule.Op)
+	}
+	compiled.compare = compare
+
+	if rule.For != "" {
+		duration, err := time.ParseDuration(rule.For)
+		if err != nil {
+			return compiled, fmt.Errorf("rule %s: invalid duration %q", rule.Name, rule.For)
+		}
+		compiled.duration = duration
+	}
+
+	switch rule.Severity {
+	case "":
+		compiled.Severity = SeverityWarning
+	case SeverityInfo, SeverityWarning, SeverityCritical:
+	default:
+		return compiled, fmt.Errorf("rule %s: unknown severity %q", rule.Name, rule.Severity)
+	}
+	return compiled, nil
+}
+
+// splitMatchers splits on commas outside quoted label values.
+func splitMatchers(s string) []string {
+	var parts []string
+	var current strings.Builder
+	quoted, escaped := false, false
+	for _, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			parts = append(parts, current.String())
+			current.Reset()
+			continue
+		}
+		current.WriteRune(r)
+	}
+	if strings.TrimSpace(current.String()) != "" {
+		parts = append(parts, current.String())
+	}
+	return parts
+}
+
+func (r compiledRule) matches(labels []metrics.Label) bool {
+	for name, want := range r.selector {
+		found := false
+		for _, label := range labels {
+			if label.Name == name {
+				found = label.Value == want
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func seriesKey(rule string, labels []metrics.Label) string {
+	sorted := append([]metrics.Label(nil), labels...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	var b strings.Builder
+	b.WriteString(rule)
+	for _, label := range sorted {
+		b.WriteString("|" + label.Name + "=" + label.Value)
+	}
+	return b.String()
+}
@@ -0,0 +1,139 @@
+package alert
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	WebhookGeneric  = "generic"
+	WebhookSlack    = "slack"
+	WebhookFeishu   = "feishu"
+	WebhookDingTalk = "dingtalk"
+)
+
+// Webhook posts alerts to a chat or automation endpoint. Secret enables the
+// signature schemes of Feishu and DingTalk custom bots.
+type Webhook struct {
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+type webhookNotifier struct {
+	config Webhook
+	client *http.Client
+}
+
+func NewWebhookNotifier(config Webhook) (Notifier, error) {
+	switch config.Type {
+	case "":
+		config.Type = WebhookGeneric
+	case WebhookGeneric, WebhookSlack, WebhookFeishu, WebhookDingTalk:
+	default:
+		return nil, fmt.Errorf("unknown webhook type %q", config.Type)
+	}
+	if config.URL == "" {
+		return nil, fmt.Errorf("%s webhook without url", config.Type)
+	}
+	return &webhookNotifier{config: config, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+func (n *webhookNotifier) Notify(alert Alert) error {
+	target := n.config.URL
+	var payload any
+
+	switch n.config.Type {
+	case WebhookSlack:
+		payload = map[string]string{"text": FormatText(alert)}
+	case WebhookFeishu:
+		body := map[string]any{
+			"msg_type": "text",
+			"content":  map[string]string{"text": FormatText(alert)},
+		}
+		if n.config.Secret != "" {
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			// Feishu signs an empty message with key "timestamp\nsecret"
+			mac := hmac.New(sha256.New, []byte(timestamp+"\n"+n.config.Secret))
+			body["timestamp"] = timestamp
+			body["sign"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		}
+		payload = body
+	case WebhookDingTalk:
+		payload = map[string]any{
+			"msgtype": "text",
+			"text":    map[string]string{"content": FormatText(alert)},
+		}
+		if n.config.Secret != "" {
+			timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+			mac := hmac.New(sha256.New, []byte(n.config.Secret))
+			mac.Write([]byte(timestamp + "\n" + n.config.Secret))
+			sign := url.QueryEscape(base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+			separator := "?"
+			if strings.Contains(target, "?") {
+				separator = "&"
+			}
+			target += separator + "timestamp=" + timestamp + "&sign=" + sign
+		}
+	default:
+		payload = alert
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := n.client.Post(target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s webhook returned %s", n.config.Type, resp.Status)
+	}
+	return nil
+}
+
+// FormatText renders an alert as a short plain-text message.
+func FormatText(alert Alert) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s][%s] %s on %s", strings.ToUpper(alert.State), alert.Severity, alert.Rule, alert.Host)
+	if alert.Summary != "" {
+		b.WriteString("\n" + alert.Summary)
+	}
+
+	names := make([]string, 0, len(alert.Labels))
+	for name := range alert.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	labels := make([]string, 0, len(names))
+	for _, name := range names {
+		labels = append(labels, fmt.Sprintf("%s=%q", name, alert.Labels[name]))
+	}
+
+	series := alert.Metric
+	if len(labels) > 0 {
+		series += "{" + strings.Join(labels, ",") + "}"
+	}
+	fmt.Fprintf(&b, "\n%s = %g (threshold %s %g)", series, alert.Value, alert.Op, alert.Threshold)
+	fmt.Fprintf(&b, "\nActive since %s", alert.ActiveSince.Format("2006-01-02 15:04:05"))
+	if alert.State == StateResolved {
+		fmt.Fprintf(&b, ", resolved at %s", alert.ResolvedAt.Format("2006-01-02 15:04:05"))
+	}
+	return b.String()
+}
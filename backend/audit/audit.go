@@ -0,0 +1,263 @@
+// Package audit keeps an append-only record of logins, administrative
+// actions and document changes. Events are JSON lines in a file that is
+// rotated by size; the server never edits or deletes entries other than
+// dropping the oldest rotated file.
+package audit
+
+import (
+	"LabMD-backend/server"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	Success = "success"
+	Failure = "failure" // The action was attempted and failed, e.g. a wrong password
+	Denied  = "denied"  // The caller was not allowed to do it
+
+	fileName    = "audit.log"
+	maxLineSize = 64 << 10
+)
+
+type Event struct {
+	Time   time.Time `json:"time"`
+	User   string    `json:"user,omitempty"`
+	IP     string    `json:"ip,omitempty"`
+	Action string    `json:"action"`           // e.g. "auth.login", "docs.write"
+	Target string    `json:"target,omitempty"` // What was acted on: a path, token or booking ID
+	Result string    `json:"result"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+type Config struct {
+	Dir      string
+	MaxSize  int64 // Rotate once the current file reaches this many bytes
+	MaxFiles int   // Rotated files kept besides the current one
+	Logf     func(string, ...any)
+}
+
+// Log appends events to Dir/audit.log. A nil *Log records nothing, so
+// callers need no checks when auditing is disabled.
+type Log struct {
+	config Config
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func Open(config Config) (*Log, error) {
+	if err := os.MkdirAll(config.Dir, 0o700); err != nil {
+		return nil, err
+	}
+	l := &Log{config: config}
+	if err := l.openFile(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Record appends e, stamping the time if it is unset. Write errors are
+// logged rather than returned: a full disk must not block logins.
+func (l *Log) Record(e Event) {
+	if l == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Result == "" {
+		e.Result = Success
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.size > 0 && l.size+int64(len(line)) > l.config.MaxSize {
+		if err := l.rotate(); err != nil {
+			l.log("[WARN] Rotating audit log: %v", err)
+		}
+	}
+	if l.file == nil {
+		// A failed rotation could not reopen the file; try again
+		if err := l.openFile(); err != nil {
+			l.log("[ERROR] Writing audit event %s: %v", e.Action, err)
+			return
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		l.log("[ERROR] Writing audit event %s: %v", e.Action, err)
+	}
+}
+
+// RecordRequest records e with the client address of r.
+func (l *Log) RecordRequest(r *http.Request, e Event) {
+	if l == nil {
+		return
+	}
+	e.IP = server.ClientIP(r)
+	l.Record(e)
+}
+
+func (l *Log) openFile() error {
+	path := filepath.Join(l.config.Dir, fileName)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+// rotate shifts audit.log.N to N+1, dropping the oldest, and starts a new
+// file. When the current file cannot be moved aside, recording continues
+// in it. It must be called with l.mu held.
+func (l *Log) rotate() error {
+	l.file.Close()
+	l.file = nil
+
+	base := filepath.Join(l.config.Dir, fileName)
+	os.Remove(base + "." + strconv.Itoa(l.config.MaxFiles))
+	for i := l.config.MaxFiles - 1; i >= 1; i-- {
+		os.Rename(base+"."+strconv.Itoa(i), base+"."+strconv.Itoa(i+1))
+	}
+	var err error
+	if l.config.MaxFiles > 0 {
+		err = os.Rename(base, base+".1")
+	} else {
+		err = os.Remove(base)
+	}
+	return errors.Join(err, l.openFile())
+}
+
+func (l *Log) log(format string, args ...any) {
+	if l.config.Logf != nil {
+		l.config.Logf("[Audit] "+format, args...)
+	}
+}
+
+// Filter selects events; zero fields match everything.
+type Filter struct {
+	User   string
+	Action string // Exact action or a prefix ending in ".", e.g. "auth."
+	Result string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+func (f Filter) match(e Event) bool {
+	switch {
+	case f.User != "" && e.User != f.User:
+		return false
+	case f.Action != "" && e.Action != f.Action &&
+		!(strings.HasSuffix(f.Action, ".") && strings.HasPrefix(e.Action, f.Action)):
+		return false
+	case f.Result != "" && e.Result != f.Result:
+		return false
+	case !f.Since.IsZero() && e.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !e.Time.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+// Query returns matching events, newest first, across the current and
+// rotated files.
+func (l *Log) Query(f Filter) ([]Event, error) {
+	files, err := l.openAll()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+
+	events := []Event{}
+	for _, file := range files {
+		matches, err := readMatches(file, f)
+		if err != nil {
+			return nil, err
+		}
+		slices.Reverse(matches)
+		events = append(events, matches...)
+		if f.Limit > 0 && len(events) >= f.Limit {
+			return events[:f.Limit], nil
+		}
+	}
+	return events, nil
+}
+
+// openAll opens the current and rotated files, newest first. It holds
+// l.mu so a rotation cannot move them in between; the open files are then
+// read without it.
+func (l *Log) openAll() ([]*os.File, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	base := filepath.Join(l.config.Dir, fileName)
+	paths := []string{base}
+	for i := 1; i <= l.config.MaxFiles; i++ {
+		paths = append(paths, base+"."+strconv.Itoa(i))
+	}
+
+	var files []*os.File
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			for _, file := range files {
+				file.Close()
+			}
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+func readMatches(file *os.File, f Filter) ([]Event, error) {
+	var matches []Event
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 4096), maxLineSize)
+	for scanner.Scan() {
+		var e Event
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		if f.match(e) {
+			matches = append(matches, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", file.Name(), err)
+	}
+	return matches, nil
+}
@@ -0,0 +1,59 @@
+package audit
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultQueryLimit = 200
+	maxQueryLimit     = 5000
+)
+
+// Handler serves GET /api/audit with optional user, action, result, since
+// and until (RFC 3339) and limit query parameters.
+func (l *Log) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		filter := Filter{
+			User:   query.Get("user"),
+			Action: query.Get("action"),
+			Result: query.Get("result"),
+			Limit:  defaultQueryLimit,
+		}
+		for name, dest := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+			if value := query.Get(name); value != "" {
+				t, err := time.Parse(time.RFC3339, value)
+				if err != nil {
+					http.Error(w, "Invalid "+name+": use RFC 3339, e.g. 2026-04-01T00:00:00Z", http.StatusBadRequest)
+					return
+				}
+				*dest = t
+			}
+		}
+		if value := query.Get("limit"); value != "" {
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit < 1 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			filter.Limit = min(limit, maxQueryLimit)
+		}
+
+		events, err := l.Query(filter)
+		if err != nil {
+			http.Error(w, "Failed to read audit log", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(events)
+	}
+}
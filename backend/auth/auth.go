@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrInvalidCredentials is returned for unknown users and wrong passwords
+// alike so callers cannot tell the two apart.
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+type Identity struct {
+	Username string   `json:"username"`
+	Source   string   `json:"source"` // Authenticator that verified the user
+	Roles    []string `json:"roles,omitempty"`
+}
+
+// Authenticator verifies a username and password pair.
+type Authenticator interface {
+	Name() string
+	Authenticate(username, password string) (*Identity, error)
+}
+
+// UserLookup is implemented by authenticators that can resolve a user
+// without a password, so API tokens follow their owner's current roles.
+// Unknown users yield ErrInvalidCredentials.
+type UserLookup interface {
+	Lookup(username string) (*Identity, error)
+}
+
+type contextKey struct{}
+
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// IdentityFrom returns the authenticated user of a request context, or nil
+// for anonymous requests.
+func IdentityFrom(ctx context.Context) *Identity {
+	id, _ := ctx.Value(contextKey{}).(*Identity)
+	return id
+}
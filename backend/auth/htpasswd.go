@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyHash keeps unknown-user lookups as slow as real ones.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("labmd-dummy"), bcrypt.DefaultCost)
+
+// LocalUsers authenticates against an htpasswd-style file with bcrypt
+// hashes ("user:$2y$..."). The file is re-read when it changes.
+type LocalUsers struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	hashes  map[string][]byte
+}
+
+func NewLocalUsers(path string) (*LocalUsers, error) {
+	l := &LocalUsers{path: path}
+	if err := l.reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *LocalUsers) Name() string { return "local" }
+
+func (l *LocalUsers) Authenticate(username, password string) (*Identity, error) {
+	if err := l.reload(); err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	hash, ok := l.hashes[username]
+	l.mu.Unlock()
+
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return &Identity{Username: username, Source: l.Name()}, nil
+}
+
+func (l *LocalUsers) Lookup(username string) (*Identity, error) {
+	if err := l.reload(); err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	_, ok := l.hashes[username]
+	l.mu.Unlock()
+
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return &Identity{Username: username, Source: l.Name()}, nil
+}
+
+func (l *LocalUsers) reload() error {
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.hashes != nil && info.ModTime().Equal(l.modTime) {
+		return nil
+	}
+
+	hashes, err := readHtpasswd(l.path)
+	if err != nil {
+		return err
+	}
+	l.hashes = hashes
+	l.modTime = info.ModTime()
+	return nil
+}
+
+func readHtpasswd(path string) (map[string][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hashes := make(map[string][]byte)
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("%s:%d: expected user:hash", path, lineNo)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("%s:%d: only bcrypt hashes are supported", path, lineNo)
+		}
+		hashes[user] = []byte(hash)
+	}
+	return hashes, scanner.Err()
+}
+
+// SetPassword creates or updates a user in an htpasswd file, keeping all
+// other entries and comments as they are. An existing file keeps its
+// owner and mode, so a server running as its own user can still read it.
+// A new one is readable by the group of its folder (labmd for /etc/labmd),
+// so the first user created on a fresh install does not lock the server
+// out.
+func SetPassword(path, username, password string) error {
+	if username == "" || strings.ContainsAny(username, ":\n") {
+		return errors.New("invalid username")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	mode, uid, gid := os.FileMode(0o600), -1, -1
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			uid, gid = int(stat.Uid), int(stat.Gid)
+		}
+	} else if dir, err := os.Stat(filepath.Dir(path)); err == nil {
+		mode = 0o640
+		if stat, ok := dir.Sys().(*syscall.Stat_t); ok {
+			gid = int(stat.Gid)
+		}
+	}
+
+	var lines []string
+	if data, err := os.ReadFile(path); err == nil {
+		if content := strings.TrimRight(string(data), "\n"); content != "" {
+			lines = strings.Split(content, "\n")
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	entry := username + ":" + string(hash)
+	replaced := false
+	for i, line := range lines {
+		if user, _, ok := strings.Cut(line, ":"); ok && user == username {
+			lines[i] = entry
+			replaced = true
+		}
+	}
+	if !replaced {
+		lines = append(lines, entry)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		return err
+	}
+	if err := os.Chown(tmp, uid, gid); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Chmod(tmp, mode); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,286 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	defaultUserFilter  = "(&(objectClass=posixAccount)(uid={username}))"
+	defaultGroupFilter = "(|(memberUid={username})(member={dn}))"
+)
+
+type LDAPConfig struct {
+	URL                string // ldap://host:389 or ldaps://host:636
+	StartTLS           bool
+	CACert             string // PEM file; system roots when empty
+	InsecureSkipVerify bool
+	Timeout            time.Duration
+
+	BindDN       string // Service account; anonymous search when empty
+	BindPassword string
+
+	BaseDN       string
+	UserFilter   string // {username} is replaced with the escaped login name
+	UserAttr     string // Attribute holding the canonical username
+	GroupBaseDN  string // Defaults to BaseDN
+	GroupFilter  string // {username} and {dn} are replaced
+	GroupAttr    string // Group attribute matched against GroupRoles keys
+	GroupRoles   map[string]string
+	DefaultRoles []string // Roles of users without a mapped group
+
+	// Dial opens the directory connection; nil uses the network. Tests set
+	// it to a stand-in directory.
+	Dial func(url string, tlsConfig *tls.Config, timeout time.Duration) (LDAPConn, error)
+}
+
+// LDAPConn is the part of *ldap.Conn the authenticator needs.
+type LDAPConn interface {
+	StartTLS(config *tls.Config) error
+	Bind(username, password string) error
+	Search(request *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+}
+
+// LDAPDirectory authenticates by searching the user with a service account
+// and binding as the found entry with the supplied password.
+type LDAPDirectory struct {
+	config    LDAPConfig
+	tlsConfig *tls.Config
+}
+
+func NewLDAPDirectory(config LDAPConfig) (*LDAPDirectory, error) {
+	if config.URL == "" || config.BaseDN == "" {
+		return nil, errors.New("ldap url and baseDN are required")
+	}
+	if config.UserFilter == "" {
+		config.UserFilter = defaultUserFilter
+	}
+	if !strings.Contains(config.UserFilter, "{username}") {
+		return nil, errors.New("ldap userFilter must contain {username}")
+	}
+	if config.UserAttr == "" {
+		config.UserAttr = "uid"
+	}
+	if config.GroupBaseDN == "" {
+		config.GroupBaseDN = config.BaseDN
+	}
+	if config.GroupFilter == "" {
+		config.GroupFilter = defaultGroupFilter
+	}
+	if config.GroupAttr == "" {
+		config.GroupAttr = "cn"
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 5 * time.Second
+	}
+	if config.Dial == nil {
+		config.Dial = dialLDAP
+	}
+
+	serverURL, err := url.Parse(config.URL)
+	if err != nil || serverURL.Hostname() == "" {
+		return nil, fmt.Errorf("invalid ldap url %q", config.URL)
+	}
+
+	// StartTLS hands the config to tls.Client as is, which needs the name
+	// to verify the certificate against
+	tlsConfig := &tls.Config{
+		ServerName:         serverURL.Hostname(),
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+	if config.CACert != "" {
+		pem, err := os.ReadFile(config.CACert)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", config.CACert)
+		}
+	}
+
+	return &LDAPDirectory{config: config, tlsConfig: tlsConfig}, nil
+}
+
+func (d *LDAPDirectory) Name() string { return "ldap" }
+
+func (d *LDAPDirectory) Authenticate(username, password string) (*Identity, error) {
+	// An empty password would be an unauthenticated bind, which most
+	// servers accept for any DN.
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := d.bindService(conn); err != nil {
+		return nil, err
+	}
+
+	entry, err := d.findUser(conn, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("user bind: %w", err)
+	}
+
+	name := entry.GetAttributeValue(d.config.UserAttr)
+	if name == "" {
+		name = username
+	}
+
+	// Group membership is read back with the service account, since plain
+	// users often may not search groups.
+	if err := d.bindService(conn); err != nil {
+		return nil, err
+	}
+	roles, err := d.roles(conn, name, entry.DN)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{Username: name, Source: d.Name(), Roles: roles}, nil
+}
+
+// Lookup resolves a user and their roles with the service account alone.
+func (d *LDAPDirectory) Lookup(username string) (*Identity, error) {
+	if username == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := d.bindService(conn); err != nil {
+		return nil, err
+	}
+
+	entry, err := d.findUser(conn, username)
+	if err != nil {
+		return nil, err
+	}
+
+	name := entry.GetAttributeValue(d.config.UserAttr)
+	if name == "" {
+		name = username
+	}
+	roles, err := d.roles(conn, name, entry.DN)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{Username: name, Source: d.Name(), Roles: roles}, nil
+}
+
+func (d *LDAPDirectory) findUser(conn LDAPConn, username string) (*ldap.Entry, error) {
+	result, err := conn.Search(ldap.NewSearchRequest(
+		d.config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(d.config.Timeout.Seconds()), false,
+		expandFilter(d.config.UserFilter, username, ""),
+		[]string{d.config.UserAttr}, nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("user search: %w", err)
+	}
+	if len(result.Entries) != 1 {
+		// Unknown and ambiguous users are rejected alike.
+		return nil, ErrInvalidCredentials
+	}
+	return result.Entries[0], nil
+}
+
+func (d *LDAPDirectory) connect() (LDAPConn, error) {
+	conn, err := d.config.Dial(d.config.URL, d.tlsConfig, d.config.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("connect %s: %w", d.config.URL, err)
+	}
+	if d.config.StartTLS {
+		if err := conn.StartTLS(d.tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("starttls: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+func (d *LDAPDirectory) bindService(conn LDAPConn) error {
+	if d.config.BindDN == "" {
+		return nil
+	}
+	if err := conn.Bind(d.config.BindDN, d.config.BindPassword); err != nil {
+		return fmt.Errorf("service bind: %w", err)
+	}
+	return nil
+}
+
+// roles maps the user's groups to LabMD roles, falling back to
+// DefaultRoles when no group is mapped.
+func (d *LDAPDirectory) roles(conn LDAPConn, username, dn string) ([]string, error) {
+	if len(d.config.GroupRoles) == 0 {
+		return slices.Clone(d.config.DefaultRoles), nil
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		d.config.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(d.config.Timeout.Seconds()), false,
+		expandFilter(d.config.GroupFilter, username, dn),
+		[]string{d.config.GroupAttr}, nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("group search: %w", err)
+	}
+
+	var roles []string
+	for _, group := range result.Entries {
+		for _, value := range group.GetAttributeValues(d.config.GroupAttr) {
+			if role, ok := d.config.GroupRoles[value]; ok && !slices.Contains(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+	}
+	if len(roles) == 0 {
+		return slices.Clone(d.config.DefaultRoles), nil
+	}
+	slices.Sort(roles)
+	return roles, nil
+}
+
+func expandFilter(filter, username, dn string) string {
+	return strings.NewReplacer(
+		"{username}", ldap.EscapeFilter(username),
+		"{dn}", ldap.EscapeFilter(dn),
+	).Replace(filter)
+}
+
+func dialLDAP(url string, tlsConfig *tls.Config, timeout time.Duration) (LDAPConn, error) {
+	conn, err := ldap.DialURL(url,
+		ldap.DialWithDialer(&net.Dialer{Timeout: timeout}),
+		ldap.DialWithTLSConfig(tlsConfig),
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(timeout)
+	return conn, nil
+}
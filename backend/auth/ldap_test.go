@@ -0,0 +1,256 @@
+package auth
+
+import (
+	"crypto/tls"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// fakeDirectory is a stand-in LDAP server. Searches are answered by exact
+// filter, so the tests also pin down the filters the authenticator sends.
+type fakeDirectory struct {
+	passwords map[string]string                // DN -> password
+	results   map[string][]*ldap.Entry         // filter -> entries
+	searchErr map[string]error                 // filter -> error
+	onSearch  func(bound, filter string) error // Checks who searches
+}
+
+// fakeConn records what one connection did.
+type fakeConn struct {
+	dir      *fakeDirectory
+	binds    []string // DNs, in order
+	bound    string
+	filters  []string
+	startTLS bool
+	closed   bool
+}
+
+func (c *fakeConn) StartTLS(*tls.Config) error {
+	c.startTLS = true
+	return nil
+}
+
+func (c *fakeConn) Bind(username, password string) error {
+	c.binds = append(c.binds, username)
+	if want, ok := c.dir.passwords[username]; !ok || want != password {
+		c.bound = ""
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+	c.bound = username
+	return nil
+}
+
+func (c *fakeConn) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	c.filters = append(c.filters, request.Filter)
+	if c.dir.onSearch != nil {
+		if err := c.dir.onSearch(c.bound, request.Filter); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.dir.searchErr[request.Filter]; err != nil {
+		return nil, err
+	}
+	return &ldap.SearchResult{Entries: c.dir.results[request.Filter]}, nil
+}
+
+func (c *fakeConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+const (
+	serviceDN = "cn=labmd,ou=services,dc=lab"
+	aliceDN   = "uid=alice,ou=people,dc=lab"
+)
+
+func newFakeLDAP(t *testing.T, config LDAPConfig) (*LDAPDirectory, *fakeDirectory, *[]*fakeConn) {
+	t.Helper()
+	dir := &fakeDirectory{
+		passwords: map[string]string{serviceDN: "service-secret", aliceDN: "alice-secret"},
+		results: map[string][]*ldap.Entry{
+			"(&(objectClass=posixAccount)(uid=alice))": {
+				ldap.NewEntry(aliceDN, map[string][]string{"uid": {"alice"}}),
+			},
+			"(|(memberUid=alice)(member=uid=alice,ou=people,dc=lab))": {
+				ldap.NewEntry("cn=lab-admins,ou=groups,dc=lab", map[string][]string{"cn": {"lab-admins"}}),
+				ldap.NewEntry("cn=students,ou=groups,dc=lab", map[string][]string{"cn": {"students"}}),
+				ldap.NewEntry("cn=printing,ou=groups,dc=lab", map[string][]string{"cn": {"printing"}}),
+			},
+		},
+		searchErr: map[string]error{},
+	}
+	var conns []*fakeConn
+	config.URL = "ldap://ldap.lab.example:389"
+	config.BaseDN = "dc=lab"
+	if config.BindDN == "" {
+		config.BindDN, config.BindPassword = serviceDN, "service-secret"
+	}
+	config.Dial = func(url string, tlsConfig *tls.Config, timeout time.Duration) (LDAPConn, error) {
+		if tlsConfig.ServerName != "ldap.lab.example" {
+			t.Errorf("TLS server name = %q, want the URL's host", tlsConfig.ServerName)
+		}
+		conn := &fakeConn{dir: dir}
+		conns = append(conns, conn)
+		return conn, nil
+	}
+	d, err := NewLDAPDirectory(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, dir, &conns
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	d, dir, conns := newFakeLDAP(t, LDAPConfig{
+		StartTLS:     true,
+		GroupRoles:   map[string]string{"lab-admins": "admin", "students": "member"},
+		DefaultRoles: []string{"viewer"},
+	})
+	// Groups may only be searched with the service account
+	dir.onSearch = func(bound, filter string) error {
+		if strings.HasPrefix(filter, "(|(memberUid=") && bound != serviceDN {
+			return ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("bound as "+bound))
+		}
+		return nil
+	}
+
+	id, err := d.Authenticate("alice", "alice-secret")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if id.Username != "alice" || id.Source != "ldap" {
+		t.Errorf("identity = %+v", id)
+	}
+	if want := []string{"admin", "member"}; !slices.Equal(id.Roles, want) {
+		t.Errorf("roles = %v, want %v", id.Roles, want)
+	}
+
+	conn := (*conns)[0]
+	if !conn.startTLS {
+		t.Error("StartTLS was not used")
+	}
+	if want := []string{serviceDN, aliceDN, serviceDN}; !slices.Equal(conn.binds, want) {
+		t.Errorf("binds = %v, want service, user, then service again", conn.binds)
+	}
+	if !conn.closed {
+		t.Error("connection left open")
+	}
+}
+
+func TestLDAPServiceBindFailure(t *testing.T) {
+	d, _, conns := newFakeLDAP(t, LDAPConfig{BindDN: serviceDN, BindPassword: "wrong"})
+
+	_, err := d.Authenticate("alice", "alice-secret")
+	if err == nil || errors.Is(err, ErrInvalidCredentials) || !strings.Contains(err.Error(), "service bind") {
+		t.Fatalf("err = %v, want a service bind error rather than bad credentials", err)
+	}
+	if filters := (*conns)[0].filters; len(filters) != 0 {
+		t.Errorf("searched %v without a service bind", filters)
+	}
+}
+
+func TestLDAPUserBindFailure(t *testing.T) {
+	d, _, conns := newFakeLDAP(t, LDAPConfig{GroupRoles: map[string]string{"lab-admins": "admin"}})
+
+	if _, err := d.Authenticate("alice", "not-her-password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("err = %v, want ErrInvalidCredentials", err)
+	}
+	if filters := (*conns)[0].filters; len(filters) != 1 {
+		t.Errorf("searches = %v, want only the user search", filters)
+	}
+
+	// An empty password would be an anonymous bind that servers accept
+	if _, err := d.Authenticate("alice", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("empty password: err = %v, want ErrInvalidCredentials", err)
+	}
+	if len(*conns) != 1 {
+		t.Error("connected for an empty password")
+	}
+}
+
+func TestLDAPUnknownUser(t *testing.T) {
+	d, _, _ := newFakeLDAP(t, LDAPConfig{})
+
+	if _, err := d.Authenticate("mallory", "whatever"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("err = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestLDAPGroupRoles(t *testing.T) {
+	tests := []struct {
+		name       string
+		groupRoles map[string]string
+		defaults   []string
+		want       []string
+	}{
+		{"mapped groups", map[string]string{"lab-admins": "admin", "students": "member"}, nil, []string{"admin", "member"}},
+		{"same role twice", map[string]string{"lab-admins": "member", "students": "member"}, nil, []string{"member"}},
+		{"no mapped group", map[string]string{"alumni": "member"}, []string{"viewer"}, []string{"viewer"}},
+		{"no mapping at all", nil, []string{"viewer"}, []string{"viewer"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, _, conns := newFakeLDAP(t, LDAPConfig{GroupRoles: test.groupRoles, DefaultRoles: test.defaults})
+			id, err := d.Authenticate("alice", "alice-secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(id.Roles, test.want) {
+				t.Errorf("roles = %v, want %v", id.Roles, test.want)
+			}
+			if test.groupRoles == nil && len((*conns)[0].filters) != 1 {
+				t.Errorf("searched groups without a mapping: %v", (*conns)[0].filters)
+			}
+		})
+	}
+}
+
+func TestLDAPGroupSearchFailure(t *testing.T) {
+	d, dir, _ := newFakeLDAP(t, LDAPConfig{GroupRoles: map[string]string{"lab-admins": "admin"}})
+	dir.searchErr["(|(memberUid=alice)(member=uid=alice,ou=people,dc=lab))"] = errors.New("busy")
+
+	// Roles must not silently fall back to the defaults
+	if _, err := d.Authenticate("alice", "alice-secret"); err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("err = %v, want the group search error", err)
+	}
+}
+
+func TestLDAPFilterEscaping(t *testing.T) {
+	d, _, conns := newFakeLDAP(t, LDAPConfig{})
+
+	if _, err := d.Authenticate("*)(uid=*", "alice-secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("err = %v, want ErrInvalidCredentials", err)
+	}
+	want := `(&(objectClass=posixAccount)(uid=\2a\29\28uid=\2a))`
+	if filters := (*conns)[0].filters; len(filters) != 1 || filters[0] != want {
+		t.Errorf("filters = %v, want %v", filters, want)
+	}
+
+	if got := expandFilter("(|(memberUid={username})(member={dn}))", "bob", `cn=Bob (Lab)\,x,dc=lab`); got != `(|(memberUid=bob)(member=cn=Bob \28Lab\29\5c,x,dc=lab))` {
+		t.Errorf("expandFilter = %s", got)
+	}
+}
+
+func TestLDAPLookup(t *testing.T) {
+	d, _, conns := newFakeLDAP(t, LDAPConfig{GroupRoles: map[string]string{"students": "member"}})
+
+	id, err := d.Lookup("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Username != "alice" || !slices.Equal(id.Roles, []string{"member"}) {
+		t.Errorf("identity = %+v", id)
+	}
+	if binds := (*conns)[0].binds; !slices.Equal(binds, []string{serviceDN}) {
+		t.Errorf("binds = %v, want only the service account", binds)
+	}
+
+	if _, err := d.Lookup("mallory"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("unknown user: err = %v, want ErrInvalidCredentials", err)
+	}
+}
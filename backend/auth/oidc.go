@@ -0,0 +1,454 @@
+package auth
+
+import (
+	"LabMD-backend/audit"
+	"LabMD-backend/server"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	oidcStateCookie  = "labmd_oidc_state"
+	oidcLoginTimeout = 10 * time.Minute
+	oidcClockSkew    = time.Minute
+	oidcKeysMinAge   = time.Minute // Refetch limit for unknown key IDs
+)
+
+type OIDCConfig struct {
+	Issuer        string
+	ClientID      string
+	ClientSecret  string // Empty for public clients
+	RedirectURL   string // Must point at /api/auth/oidc/callback
+	Scopes        []string
+	UsernameClaim string // e.g. preferred_username, email or sub
+	StripDomain   bool   // Drop "@domain" from the username claim
+	GroupsClaim   string
+	GroupRoles    map[string]string
+	DefaultRoles  []string
+	HTTPClient    *http.Client
+	Audit         *audit.Log
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcLogin struct {
+	verifier string
+	nonce    string
+	redirect string
+	expires  time.Time
+}
+
+// OIDC implements the authorization code flow with PKCE against an
+// OpenID Connect provider and ends in a regular LabMD session.
+type OIDC struct {
+	config   OIDCConfig
+	sessions *Sessions
+	logf     func(string, ...any)
+
+	mu          sync.Mutex
+	discovery   *oidcDiscovery
+	keys        map[string]jwk
+	keysFetched time.Time
+	pending     map[string]oidcLogin // state -> login in progress
+}
+
+func NewOIDC(config OIDCConfig, sessions *Sessions, logf func(string, ...any)) (*OIDC, error) {
+	if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, errors.New("oidc issuer, clientID and redirectURL are required")
+	}
+	config.Issuer = strings.TrimSuffix(config.Issuer, "/")
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "profile", "email"}
+	} else if !slices.Contains(config.Scopes, "openid") {
+		config.Scopes = append([]string{"openid"}, config.Scopes...)
+	}
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = "preferred_username"
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &OIDC{
+		config:   config,
+		sessions: sessions,
+		logf:     logf,
+		pending:  make(map[string]oidcLogin),
+	}, nil
+}
+
+// LoginHandler redirects the browser to the provider. ?redirect= names
+// the LabMD page to return to afterwards.
+func (o *OIDC) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	discovery, err := o.discover()
+	if err != nil {
+		o.log("[WARN] Discovery failed: %v", err)
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	state, verifier, nonce := randomToken(), randomToken(), randomToken()
+	now := time.Now()
+
+	o.mu.Lock()
+	for key, login := range o.pending {
+		if now.After(login.expires) {
+			delete(o.pending, key)
+		}
+	}
+	o.pending[state] = oidcLogin{
+		verifier: verifier,
+		nonce:    nonce,
+		redirect: localRedirect(r.URL.Query().Get("redirect")),
+		expires:  now.Add(oidcLoginTimeout),
+	}
+	o.mu.Unlock()
+
+	// The state cookie binds the callback to the browser that started the
+	// login. Lax cookies are sent on the provider's top-level redirect.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/auth/oidc/",
+		MaxAge:   int(oidcLoginTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   o.sessions.secure || r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {o.config.ClientID},
+		"redirect_uri":          {o.config.RedirectURL},
+		"scope":                 {strings.Join(o.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	target := discovery.AuthorizationEndpoint
+	if strings.Contains(target, "?") {
+		target += "&" + query.Encode()
+	} else {
+		target += "?" + query.Encode()
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// CallbackHandler exchanges the authorization code, validates the ID
+// token and starts a session.
+func (o *OIDC) CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		o.log("Provider returned %s: %s", e, query.Get("error_description"))
+		http.Error(w, "Login was not completed: "+e, http.StatusUnauthorized)
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if state == "" || err != nil || cookie.Value != state {
+		http.Error(w, "Invalid login state, please try again", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/api/auth/oidc/", MaxAge: -1})
+
+	o.mu.Lock()
+	login, ok := o.pending[state]
+	delete(o.pending, state)
+	o.mu.Unlock()
+	if !ok || time.Now().After(login.expires) {
+		http.Error(w, "Login expired, please try again", http.StatusBadRequest)
+		return
+	}
+
+	rawIDToken, err := o.exchange(query.Get("code"), login.verifier)
+	if err != nil {
+		o.log("[WARN] Code exchange failed: %v", err)
+		http.Error(w, "Login failed", http.StatusBadGateway)
+		return
+	}
+
+	id, err := o.verify(rawIDToken, login.nonce, time.Now())
+	if err != nil {
+		o.log("[WARN] ID token rejected from %s: %v", server.ClientIP(r), err)
+		o.config.Audit.RecordRequest(r, audit.Event{Action: "auth.login", Result: audit.Failure, Detail: "oidc: " + err.Error()})
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+
+	o.sessions.Create(w, r, *id)
+	o.log("Login %s (%s) from %s", id.Username, id.Source, server.ClientIP(r))
+	o.config.Audit.RecordRequest(r, audit.Event{User: id.Username, Action: "auth.login", Detail: id.Source})
+	http.Redirect(w, r, login.redirect, http.StatusFound)
+}
+
+func (o *OIDC) discover() (*oidcDiscovery, error) {
+	o.mu.Lock()
+	cached := o.discovery
+	o.mu.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := o.getJSON(o.config.Issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != o.config.Issuer {
+		return nil, fmt.Errorf("issuer mismatch: provider reports %q", discovery.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("discovery document is missing endpoints")
+	}
+
+	o.mu.Lock()
+	o.discovery = &discovery
+	o.mu.Unlock()
+	return &discovery, nil
+}
+
+func (o *OIDC) exchange(code, verifier string) (string, error) {
+	if code == "" {
+		return "", errors.New("missing code")
+	}
+	discovery, err := o.discover()
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.config.RedirectURL},
+		"code_verifier": {verifier},
+		"client_id":     {o.config.ClientID},
+	}
+	req, err := http.NewRequest(http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if o.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(o.config.ClientID), url.QueryEscape(o.config.ClientSecret))
+	}
+
+	resp, err := o.config.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return "", fmt.Errorf("token response: %w", err)
+	}
+	if token.Error != "" {
+		return "", fmt.Errorf("%s: %s", token.Error, token.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK || token.IDToken == "" {
+		return "", fmt.Errorf("token endpoint returned %s without id_token", resp.Status)
+	}
+	return token.IDToken, nil
+}
+
+// verify checks the ID token signature and standard claims, then maps
+// the claims to an identity.
+func (o *OIDC) verify(rawToken, nonce string, now time.Time) (*Identity, error) {
+	header, rawClaims, signed, signature, err := parseJWT(rawToken)
+	if err != nil {
+		return nil, err
+	}
+	key, err := o.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := key.publicKey()
+	if err != nil {
+		return nil, err
+	}
+	if key.Alg != "" && key.Alg != header.Alg {
+		return nil, fmt.Errorf("token algorithm %q does not match key", header.Alg)
+	}
+	if err := verifySignature(header.Alg, publicKey, signed, signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err := json.Unmarshal(rawClaims, &claims); err != nil {
+		return nil, fmt.Errorf("token claims: %w", err)
+	}
+
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != o.config.Issuer {
+		return nil, fmt.Errorf("unexpected issuer %q", iss)
+	}
+	audiences := stringList(claims["aud"])
+	if !slices.Contains(audiences, o.config.ClientID) {
+		return nil, errors.New("token was not issued for this client")
+	}
+	if azp, ok := claims["azp"].(string); (ok || len(audiences) > 1) && azp != o.config.ClientID {
+		return nil, errors.New("token authorized party mismatch")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(oidcClockSkew)) {
+		return nil, errors.New("token expired")
+	}
+	if iat, ok := claims["iat"].(float64); ok && time.Unix(int64(iat), 0).After(now.Add(oidcClockSkew)) {
+		return nil, errors.New("token issued in the future")
+	}
+	if claimNonce, _ := claims["nonce"].(string); claimNonce != nonce {
+		return nil, errors.New("nonce mismatch")
+	}
+
+	username, _ := claims[o.config.UsernameClaim].(string)
+	if o.config.StripDomain {
+		username, _, _ = strings.Cut(username, "@")
+	}
+	if username == "" {
+		return nil, fmt.Errorf("claim %q is missing", o.config.UsernameClaim)
+	}
+
+	return &Identity{Username: username, Source: "oidc", Roles: o.roles(claims)}, nil
+}
+
+func (o *OIDC) roles(claims map[string]any) []string {
+	var roles []string
+	if o.config.GroupsClaim != "" {
+		for _, group := range stringList(claims[o.config.GroupsClaim]) {
+			if role, ok := o.config.GroupRoles[group]; ok && !slices.Contains(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+	}
+	if len(roles) == 0 {
+		return slices.Clone(o.config.DefaultRoles)
+	}
+	slices.Sort(roles)
+	return roles
+}
+
+// key returns the signing key for kid, refetching the key set when the
+// provider has rotated keys.
+func (o *OIDC) key(kid string) (jwk, error) {
+	o.mu.Lock()
+	key, ok := o.lookupKey(kid)
+	stale := time.Since(o.keysFetched) > oidcKeysMinAge
+	o.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !stale {
+		return jwk{}, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	discovery, err := o.discover()
+	if err != nil {
+		return jwk{}, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := o.getJSON(discovery.JWKSURI, &set); err != nil {
+		return jwk{}, fmt.Errorf("fetch keys: %w", err)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.keys = make(map[string]jwk)
+	for _, k := range set.Keys {
+		if k.Use == "" || k.Use == "sig" {
+			o.keys[k.Kid] = k
+		}
+	}
+	o.keysFetched = time.Now()
+
+	if key, ok := o.lookupKey(kid); ok {
+		return key, nil
+	}
+	return jwk{}, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookupKey must be called with o.mu held. Tokens without a key ID are
+// accepted only when the provider publishes a single key.
+func (o *OIDC) lookupKey(kid string) (jwk, bool) {
+	if kid == "" && len(o.keys) == 1 {
+		for _, key := range o.keys {
+			return key, true
+		}
+	}
+	key, ok := o.keys[kid]
+	return key, ok
+}
+
+func (o *OIDC) getJSON(target string, v any) error {
+	resp, err := o.config.HTTPClient.Get(target)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", target, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+func (o *OIDC) log(format string, args ...any) {
+	if o.logf != nil {
+		o.logf("[Auth] OIDC: "+format, args...)
+	}
+}
+
+func randomToken() string {
+	raw := make([]byte, 32)
+	rand.Read(raw)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// localRedirect only allows paths on this server, so the login flow cannot
+// be abused as an open redirect.
+func localRedirect(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.Contains(target, "\\") {
+		return "/"
+	}
+	return target
+}
+
+// stringList reads a claim that may be a single string or an array.
+func stringList(claim any) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var list []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
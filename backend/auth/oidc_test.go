@@ -0,0 +1,487 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testClientID = "labmd"
+
+// mockIdP is a local OpenID provider serving discovery, the key set and a
+// token endpoint that checks PKCE like a real one.
+type mockIdP struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu    sync.Mutex
+	keys  map[string]crypto.Signer // kid -> key
+	codes map[string]authorization
+}
+
+type authorization struct {
+	challenge   string
+	nonce       string
+	redirectURI string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	idp := &mockIdP{
+		t:     t,
+		keys:  map[string]crypto.Signer{"rsa-1": newRSAKey(t)},
+		codes: map[string]authorization{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                idp.server.URL,
+			AuthorizationEndpoint: idp.server.URL + "/authorize",
+			TokenEndpoint:         idp.server.URL + "/token",
+			JWKSURI:               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", idp.serveKeys)
+	mux.HandleFunc("/token", idp.serveToken)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func (idp *mockIdP) serveKeys(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	for kid, key := range idp.keys {
+		// No "alg", so the signature check itself has to reject a
+		// mismatched algorithm
+		switch pub := key.Public().(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, jwk{
+				Kty: "RSA", Kid: kid, Use: "sig",
+				N: b64(pub.N.Bytes()), E: b64(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case *ecdsa.PublicKey:
+			set.Keys = append(set.Keys, jwk{
+				Kty: "EC", Kid: kid, Use: "sig", Crv: "P-256",
+				X: b64(pub.X.FillBytes(make([]byte, 32))), Y: b64(pub.Y.FillBytes(make([]byte, 32))),
+			})
+		}
+	}
+	json.NewEncoder(w).Encode(set)
+}
+
+// authorize stands in for the provider's login page: it accepts the
+// browser's authorization request and returns the code it would redirect
+// back with.
+func (idp *mockIdP) authorize(query url.Values) string {
+	idp.t.Helper()
+	if query.Get("response_type") != "code" || query.Get("client_id") != testClientID {
+		idp.t.Fatalf("authorization request = %v", query)
+	}
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		idp.t.Fatalf("authorization request without PKCE: %v", query)
+	}
+	if !slices.Contains(strings.Fields(query.Get("scope")), "openid") {
+		idp.t.Fatalf("scope %q lacks openid", query.Get("scope"))
+	}
+
+	code := randomToken()
+	idp.mu.Lock()
+	idp.codes[code] = authorization{
+		challenge:   query.Get("code_challenge"),
+		nonce:       query.Get("nonce"),
+		redirectURI: query.Get("redirect_uri"),
+	}
+	idp.mu.Unlock()
+	return code
+}
+
+func (idp *mockIdP) serveToken(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	idp.mu.Lock()
+	auth, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case !ok, r.PostForm.Get("grant_type") != "authorization_code":
+		tokenError(w, "invalid_grant")
+		return
+	case b64(challenge[:]) != auth.challenge:
+		tokenError(w, "invalid_grant") // PKCE verifier does not match
+		return
+	case r.PostForm.Get("redirect_uri") != auth.redirectURI:
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	claims := idp.validClaims(auth.nonce)
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "opaque",
+		"token_type":   "Bearer",
+		"id_token":     idp.sign("rsa-1", "RS256", claims),
+	})
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+func (idp *mockIdP) validClaims(nonce string) map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":                idp.server.URL,
+		"sub":                "248289761001",
+		"aud":                testClientID,
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"iat":                now.Unix(),
+		"nonce":              nonce,
+		"preferred_username": "alice",
+		"groups":             []string{"lab-admins", "library"},
+	}
+}
+
+// sign issues a compact JWS with the key kid.
+func (idp *mockIdP) sign(kid, alg string, claims map[string]any) string {
+	idp.t.Helper()
+	idp.mu.Lock()
+	key := idp.keys[kid]
+	idp.mu.Unlock()
+
+	signed := encodeSegment(idp.t, jwtHeader{Alg: alg, Kid: kid}) + "." + encodeSegment(idp.t, claims)
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	var err error
+	switch alg {
+	case "RS256":
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:])
+	case "PS256":
+		signature, err = rsa.SignPSS(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:], nil)
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), digest[:])
+		if err == nil {
+			signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	default:
+		idp.t.Fatalf("sign: unsupported algorithm %s", alg)
+	}
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	return signed + "." + b64(signature)
+}
+
+func encodeSegment(t *testing.T, v any) string {
+	t.Helper()
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b64(raw)
+}
+
+func b64(raw []byte) string {
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func newTestOIDC(t *testing.T, idp *mockIdP) (*OIDC, *Sessions) {
+	t.Helper()
+	sessions := NewSessions(time.Hour, false)
+	o, err := NewOIDC(OIDCConfig{
+		Issuer:       idp.server.URL,
+		ClientID:     testClientID,
+		RedirectURL:  "https://labmd.example/api/auth/oidc/callback",
+		GroupsClaim:  "groups",
+		GroupRoles:   map[string]string{"lab-admins": "admin"},
+		DefaultRoles: []string{"viewer"},
+		HTTPClient:   idp.server.Client(),
+	}, sessions, t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o, sessions
+}
+
+// startLogin runs LoginHandler and returns the authorization request and
+// the state cookie it set.
+func startLogin(t *testing.T, o *OIDC, redirect string) (url.Values, *http.Cookie) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	o.LoginHandler(rec, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/login?redirect="+url.QueryEscape(redirect), nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login: status %d: %s", rec.Code, rec.Body)
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == oidcStateCookie {
+			return location.Query(), cookie
+		}
+	}
+	t.Fatal("login set no state cookie")
+	return nil, nil
+}
+
+func callback(o *OIDC, query url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/callback?"+query.Encode(), nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	o.CallbackHandler(rec, req)
+	return rec
+}
+
+func TestOIDCLoginRoundTrip(t *testing.T) {
+	idp := newMockIdP(t)
+	o, sessions := newTestOIDC(t, idp)
+
+	authRequest, stateCookie := startLogin(t, o, "/docs?doc=pcr.md")
+	if authRequest.Get("state") != stateCookie.Value {
+		t.Fatalf("state %q differs from its cookie %q", authRequest.Get("state"), stateCookie.Value)
+	}
+	code := idp.authorize(authRequest)
+
+	rec := callback(o, url.Values{"code": {code}, "state": {authRequest.Get("state")}}, stateCookie)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/docs?doc=pcr.md" {
+		t.Fatalf("callback: status %d, location %q: %s", rec.Code, rec.Header().Get("Location"), rec.Body)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range rec.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	id := sessions.Lookup(req)
+	if id == nil {
+		t.Fatal("callback started no session")
+	}
+	if id.Username != "alice" || id.Source != "oidc" || !slices.Equal(id.Roles, []string{"admin"}) {
+		t.Errorf("identity = %+v", id)
+	}
+
+	// The state is used up
+	if rec := callback(o, url.Values{"code": {code}, "state": {authRequest.Get("state")}}, stateCookie); rec.Code != http.StatusBadRequest {
+		t.Errorf("replayed callback: status %d, want 400", rec.Code)
+	}
+}
+
+func TestOIDCCallbackState(t *testing.T) {
+	idp := newMockIdP(t)
+	o, _ := newTestOIDC(t, idp)
+
+	authRequest, stateCookie := startLogin(t, o, "/")
+	code := idp.authorize(authRequest)
+
+	tests := []struct {
+		name   string
+		state  string
+		cookie *http.Cookie
+	}{
+		{"no cookie", authRequest.Get("state"), nil},
+		{"state of another browser", "forged", &http.Cookie{Name: oidcStateCookie, Value: "forged"}},
+		{"cookie and state differ", authRequest.Get("state"), &http.Cookie{Name: oidcStateCookie, Value: "other"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if rec := callback(o, url.Values{"code": {code}, "state": {test.state}}, test.cookie); rec.Code != http.StatusBadRequest {
+				t.Errorf("status %d, want 400", rec.Code)
+			}
+		})
+	}
+
+	// The login is still pending after the rejected attempts
+	if rec := callback(o, url.Values{"code": {code}, "state": {authRequest.Get("state")}}, stateCookie); rec.Code != http.StatusFound {
+		t.Errorf("genuine callback: status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestOIDCCallbackPKCE(t *testing.T) {
+	idp := newMockIdP(t)
+	o, _ := newTestOIDC(t, idp)
+
+	authRequest, stateCookie := startLogin(t, o, "/")
+	// An attacker's code, issued for a different challenge
+	authRequest.Set("code_challenge", b64(make([]byte, 32)))
+	code := idp.authorize(authRequest)
+
+	if rec := callback(o, url.Values{"code": {code}, "state": {authRequest.Get("state")}}, stateCookie); rec.Code != http.StatusBadGateway {
+		t.Errorf("status %d, want 502 for a refused code exchange", rec.Code)
+	}
+}
+
+func TestOIDCVerify(t *testing.T) {
+	idp := newMockIdP(t)
+	o, _ := newTestOIDC(t, idp)
+	const nonce = "n-0S6_WzA2Mj"
+
+	hmacToken := func(claims map[string]any) string {
+		signed := encodeSegment(t, jwtHeader{Alg: "HS256", Kid: "rsa-1"}) + "." + encodeSegment(t, claims)
+		mac := hmac.New(sha256.New, []byte("guessable"))
+		mac.Write([]byte(signed))
+		return signed + "." + b64(mac.Sum(nil))
+	}
+
+	tests := []struct {
+		name    string
+		token   func(claims map[string]any) string
+		edit    func(claims map[string]any)
+		wantErr string
+	}{
+		{name: "valid"},
+		{name: "multi-aud with azp", edit: func(c map[string]any) { c["aud"] = []string{testClientID, "other"}; c["azp"] = testClientID }},
+		{
+			name: "bad signature",
+			token: func(claims map[string]any) string {
+				token := idp.sign("rsa-1", "RS256", claims)
+				claims["preferred_username"] = "root"
+				parts := strings.Split(token, ".")
+				return parts[0] + "." + encodeSegment(t, claims) + "." + parts[2]
+			},
+			wantErr: "verification error",
+		},
+		{name: "wrong aud", edit: func(c map[string]any) { c["aud"] = "another-client" }, wantErr: "not issued for this client"},
+		{name: "multi-aud without azp", edit: func(c map[string]any) { c["aud"] = []string{testClientID, "other"} }, wantErr: "authorized party"},
+		{name: "foreign azp", edit: func(c map[string]any) { c["azp"] = "other" }, wantErr: "authorized party"},
+		{name: "nonce mismatch", edit: func(c map[string]any) { c["nonce"] = "replayed" }, wantErr: "nonce mismatch"},
+		{name: "missing nonce", edit: func(c map[string]any) { delete(c, "nonce") }, wantErr: "nonce mismatch"},
+		{name: "expired", edit: func(c map[string]any) { c["exp"] = time.Now().Add(-2 * oidcClockSkew).Unix() }, wantErr: "expired"},
+		{name: "no exp", edit: func(c map[string]any) { delete(c, "exp") }, wantErr: "expired"},
+		{name: "issued in the future", edit: func(c map[string]any) { c["iat"] = time.Now().Add(2 * oidcClockSkew).Unix() }, wantErr: "future"},
+		{name: "wrong issuer", edit: func(c map[string]any) { c["iss"] = "https://evil.example" }, wantErr: "unexpected issuer"},
+		{name: "no username", edit: func(c map[string]any) { delete(c, "preferred_username") }, wantErr: "missing"},
+		{
+			name: "alg none",
+			token: func(claims map[string]any) string {
+				return encodeSegment(t, jwtHeader{Alg: "none", Kid: "rsa-1"}) + "." + encodeSegment(t, claims) + "."
+			},
+			wantErr: "unsupported algorithm",
+		},
+		{name: "HS256", token: hmacToken, wantErr: "unsupported algorithm"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := idp.validClaims(nonce)
+			if test.edit != nil {
+				test.edit(claims)
+			}
+			token := ""
+			if test.token != nil {
+				token = test.token(claims)
+			} else {
+				token = idp.sign("rsa-1", "RS256", claims)
+			}
+
+			id, err := o.verify(token, nonce, time.Now())
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("verify: %v", err)
+				}
+				if id.Username != "alice" {
+					t.Errorf("username = %q", id.Username)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("err = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestOIDCVerifyAlgorithms(t *testing.T) {
+	idp := newMockIdP(t)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.keys["ec-1"] = ecKey
+	o, _ := newTestOIDC(t, idp)
+
+	for _, test := range []struct{ kid, alg string }{{"rsa-1", "RS256"}, {"rsa-1", "PS256"}, {"ec-1", "ES256"}} {
+		if _, err := o.verify(idp.sign(test.kid, test.alg, idp.validClaims("n")), "n", time.Now()); err != nil {
+			t.Errorf("%s: %v", test.alg, err)
+		}
+	}
+
+	// An RSA key cannot vouch for an ES256 token and vice versa
+	token := idp.sign("ec-1", "ES256", idp.validClaims("n"))
+	parts := strings.Split(token, ".")
+	token = encodeSegment(t, jwtHeader{Alg: "ES256", Kid: "rsa-1"}) + "." + parts[1] + "." + parts[2]
+	if _, err := o.verify(token, "n", time.Now()); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("ES256 token with an RSA key: err = %v", err)
+	}
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	idp := newMockIdP(t)
+	o, _ := newTestOIDC(t, idp)
+
+	if _, err := o.verify(idp.sign("rsa-1", "RS256", idp.validClaims("n")), "n", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The provider rotates to a new key
+	idp.mu.Lock()
+	idp.keys = map[string]crypto.Signer{"rsa-2": newRSAKey(t)}
+	idp.mu.Unlock()
+	token := idp.sign("rsa-2", "RS256", idp.validClaims("n"))
+
+	// Right after a fetch, unknown key IDs do not trigger another one
+	if _, err := o.verify(token, "n", time.Now()); err == nil || !strings.Contains(err.Error(), "unknown signing key") {
+		t.Fatalf("err = %v, want unknown signing key before the refetch limit", err)
+	}
+
+	o.mu.Lock()
+	o.keysFetched = time.Now().Add(-2 * oidcKeysMinAge)
+	o.mu.Unlock()
+	if _, err := o.verify(token, "n", time.Now()); err != nil {
+		t.Fatalf("after rotation: %v", err)
+	}
+}
+
+func TestLocalRedirect(t *testing.T) {
+	for target, want := range map[string]string{
+		"/docs?doc=a.md":       "/docs?doc=a.md",
+		"":                     "/",
+		"https://evil.example": "/",
+		"//evil.example":       "/",
+		"/\\evil.example":      "/",
+	} {
+		if got := localRedirect(target); got != want {
+			t.Errorf("localRedirect(%q) = %q, want %q", target, got, want)
+		}
+	}
+}
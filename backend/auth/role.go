@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Role orders what a requester may see. Higher roles include everything
+// lower roles may do.
+type Role int
+
+const (
+	RoleAnonymous Role = iota // Aggregate stats only
+	RoleViewer                // Signed in, aggregate stats only
+	RoleMember                // Docs, reservations and own disk/GPU/Slurm details
+	RoleAdmin                 // Per-user tables, process owners, configuration
+)
+
+var roleNames = []string{"anonymous", "viewer", "member", "admin"}
+
+func (r Role) String() string {
+	if r < RoleAnonymous || int(r) >= len(roleNames) {
+		return fmt.Sprintf("role(%d)", int(r))
+	}
+	return roleNames[r]
+}
+
+func ParseRole(name string) (Role, error) {
+	i := slices.Index(roleNames, strings.ToLower(strings.TrimSpace(name)))
+	if i < 0 {
+		return RoleAnonymous, fmt.Errorf("unknown role %q (use viewer, member or admin)", name)
+	}
+	return Role(i), nil
+}
+
+type roleKey struct{}
+
+func WithRole(ctx context.Context, role Role) context.Context {
+	return context.WithValue(ctx, roleKey{}, role)
+}
+
+// RoleFrom returns the role attached by the auth middleware. Requests that
+// never passed through it are anonymous.
+func RoleFrom(ctx context.Context) Role {
+	role, _ := ctx.Value(roleKey{}).(Role)
+	return role
+}
+
+// Open grants every request the admin role. It stands in for the auth
+// middleware when authentication is disabled, keeping the server as open
+// as it was before roles existed.
+func Open(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(WithRole(r.Context(), RoleAdmin)))
+	})
+}
+
+type scopesKey struct{}
+
+func WithScopes(ctx context.Context, scopes []Scope) context.Context {
+	return context.WithValue(ctx, scopesKey{}, scopes)
+}
+
+// HasScope reports whether a request may act within scope. Only requests
+// authenticated by an API token are limited by scopes.
+func HasScope(ctx context.Context, scope Scope) bool {
+	scopes, ok := ctx.Value(scopesKey{}).([]Scope)
+	if !ok || scope == "" {
+		return true
+	}
+	return slices.Contains(scopes, scope) || slices.Contains(scopes, ScopeAdmin) ||
+		scope == ScopeReadDocs && slices.Contains(scopes, ScopeWriteDocs)
+}
+
+// Require rejects requests below min: anonymous users get 401 so the
+// frontend can offer a login, signed-in users 403. API token requests
+// also need scope; an empty scope admits every token.
+func Require(min Role, scope Scope, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role := RoleFrom(r.Context())
+		switch {
+		case role < min && role == RoleAnonymous:
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+		case role < min:
+			http.Error(w, "Forbidden: requires "+min.String()+" role", http.StatusForbidden)
+		case !HasScope(r.Context(), scope):
+			http.Error(w, "Forbidden: token lacks "+string(scope)+" scope", http.StatusForbidden)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// RoleOf resolves the effective role of an identity: an explicit per-user
+// assignment wins, then the highest role mapped by the authenticator, then
+// the configured default.
+func (s *Service) RoleOf(id *Identity) Role {
+	if id == nil {
+		return RoleAnonymous
+	}
+	if role, ok := s.config.UserRoles[id.Username]; ok {
+		return role
+	}
+
+	best, found := RoleAnonymous, false
+	for _, name := range id.Roles {
+		if role, err := ParseRole(name); err == nil && role > best {
+			best, found = role, true
+		}
+	}
+	if found {
+		return best
+	}
+	return s.config.DefaultRole
+}
@@ -0,0 +1,415 @@
+package auth
+
+import (
+	"LabMD-backend/audit"
+	"LabMD-backend/server"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ProtectAll = "all"
+
+	maxLoginFailures = 10
+	loginWindow      = 15 * time.Minute
+	// How long a token owner's looked-up roles are reused
+	tokenOwnerTTL = time.Minute
+)
+
+type Config struct {
+	Authenticators []Authenticator
+	Sessions       *Sessions
+	// Protect lists the routes that require a login: "all" for every API
+	// and raw docs route, or paths where a trailing "/" or "*" matches a
+	// prefix, e.g. "/api/docs/*".
+	Protect []string
+	// UserRoles assigns roles to individual users; everyone else gets the
+	// highest role their authenticator mapped, or DefaultRole.
+	UserRoles   map[string]Role
+	DefaultRole Role
+	Tokens      *Tokens // API tokens accepted as "Authorization: Bearer"; nil disables them
+	Audit       *audit.Log
+	Logf        func(string, ...any)
+}
+
+type Service struct {
+	config Config
+
+	mu          sync.Mutex
+	failures    map[string][]time.Time
+	tokenOwners map[string]tokenOwner // By source and username
+}
+
+type tokenOwner struct {
+	id      *Identity
+	expires time.Time
+}
+
+func NewService(config Config) *Service {
+	return &Service{
+		config:      config,
+		failures:    make(map[string][]time.Time),
+		tokenOwners: make(map[string]tokenOwner),
+	}
+}
+
+// Tokens returns the API token store, or nil when tokens are unavailable.
+func (s *Service) Tokens() *Tokens {
+	return s.config.Tokens
+}
+
+// Middleware attaches the identity and role from an API token or the
+// session to every request and rejects anonymous requests to protected
+// routes. An invalid bearer token is rejected outright rather than
+// treated as anonymous.
+func (s *Service) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var id *Identity
+
+		if secret, ok := bearerToken(r); ok {
+			token, err := s.authenticateToken(r, secret)
+			if err == nil {
+				id, err = s.tokenIdentity(r, token)
+			}
+			switch {
+			case errors.Is(err, ErrInvalidCredentials):
+				w.Header().Set("WWW-Authenticate", `Bearer realm="labmd"`)
+				http.Error(w, "Invalid or expired API token", http.StatusUnauthorized)
+				return
+			case err != nil:
+				s.log("[WARN] Cannot resolve owner of token %s: %v", token.ID, err)
+				http.Error(w, "Cannot verify the token owner, try again later", http.StatusServiceUnavailable)
+				return
+			}
+			ctx = WithScopes(ctx, token.Scopes)
+		} else {
+			id = s.config.Sessions.Lookup(r)
+		}
+
+		ctx = WithRole(ctx, s.RoleOf(id))
+		if id != nil {
+			ctx = WithIdentity(ctx, id)
+		}
+		r = r.WithContext(ctx)
+
+		if id == nil && s.Requires(r.URL.Path) {
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Requires reports whether path needs an authenticated user. The login
+// endpoints and the frontend shell are always public so users can sign in.
+func (s *Service) Requires(path string) bool {
+	if strings.HasPrefix(path, "/api/auth/") {
+		return false
+	}
+
+	for _, pattern := range s.config.Protect {
+		switch {
+		case pattern == ProtectAll:
+			if strings.HasPrefix(path, "/api/") && path != "/api/config" ||
+				strings.HasPrefix(path, "/raw/") || path == "/metrics" {
+				return true
+			}
+		case strings.HasSuffix(pattern, "*"):
+			if strings.HasPrefix(path, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		case strings.HasSuffix(pattern, "/"):
+			if strings.HasPrefix(path, pattern) {
+				return true
+			}
+		case path == pattern:
+			return true
+		}
+	}
+	return false
+}
+
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (s *Service) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ip := server.ClientIP(r)
+	if !s.allowLogin(ip, time.Now()) {
+		s.config.Audit.RecordRequest(r, audit.Event{Action: "auth.login", Result: audit.Denied, Detail: "too many failed logins"})
+		http.Error(w, "Too many failed logins, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	var req loginRequest
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	} else {
+		req.Username = r.PostFormValue("username")
+		req.Password = r.PostFormValue("password")
+	}
+
+	id, err := s.authenticate(req.Username, req.Password)
+	if err != nil {
+		s.recordFailure(ip, time.Now())
+		s.log("Login failed for %q from %s", req.Username, ip)
+		s.config.Audit.RecordRequest(r, audit.Event{User: req.Username, Action: "auth.login", Result: audit.Failure})
+		http.Error(w, ErrInvalidCredentials.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	s.config.Sessions.Create(w, r, *id)
+	s.log("Login %s (%s) from %s", id.Username, id.Source, ip)
+	s.config.Audit.RecordRequest(r, audit.Event{User: id.Username, Action: "auth.login", Detail: id.Source})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(id)
+}
+
+func (s *Service) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if id := s.config.Sessions.Lookup(r); id != nil {
+		s.log("Logout %s from %s", id.Username, server.ClientIP(r))
+		s.config.Audit.RecordRequest(r, audit.Event{User: id.Username, Action: "auth.logout"})
+	}
+	s.config.Sessions.Destroy(w, r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MeHandler reports the current user and role so the frontend can decide
+// whether to show the login form. loginRequired tells it whether anonymous
+// visitors may see the dashboard at all.
+func (s *Service) MeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := IdentityFrom(r.Context())
+	json.NewEncoder(w).Encode(struct {
+		Authenticated bool      `json:"authenticated"`
+		User          *Identity `json:"user,omitempty"`
+		Role          string    `json:"role"`
+		LoginRequired bool      `json:"loginRequired"`
+	}{id != nil, id, RoleFrom(r.Context()).String(), s.Requires("/api/stats")})
+}
+
+type createTokenRequest struct {
+	Name          string  `json:"name"`
+	Scopes        []Scope `json:"scopes"`
+	ExpiresInDays int     `json:"expiresInDays"`
+}
+
+// TokensHandler lets signed-in users list (GET, admins may add ?all=1),
+// create (POST) and revoke (DELETE ?id=) their API tokens.
+func (s *Service) TokensHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := IdentityFrom(r.Context())
+	isAdmin := RoleFrom(r.Context()) >= RoleAdmin
+	if id == nil || s.config.Tokens == nil {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		user := id.Username
+		if isAdmin && r.URL.Query().Get("all") == "1" {
+			user = ""
+		}
+		json.NewEncoder(w).Encode(s.config.Tokens.List(user))
+
+	case http.MethodPost:
+		var req createTokenRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if slices.Contains(req.Scopes, ScopeAdmin) && !isAdmin {
+			s.config.Audit.RecordRequest(r, audit.Event{User: id.Username, Action: "token.create", Target: req.Name, Result: audit.Denied, Detail: "admin scope"})
+			http.Error(w, "Only admins may create admin tokens", http.StatusForbidden)
+			return
+		}
+
+		life := time.Duration(req.ExpiresInDays) * 24 * time.Hour
+		token, secret, err := s.config.Tokens.Create(*id, req.Name, req.Scopes, life, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.log("Token %s (%s) created for %s", token.ID, token.Name, token.User)
+		s.config.Audit.RecordRequest(r, audit.Event{
+			User:   id.Username,
+			Action: "token.create",
+			Target: token.ID,
+			Detail: fmt.Sprintf("%s, scopes %v, expires %s", token.Name, token.Scopes, token.Expires.Format(time.DateOnly)),
+		})
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(struct {
+			Token
+			Secret string `json:"secret"`
+		}{token, secret})
+
+	case http.MethodDelete:
+		tokenID := r.URL.Query().Get("id")
+		err := s.config.Tokens.Revoke(tokenID, id.Username, isAdmin)
+		switch {
+		case errors.Is(err, ErrTokenNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case err != nil:
+			http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+		default:
+			s.log("Token %s revoked by %s", tokenID, id.Username)
+			s.config.Audit.RecordRequest(r, audit.Event{User: id.Username, Action: "token.revoke", Target: tokenID})
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Service) authenticate(username, password string) (*Identity, error) {
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	for _, authenticator := range s.config.Authenticators {
+		id, err := authenticator.Authenticate(username, password)
+		if err == nil {
+			return id, nil
+		}
+		if !errors.Is(err, ErrInvalidCredentials) {
+			s.log("[WARN] %s authenticator error: %v", authenticator.Name(), err)
+		}
+	}
+	return nil, ErrInvalidCredentials
+}
+
+func (s *Service) authenticateToken(r *http.Request, secret string) (Token, error) {
+	ip := server.ClientIP(r)
+	if s.config.Tokens == nil || !s.allowLogin(ip, time.Now()) {
+		return Token{}, ErrInvalidCredentials
+	}
+
+	token, err := s.config.Tokens.Authenticate(secret, time.Now())
+	if err != nil {
+		s.recordFailure(ip, time.Now())
+		s.log("Invalid API token from %s", ip)
+		s.config.Audit.RecordRequest(r, audit.Event{Action: "auth.token", Result: audit.Failure})
+	}
+	return token, err
+}
+
+// tokenIdentity resolves the owner of token through the authenticator that
+// verified them, so a token follows its owner's current roles. A token
+// whose owner no longer resolves is revoked. Authenticators without
+// lookup, such as OIDC, give the owner only their userRoles entry or
+// DefaultRole.
+func (s *Service) tokenIdentity(r *http.Request, token Token) (*Identity, error) {
+	owner := &Identity{Username: token.User}
+	var lookup UserLookup
+	for _, authenticator := range s.config.Authenticators {
+		if l, ok := authenticator.(UserLookup); ok && authenticator.Name() == token.Source {
+			lookup = l
+		}
+	}
+	if lookup != nil {
+		var err error
+		owner, err = s.lookupTokenOwner(lookup, token, time.Now())
+		if errors.Is(err, ErrInvalidCredentials) {
+			s.log("Owner %s of token %s no longer exists, revoking it", token.User, token.ID)
+			s.config.Tokens.Revoke(token.ID, "", true)
+			s.config.Audit.RecordRequest(r, audit.Event{User: token.User, Action: "token.revoke", Target: token.ID, Detail: "owner not found"})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Identity{Username: token.User, Source: "token", Roles: owner.Roles}, nil
+}
+
+func (s *Service) lookupTokenOwner(lookup UserLookup, token Token, now time.Time) (*Identity, error) {
+	key := token.Source + "/" + token.User
+	s.mu.Lock()
+	cached, ok := s.tokenOwners[key]
+	s.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.id, nil
+	}
+
+	id, err := lookup.Lookup(token.User)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, owner := range s.tokenOwners {
+		if !now.Before(owner.expires) {
+			delete(s.tokenOwners, k)
+		}
+	}
+	s.tokenOwners[key] = tokenOwner{id: id, expires: now.Add(tokenOwnerTTL)}
+	return id, nil
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, secret, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(secret), true
+}
+
+func (s *Service) allowLogin(ip string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recent := s.failures[ip][:0]
+	for _, t := range s.failures[ip] {
+		if now.Sub(t) < loginWindow {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) == 0 {
+		delete(s.failures, ip)
+		return true
+	}
+	s.failures[ip] = recent
+	return len(recent) < maxLoginFailures
+}
+
+func (s *Service) recordFailure(ip string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[ip] = append(s.failures[ip], now)
+}
+
+func (s *Service) log(format string, args ...any) {
+	if s.config.Logf != nil {
+		s.config.Logf("[Auth] "+format, args...)
+	}
+}
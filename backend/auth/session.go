@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const SessionCookie = "labmd_session"
+
+type Session struct {
+	Identity Identity
+	Created  time.Time
+	Expires  time.Time
+}
+
+// Sessions is an in-memory session store keyed by the SHA-256 of the
+// cookie value, so a memory dump does not reveal usable cookies.
+type Sessions struct {
+	ttl    time.Duration
+	secure bool
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+func NewSessions(ttl time.Duration, secureCookie bool) *Sessions {
+	s := &Sessions{ttl: ttl, secure: secureCookie, sessions: make(map[string]*Session)}
+	go s.expireLoop()
+	return s
+}
+
+// Create starts a session for id and sets its cookie on w.
+func (s *Sessions) Create(w http.ResponseWriter, r *http.Request, id Identity) {
+	token := randomToken()
+
+	now := time.Now()
+	s.mu.Lock()
+	s.sessions[hashToken(token)] = &Session{Identity: id, Created: now, Expires: now.Add(s.ttl)}
+	s.mu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  now.Add(s.ttl),
+		HttpOnly: true,
+		Secure:   s.secure || r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Lookup returns the session identity of a request, or nil.
+func (s *Sessions) Lookup(r *http.Request) *Identity {
+	cookie, err := r.Cookie(SessionCookie)
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := hashToken(cookie.Value)
+	session, ok := s.sessions[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(session.Expires) {
+		delete(s.sessions, key)
+		return nil
+	}
+	id := session.Identity
+	return &id
+}
+
+// Destroy ends the request's session and clears its cookie.
+func (s *Sessions) Destroy(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(SessionCookie); err == nil {
+		s.mu.Lock()
+		delete(s.sessions, hashToken(cookie.Value))
+		s.mu.Unlock()
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.secure || r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (s *Sessions) expireLoop() {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		s.mu.Lock()
+		for key, session := range s.sessions {
+			if now.After(session.Expires) {
+				delete(s.sessions, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scope limits what a request authenticated by an API token may do. The
+// token owner's role still applies on top of it.
+type Scope string
+
+const (
+	ScopeReadStats    Scope = "read-stats" // Stats, Slurm, hub and metrics
+	ScopeReadDocs     Scope = "read-docs"
+	ScopeWriteDocs    Scope = "write-docs"   // Implies read-docs
+	ScopeReservations Scope = "reservations" // Book and cancel GPUs
+	ScopeAdmin        Scope = "admin"        // Implies every other scope
+
+	tokenPrefix        = "labmd_"
+	lastUsedSavePeriod = time.Minute
+)
+
+var (
+	AllScopes = []Scope{ScopeReadStats, ScopeReadDocs, ScopeWriteDocs, ScopeReservations, ScopeAdmin}
+
+	ErrTokenNotFound = errors.New("token not found")
+)
+
+type Token struct {
+	ID       string    `json:"id"`
+	User     string    `json:"user"`
+	Name     string    `json:"name"`
+	Scopes   []Scope   `json:"scopes"`
+	Source   string    `json:"source"` // Authenticator that verified the owner; resolves their roles on use
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires"`
+	LastUsed time.Time `json:"lastUsed,omitzero"`
+	Hash     string    `json:"hash,omitempty"` // SHA-256 of the secret, never sent to clients
+}
+
+// Tokens stores API tokens hashed in a JSON file. Last-used times are kept
+// in memory and written at most once a minute.
+type Tokens struct {
+	path    string
+	maxLife time.Duration
+
+	mu     sync.Mutex
+	tokens []*Token
+	dirty  bool
+}
+
+func OpenTokens(path string, maxLife time.Duration) (*Tokens, error) {
+	t := &Tokens{path: path, maxLife: maxLife}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &t.tokens); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+	}
+
+	go t.saveLoop()
+	return t, nil
+}
+
+// Create issues a token for id and returns it together with the secret,
+// which is not stored and cannot be shown again.
+func (t *Tokens) Create(id Identity, name string, scopes []Scope, life time.Duration, now time.Time) (Token, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len([]rune(name)) > 64 {
+		return Token{}, "", errors.New("token name must be 1-64 characters")
+	}
+	if len(scopes) == 0 {
+		return Token{}, "", errors.New("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(AllScopes, scope) {
+			return Token{}, "", fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	if life <= 0 || life > t.maxLife {
+		return Token{}, "", fmt.Errorf("expiry must be between 1 day and %d days", int(t.maxLife.Hours()/24))
+	}
+
+	rawID := make([]byte, 6)
+	rand.Read(rawID)
+	secret := tokenPrefix + randomToken()
+
+	token := &Token{
+		ID:      hex.EncodeToString(rawID),
+		User:    id.Username,
+		Name:    name,
+		Scopes:  slices.Compact(slices.Sorted(slices.Values(scopes))),
+		Source:  id.Source,
+		Created: now,
+		Expires: now.Add(life),
+		Hash:    hashToken(secret),
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.tokens = append(t.tokens, token)
+	if err := t.save(); err != nil {
+		t.tokens = t.tokens[:len(t.tokens)-1]
+		return Token{}, "", err
+	}
+	return token.public(), secret, nil
+}
+
+// Authenticate resolves a bearer secret to its token and records the use.
+func (t *Tokens) Authenticate(secret string, now time.Time) (Token, error) {
+	if !strings.HasPrefix(secret, tokenPrefix) {
+		return Token{}, ErrInvalidCredentials
+	}
+	hash := hashToken(secret)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, token := range t.tokens {
+		if token.Hash != hash {
+			continue
+		}
+		if !now.Before(token.Expires) {
+			return Token{}, ErrInvalidCredentials
+		}
+		token.LastUsed = now
+		t.dirty = true
+		return token.public(), nil
+	}
+	return Token{}, ErrInvalidCredentials
+}
+
+// List returns the tokens of user, or every token when user is empty.
+func (t *Tokens) List(user string) []Token {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	list := []Token{}
+	for _, token := range t.tokens {
+		if user == "" || token.User == user {
+			list = append(list, token.public())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.After(list[j].Created) })
+	return list
+}
+
+// Revoke deletes a token. Only its owner may revoke it unless force is set.
+func (t *Tokens) Revoke(id, user string, force bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, token := range t.tokens {
+		if token.ID != id {
+			continue
+		}
+		if token.User != user && !force {
+			return ErrTokenNotFound
+		}
+
+		previous := t.tokens
+		t.tokens = slices.Delete(slices.Clone(t.tokens), i, i+1)
+		if err := t.save(); err != nil {
+			t.tokens = previous
+			return err
+		}
+		return nil
+	}
+	return ErrTokenNotFound
+}
+
+func (t *Tokens) saveLoop() {
+	ticker := time.NewTicker(lastUsedSavePeriod)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		t.mu.Lock()
+		if t.dirty {
+			t.save()
+		}
+		t.mu.Unlock()
+	}
+}
+
+// save must be called with t.mu held.
+func (t *Tokens) save() error {
+	data, err := json.MarshalIndent(t.tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(t.path), 0o755); err != nil {
+		return err
+	}
+	tmp := t.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, t.path); err != nil {
+		return err
+	}
+	t.dirty = false
+	return nil
+}
+
+func (t *Token) public() Token {
+	token := *t
+	token.Hash = ""
+	return token
+}
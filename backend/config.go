@@ -1,23 +1,69 @@
 package main
 
 import (
+	"LabMD-backend/alert"
+	"LabMD-backend/docs"
+	"LabMD-backend/hub"
 	"encoding/json"
 	"log"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 )
 
 type Config struct {
-	ProjectName string `json:"projectName"`
-	LabName     string `json:"labName"`
-	Port        int    `json:"port"`       // Server port
-	DocsPath    string `json:"docsPath"`   // User configurable source doc
-	DocsDepth   int    `json:"docsDepth"`  // Max depth for docs tree
-	DefaultDoc  string `json:"defaultDoc"` // Default document to load as homepage
-	Version     string `json:"version"`    // LabMD version
-	Admin       struct {
+	ProjectName   string   `json:"projectName"`
+	LabName       string   `json:"labName"`
+	Port          int      `json:"port"`          // Server port, used when listen is empty
+	Listen        []string `json:"listen"`        // "host:port" or "unix:/path"; empty = all interfaces on port
+	SocketMode    string   `json:"socketMode"`    // Octal permissions of unix sockets
+	SocketGroup   string   `json:"socketGroup"`   // Group owning unix sockets
+	DocsPath      string   `json:"docsPath"`      // User configurable source doc
+	DocsDepth     int      `json:"docsDepth"`     // Max depth for docs tree
+	DefaultDoc    string   `json:"defaultDoc"`    // Default document to load as homepage
+	DocsEditable  bool     `json:"docsEditable"`  // Let signed-in members edit docs (needs auth)
+	DocsUnixUsers bool     `json:"docsUnixUsers"` // Web users are the Unix accounts of the same name
+	DocsResyncSec int      `json:"docsResyncSec"` // Full rescan of the cached docs tree
+	DataDir       string   `json:"dataDir"`       // Persistent server state (spool, databases)
+	Version       string   `json:"version"`       // LabMD version
+	Admin         struct {
 		Name  string `json:"name"`
 		Email string `json:"email"`
 	} `json:"admin"`
+	TLS struct {
+		Enabled               bool     `json:"enabled"`
+		CertFile              string   `json:"certFile"` // PEM, reloaded when it changes
+		KeyFile               string   `json:"keyFile"`
+		SelfSigned            bool     `json:"selfSigned"`      // Generate a certificate when the files are missing
+		SelfSignedHosts       []string `json:"selfSignedHosts"` // Extra DNS names or IPs for the generated certificate
+		RedirectPort          int      `json:"redirectPort"`    // Plain HTTP port redirecting to HTTPS (0 = off)
+		HSTSMaxAgeSec         int      `json:"hstsMaxAgeSec"`   // 0 = no HSTS header
+		HSTSIncludeSubdomains bool     `json:"hstsIncludeSubdomains"`
+	} `json:"tls"`
+	Helper struct {
+		Socket       string   `json:"socket"`       // Root helper used when the server runs unprivileged ("" = scan in-process)
+		SocketGroup  string   `json:"socketGroup"`  // Group of the helper socket when not socket-activated
+		AllowedUsers []string `json:"allowedUsers"` // Users the helper answers besides root
+	} `json:"helper"`
+	HTTP struct {
+		CORSOrigins           []string `json:"corsOrigins"` // Other sites allowed to call the API ("*" = any, without cookies)
+		ContentSecurityPolicy string   `json:"contentSecurityPolicy"`
+		FrameOptions          string   `json:"frameOptions"`    // "DENY", "SAMEORIGIN" or "" to omit
+		RateLimitPerSec       float64  `json:"rateLimitPerSec"` // Per client IP (0 = off)
+		RateLimitBurst        int      `json:"rateLimitBurst"`
+		TrustedProxies        []string `json:"trustedProxies"` // IPs, CIDRs or "unix" whose X-Forwarded-For names the client
+		AccessLog             struct {
+			Enabled bool   `json:"enabled"`
+			Path    string `json:"path"`   // "" = stderr with the server log
+			Format  string `json:"format"` // "text" or "json"
+		} `json:"accessLog"`
+	} `json:"http"`
+	Debug struct {
+		Enabled     bool   `json:"enabled"`     // pprof profiles and expvar runtime stats
+		Listen      string `json:"listen"`      // Separate debug listener ("" = none)
+		AdminRoutes bool   `json:"adminRoutes"` // Also serve /debug/ to admins on the main port
+	} `json:"debug"`
 	Monitor struct {
 		IntervalCRG     int     `json:"intervalCRGSec"`     // CPU, RAM, GPU (seconds)
 		IntervalDisk    float64 `json:"intervalDiskHours"`  // Disk (hours)
@@ -41,21 +87,152 @@ type Config struct {
 		HistoryIntervalMin   int  `json:"historyIntervalMin"`
 		HistoryRetentionHour int  `json:"historyRetentionHour"`
 	} `json:"slurm"`
+	Metrics struct {
+		Enabled bool `json:"enabled"` // Expose Prometheus metrics at /metrics
+	} `json:"metrics"`
+	Exporter struct {
+		Enabled     bool              `json:"enabled"`
+		Format      string            `json:"format"` // "influx" or "otlp"
+		URL         string            `json:"url"`
+		Headers     map[string]string `json:"headers"` // e.g. Authorization tokens
+		IntervalSec int               `json:"intervalSec"`
+		BatchSize   int               `json:"batchSize"`
+		TimeoutSec  int               `json:"timeoutSec"`
+		MaxRetries  int               `json:"maxRetries"`
+		SpoolMaxMB  int               `json:"spoolMaxMB"`
+	} `json:"exporter"`
+	Alerts struct {
+		Enabled        bool            `json:"enabled"`
+		RepeatAfterMin int             `json:"repeatAfterMin"` // Re-notify firing alerts (0 = never)
+		Rules          []alert.Rule    `json:"rules"`
+		Webhooks       []alert.Webhook `json:"webhooks"`
+	} `json:"alerts"`
+	Mail struct {
+		Enabled           bool              `json:"enabled"`
+		Host              string            `json:"host"`
+		Port              int               `json:"port"`
+		Username          string            `json:"username"`
+		Password          string            `json:"password"`
+		From              string            `json:"from"`
+		Security          string            `json:"security"`       // "starttls", "tls" or "none"
+		AddressPattern    string            `json:"addressPattern"` // e.g. "{user}@example.edu"
+		Addresses         map[string]string `json:"addresses"`      // Username -> address
+		RatePerHour       int               `json:"ratePerHour"`    // Per recipient
+		TemplatesDir      string            `json:"templatesDir"`
+		AlertsToAdmin     bool              `json:"alertsToAdmin"`
+		UserDiskLimitGB   float64           `json:"userDiskLimitGB"` // 0 = no disk notices
+		GPUIdleMinutes    int               `json:"gpuIdleMinutes"`  // 0 = no idle GPU notices
+		GPUIdleUtil       int               `json:"gpuIdleUtilPercent"`
+		NoticeRepeatHours int               `json:"noticeRepeatHours"` // Per user and notice kind
+	} `json:"mail"`
+	Hub struct {
+		Enabled       bool       `json:"enabled"`
+		IntervalSec   int        `json:"intervalSec"`
+		StaleAfterSec int        `json:"staleAfterSec"`
+		TimeoutSec    int        `json:"timeoutSec"`
+		Peers         []hub.Peer `json:"peers"`
+	} `json:"hub"`
+	Reservations struct {
+		Enabled      bool     `json:"enabled"`
+		MaxHours     int      `json:"maxHours"`     // Longest single booking
+		MaxAheadDays int      `json:"maxAheadDays"` // How far ahead bookings may start
+		ExemptUsers  []string `json:"exemptUsers"`  // Process owners never flagged as violations
+	} `json:"reservations"`
+	Auth struct {
+		Enabled         bool              `json:"enabled"`
+		UsersFile       string            `json:"usersFile"` // htpasswd file with bcrypt hashes
+		SessionTTLHours int               `json:"sessionTTLHours"`
+		CookieSecure    bool              `json:"cookieSecure"` // Set when served behind an HTTPS proxy
+		Protect         []string          `json:"protect"`      // "all" or route patterns such as "/api/docs/*"
+		DefaultRole     string            `json:"defaultRole"`  // Role of signed-in users without a mapped role
+		UserRoles       map[string]string `json:"userRoles"`    // Username -> viewer, member or admin
+		TokenMaxDays    int               `json:"tokenMaxDays"` // Longest API token lifetime
+		LDAP            struct {
+			Enabled            bool              `json:"enabled"`
+			URL                string            `json:"url"` // ldap://host:389 or ldaps://host:636
+			StartTLS           bool              `json:"startTLS"`
+			CACert             string            `json:"caCert"`
+			InsecureSkipVerify bool              `json:"insecureSkipVerify"`
+			TimeoutSec         int               `json:"timeoutSec"`
+			BindDN             string            `json:"bindDN"`
+			BindPassword       string            `json:"bindPassword"`
+			BaseDN             string            `json:"baseDN"`
+			UserFilter         string            `json:"userFilter"`
+			UserAttr           string            `json:"userAttr"`
+			GroupBaseDN        string            `json:"groupBaseDN"`
+			GroupFilter        string            `json:"groupFilter"`
+			GroupAttr          string            `json:"groupAttr"`
+			GroupRoles         map[string]string `json:"groupRoles"` // Group name -> LabMD role
+			DefaultRoles       []string          `json:"defaultRoles"`
+		} `json:"ldap"`
+		OIDC struct {
+			Enabled       bool              `json:"enabled"`
+			ProviderName  string            `json:"providerName"` // Shown on the login button
+			Issuer        string            `json:"issuer"`
+			ClientID      string            `json:"clientID"`
+			ClientSecret  string            `json:"clientSecret"`
+			RedirectURL   string            `json:"redirectURL"` // https://<host>/api/auth/oidc/callback
+			Scopes        []string          `json:"scopes"`
+			UsernameClaim string            `json:"usernameClaim"`
+			StripDomain   bool              `json:"stripDomain"`
+			GroupsClaim   string            `json:"groupsClaim"`
+			GroupRoles    map[string]string `json:"groupRoles"` // Group name -> LabMD role
+			DefaultRoles  []string          `json:"defaultRoles"`
+		} `json:"oidc"`
+	} `json:"auth"`
+	Search struct {
+		Enabled    bool `json:"enabled"`
+		RefreshSec int  `json:"refreshSec"` // Rescan for changed files
+	} `json:"search"`
+	Uploads struct {
+		MaxSizeMB    int      `json:"maxSizeMB"`    // Per file
+		AllowedTypes []string `json:"allowedTypes"` // MIME types detected from the content
+	} `json:"uploads"`
+	Audit struct {
+		Enabled   bool `json:"enabled"`
+		MaxSizeMB int  `json:"maxSizeMB"` // Rotate audit.log at this size
+		MaxFiles  int  `json:"maxFiles"`  // Rotated files kept
+	} `json:"audit"`
 }
 
 var globalConfig Config
 
+// defaultContentSecurityPolicy fits the bundled frontend: KaTeX needs
+// inline styles, and documents may embed images from other sites.
+// frame-ancestors is added from frameOptions when the headers are sent.
+const defaultContentSecurityPolicy = "default-src 'self'; script-src 'self'; style-src 'self' 'unsafe-inline'; " +
+	"img-src 'self' data: blob: https:; font-src 'self' data:; connect-src 'self'; object-src 'none'; " +
+	"base-uri 'self'; form-action 'self'"
+
 func LoadConfig(configPath string) {
 	// 1. Set Default Values
 	globalConfig.ProjectName = "LabMD"
 	globalConfig.LabName = "Lab Monitoring & Documentation"
-	globalConfig.Port = 8088                   // Default port 8088
+	globalConfig.Port = 8088 // Default port 8088
+	globalConfig.SocketMode = "0660"
 	globalConfig.DocsPath = "/home/labmd/docs" // Default docs folder
 	globalConfig.DocsDepth = 4                 // Default depth 4
 	globalConfig.DefaultDoc = "index.md"       // Default homepage
+	globalConfig.DocsEditable = true
+	globalConfig.DocsResyncSec = 300
+	globalConfig.DataDir = "/var/lib/labmd"
 	globalConfig.Admin.Name = ""
 	globalConfig.Admin.Email = ""
 	globalConfig.Version = Version
+	globalConfig.TLS.Enabled = false
+	globalConfig.TLS.CertFile = "/etc/labmd/tls/cert.pem"
+	globalConfig.TLS.KeyFile = "/etc/labmd/tls/key.pem"
+	globalConfig.TLS.HSTSMaxAgeSec = 0
+	globalConfig.Helper.Socket = "/run/labmd/helper.sock"
+	globalConfig.Helper.SocketGroup = "labmd"
+	globalConfig.Helper.AllowedUsers = []string{"labmd"}
+	globalConfig.HTTP.ContentSecurityPolicy = defaultContentSecurityPolicy
+	globalConfig.HTTP.FrameOptions = "DENY"
+	globalConfig.HTTP.RateLimitPerSec = 20
+	globalConfig.HTTP.RateLimitBurst = 100
+	globalConfig.HTTP.AccessLog.Format = "text"
+	globalConfig.Debug.Enabled = false
+	globalConfig.Debug.Listen = "127.0.0.1:6060"
 
 	// Monitor defaults
 	globalConfig.Monitor.IntervalCRG = 2  // 2 seconds
@@ -79,6 +256,47 @@ func LoadConfig(configPath string) {
 	globalConfig.Slurm.DefaultJobs = 10
 	globalConfig.Slurm.HistoryIntervalMin = 30
 	globalConfig.Slurm.HistoryRetentionHour = 23
+	globalConfig.Metrics.Enabled = true
+	globalConfig.Exporter.Enabled = false
+	globalConfig.Exporter.Format = "influx"
+	globalConfig.Exporter.IntervalSec = 30
+	globalConfig.Exporter.BatchSize = 4
+	globalConfig.Exporter.TimeoutSec = 10
+	globalConfig.Exporter.MaxRetries = 3
+	globalConfig.Exporter.SpoolMaxMB = 64
+	globalConfig.Alerts.Enabled = false
+	globalConfig.Alerts.RepeatAfterMin = 0
+	globalConfig.Mail.Enabled = false
+	globalConfig.Mail.Port = 587
+	globalConfig.Mail.Security = "starttls"
+	globalConfig.Mail.RatePerHour = 6
+	globalConfig.Mail.AlertsToAdmin = true
+	globalConfig.Mail.GPUIdleUtil = 5
+	globalConfig.Mail.NoticeRepeatHours = 24
+	globalConfig.Hub.Enabled = false
+	globalConfig.Hub.IntervalSec = 15
+	globalConfig.Hub.StaleAfterSec = 60
+	globalConfig.Hub.TimeoutSec = 5
+	globalConfig.Reservations.Enabled = false
+	globalConfig.Reservations.MaxHours = 7 * 24
+	globalConfig.Reservations.MaxAheadDays = 30
+	globalConfig.Reservations.ExemptUsers = []string{"root"}
+	globalConfig.Auth.Enabled = false
+	globalConfig.Auth.UsersFile = "/etc/labmd/users.htpasswd"
+	globalConfig.Auth.SessionTTLHours = 12
+	globalConfig.Auth.Protect = []string{"all"}
+	globalConfig.Auth.DefaultRole = "member"
+	globalConfig.Auth.TokenMaxDays = 365
+	globalConfig.Auth.LDAP.TimeoutSec = 5
+	globalConfig.Auth.OIDC.ProviderName = "Single Sign-On"
+	globalConfig.Auth.OIDC.UsernameClaim = "preferred_username"
+	globalConfig.Search.Enabled = true
+	globalConfig.Search.RefreshSec = 60
+	globalConfig.Uploads.MaxSizeMB = 20
+	globalConfig.Uploads.AllowedTypes = docs.DefaultUploadTypes
+	globalConfig.Audit.Enabled = true
+	globalConfig.Audit.MaxSizeMB = 10
+	globalConfig.Audit.MaxFiles = 5
 
 	// 2. Try to read config file
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
@@ -120,6 +338,35 @@ func LoadConfig(configPath string) {
 		}
 	}
 
+	// Listen config
+	if mode, err := strconv.ParseUint(globalConfig.SocketMode, 8, 32); err != nil || mode > 0o777 {
+		log.Printf("[WARN] SocketMode %q is not an octal permission, using 0660", globalConfig.SocketMode)
+		globalConfig.SocketMode = "0660"
+	}
+
+	// HTTP config
+	if globalConfig.HTTP.RateLimitPerSec != 0 {
+		validateFloat("HTTPRateLimitPerSec", &globalConfig.HTTP.RateLimitPerSec, 0.01, 10000)
+		validateInt("HTTPRateLimitBurst", &globalConfig.HTTP.RateLimitBurst, 1, 100000)
+	}
+	globalConfig.HTTP.FrameOptions = strings.ToUpper(globalConfig.HTTP.FrameOptions)
+	if !slices.Contains([]string{"DENY", "SAMEORIGIN", ""}, globalConfig.HTTP.FrameOptions) {
+		log.Printf("[WARN] FrameOptions %q is not DENY or SAMEORIGIN, using DENY", globalConfig.HTTP.FrameOptions)
+		globalConfig.HTTP.FrameOptions = "DENY"
+	}
+	if f := globalConfig.HTTP.AccessLog.Format; f != "text" && f != "json" {
+		log.Printf("[WARN] AccessLog format %q unknown, using text", f)
+		globalConfig.HTTP.AccessLog.Format = "text"
+	}
+
+	// TLS config
+	if globalConfig.TLS.RedirectPort != 0 {
+		validateInt("TLSRedirectPort", &globalConfig.TLS.RedirectPort, 1, 65535)
+	}
+	if globalConfig.TLS.HSTSMaxAgeSec != 0 {
+		validateInt("TLSHSTSMaxAgeSec", &globalConfig.TLS.HSTSMaxAgeSec, 60, 2*365*24*3600)
+	}
+
 	// Monitor intervals
 	validateInt("IntervalCRG", &globalConfig.Monitor.IntervalCRG, 1, 60)
 	validateFloat("IntervalDisk", &globalConfig.Monitor.IntervalDisk, 0.1, 24)
@@ -146,4 +393,58 @@ func LoadConfig(configPath string) {
 	validateInt("SlurmDefaultJobs", &globalConfig.Slurm.DefaultJobs, 1, 100)
 	validateInt("SlurmHistoryIntervalMin", &globalConfig.Slurm.HistoryIntervalMin, 1, 60)
 	validateInt("SlurmHistoryRetentionHour", &globalConfig.Slurm.HistoryRetentionHour, 1, 24)
+
+	// Exporter config
+	validateInt("ExporterIntervalSec", &globalConfig.Exporter.IntervalSec, 5, 3600)
+	validateInt("ExporterBatchSize", &globalConfig.Exporter.BatchSize, 1, 100)
+	validateInt("ExporterTimeoutSec", &globalConfig.Exporter.TimeoutSec, 1, 120)
+	if globalConfig.Exporter.MaxRetries != 0 {
+		validateInt("ExporterMaxRetries", &globalConfig.Exporter.MaxRetries, 0, 10)
+	}
+	validateInt("ExporterSpoolMaxMB", &globalConfig.Exporter.SpoolMaxMB, 1, 4096)
+
+	// Alerts config
+	if globalConfig.Alerts.RepeatAfterMin != 0 {
+		validateInt("AlertRepeatAfterMin", &globalConfig.Alerts.RepeatAfterMin, 0, 7*24*60)
+	}
+
+	// Mail config
+	validateInt("MailPort", &globalConfig.Mail.Port, 1, 65535)
+	if globalConfig.Mail.RatePerHour != 0 {
+		validateInt("MailRatePerHour", &globalConfig.Mail.RatePerHour, 0, 1000)
+	}
+	if globalConfig.Mail.GPUIdleMinutes != 0 {
+		validateInt("MailGPUIdleMinutes", &globalConfig.Mail.GPUIdleMinutes, 0, 7*24*60)
+	}
+	validateInt("MailGPUIdleUtil", &globalConfig.Mail.GPUIdleUtil, 1, 100)
+	validateInt("MailNoticeRepeatHours", &globalConfig.Mail.NoticeRepeatHours, 1, 24*30)
+
+	// Hub config
+	validateInt("HubIntervalSec", &globalConfig.Hub.IntervalSec, 2, 3600)
+	validateInt("HubStaleAfterSec", &globalConfig.Hub.StaleAfterSec, 5, 86400)
+	validateInt("HubTimeoutSec", &globalConfig.Hub.TimeoutSec, 1, 60)
+
+	// Reservations config
+	validateInt("ReservationMaxHours", &globalConfig.Reservations.MaxHours, 1, 30*24)
+	validateInt("ReservationMaxAheadDays", &globalConfig.Reservations.MaxAheadDays, 1, 365)
+
+	// Auth config
+	validateInt("AuthSessionTTLHours", &globalConfig.Auth.SessionTTLHours, 1, 24*30)
+	validateInt("AuthTokenMaxDays", &globalConfig.Auth.TokenMaxDays, 1, 3650)
+	validateInt("LDAPTimeoutSec", &globalConfig.Auth.LDAP.TimeoutSec, 1, 60)
+
+	// Docs config
+	validateInt("DocsResyncSec", &globalConfig.DocsResyncSec, 10, 24*3600)
+
+	// Search config
+	validateInt("SearchRefreshSec", &globalConfig.Search.RefreshSec, 5, 3600)
+
+	// Uploads config
+	validateInt("UploadMaxSizeMB", &globalConfig.Uploads.MaxSizeMB, 1, 1024)
+
+	// Audit config
+	validateInt("AuditMaxSizeMB", &globalConfig.Audit.MaxSizeMB, 1, 1024)
+	if globalConfig.Audit.MaxFiles != 0 {
+		validateInt("AuditMaxFiles", &globalConfig.Audit.MaxFiles, 0, 100)
+	}
 }
@@ -1,10 +1,13 @@
 package docs
 
 import (
+	"LabMD-backend/audit"
+	"LabMD-backend/monitor"
 	"encoding/json"
+	"io"
 	"net/http"
 	"os"
-	"os/user"
+	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
@@ -16,6 +19,18 @@ type Config struct {
 	DocsPath   string
 	DocsDepth  int
 	DefaultDoc string
+	Editable   bool // Accept changes through the API
+	// Web users own files as the Unix account of the same name
+	UnixAccounts bool
+	Audit        *audit.Log // Records document changes
+	Search       *Index     // Refreshed after changes; may be nil
+	Tree         *Tree      // Cached tree; nil walks the directory per request
+	// Gives a file (relative to DocsPath) to another user when the server
+	// may not; nil keeps such files the server's
+	Chown func(path string, uid, gid int) error
+
+	MaxUploadBytes int64    // Per uploaded file
+	UploadTypes    []string // Accepted MIME types; empty = DefaultUploadTypes
 }
 
 type FileNode struct {
@@ -25,28 +40,57 @@ type FileNode struct {
 	Owner    string      `json:"owner"`
 	ModTime  string      `json:"modTime"`
 	Children []*FileNode `json:"children,omitempty"`
+
+	// From the front matter of documents
+	Title   string   `json:"title,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	Authors []string `json:"authors,omitempty"`
+	Draft   bool     `json:"draft,omitempty"`
+	Order   int      `json:"order,omitempty"`
+
+	owners []string // Of drafts, who besides the authors may read them
 }
 
 func TreeHandler(config Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		viewer := ViewerOf(r)
+		if config.Tree != nil {
+			w.Write(config.Tree.JSON(viewer))
+			return
+		}
 
 		children := walk(config, "", 1)
 		root := &FileNode{
 			Name:     "root",
 			Path:     "",
 			Type:     "dir",
-			Children: children,
+			Children: viewer.hideDrafts(children),
 		}
 
 		json.NewEncoder(w).Encode(root)
 	}
 }
 
+// ContentHandler serves a document on GET, with an ETag to send back in
+// If-Match when saving it through PUT. PUT also creates and DELETE removes
+// documents.
 func ContentHandler(config Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPut:
+			saveContent(config, w, r)
+			return
+		case http.MethodDelete:
+			deleteContent(config, w, r)
+			return
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
 
 		relPath := r.URL.Query().Get("path")
 		if relPath == "" {
@@ -60,8 +104,8 @@ func ContentHandler(config Config) http.HandlerFunc {
 		}
 
 		fullPath := filepath.Join(config.DocsPath, relPath)
-		content, err := os.ReadFile(fullPath)
-		if err != nil {
+		content, info, err := readFile(fullPath)
+		if err != nil || ViewerOf(r).hidden(config, fullPath, content, info) {
 			http.Error(w, "File not found", http.StatusNotFound)
 			return
 		}
@@ -73,10 +117,48 @@ func ContentHandler(config Config) http.HandlerFunc {
 		}
 
 		w.Header().Set("Content-Type", "text/markdown")
+		w.Header().Set("ETag", ETag(info))
+		w.Header().Set("X-Front-Matter-Lines", strconv.Itoa(frontMatterLines(content)))
+		w.Header().Set("Cache-Control", "no-cache")
 		w.Write(content)
 	}
 }
 
+// RawHandler serves files of the docs directory as they are, for images
+// and attachments. Drafts are hidden as in ContentHandler.
+func RawHandler(config Config) http.HandlerFunc {
+	files := http.StripPrefix("/raw/", http.FileServer(http.Dir(config.DocsPath)))
+	return func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(strings.ToLower(r.URL.Path), ".md") {
+			relPath := path.Clean("/" + strings.TrimPrefix(r.URL.Path, "/raw/"))
+			fullPath := filepath.Join(config.DocsPath, filepath.FromSlash(relPath))
+			content, info, err := readFile(fullPath)
+			if err == nil && ViewerOf(r).hidden(config, fullPath, content, info) {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		files.ServeHTTP(w, r)
+	}
+}
+
+// readFile returns a file's content with the FileInfo of the same open
+// file, so the ETag always matches the bytes served.
+func readFile(path string) ([]byte, os.FileInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := io.ReadAll(file)
+	return content, info, err
+}
+
 func walk(config Config, relPath string, currentDepth int) []*FileNode {
 	if currentDepth > config.DocsDepth {
 		return []*FileNode{}
@@ -100,7 +182,8 @@ func walk(config Config, relPath string, currentDepth int) []*FileNode {
 			if currentDepth >= config.DocsDepth {
 				continue
 			}
-			if !hasMarkdownFiles(config, childRelPath) {
+			// Empty folders are shown so ones just created can be filled
+			if !hasMarkdownFiles(config, childRelPath) && !isEmptyDir(filepath.Join(config.DocsPath, childRelPath)) {
 				continue
 			}
 
@@ -114,17 +197,35 @@ func walk(config Config, relPath string, currentDepth int) []*FileNode {
 		}
 
 		if strings.HasSuffix(strings.ToLower(name), ".md") {
-			owner, modTime := getFileMetadata(filepath.Join(config.DocsPath, childRelPath))
-			nodes = append(nodes, &FileNode{
+			fullPath := filepath.Join(config.DocsPath, childRelPath)
+			info, err := os.Stat(fullPath)
+			owner, modTime := "--", "--"
+			if err == nil {
+				owner, modTime = metadataOf(info)
+			}
+			node := &FileNode{
 				Name:    name,
 				Path:    childRelPath,
 				Type:    "file",
 				Owner:   owner,
 				ModTime: modTime,
-			})
+			}
+			node.setFrontMatter(readFrontMatter(fullPath))
+			if err == nil {
+				node.setDraftOwners(config, fullPath, info)
+			}
+			nodes = append(nodes, node)
 		}
 	}
 
+	sortNodes(config, nodes)
+	return nodes
+}
+
+// sortNodes puts the default document first, then files before folders.
+// Files with an order in their front matter come first, lowest first, and
+// the rest go alphabetically.
+func sortNodes(config Config, nodes []*FileNode) {
 	sort.Slice(nodes, func(i, j int) bool {
 		if config.DefaultDoc != "" {
 			if nodes[i].Path == config.DefaultDoc {
@@ -137,10 +238,14 @@ func walk(config Config, relPath string, currentDepth int) []*FileNode {
 		if nodes[i].Type != nodes[j].Type {
 			return nodes[i].Type == "file"
 		}
+		if oi, oj := nodes[i].Order, nodes[j].Order; oi != oj {
+			if oi == 0 || oj == 0 {
+				return oj == 0
+			}
+			return oi < oj
+		}
 		return strings.ToLower(nodes[i].Name) < strings.ToLower(nodes[j].Name)
 	})
-
-	return nodes
 }
 
 func hasMarkdownFiles(config Config, relPath string) bool {
@@ -172,22 +277,24 @@ func hasMarkdownFiles(config Config, relPath string) bool {
 	return false
 }
 
-func getFileMetadata(path string) (string, string) {
-	info, err := os.Stat(path)
+func isEmptyDir(path string) bool {
+	entries, err := os.ReadDir(path)
 	if err != nil {
-		return "--", "--"
+		return false
 	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), ".") {
+			return false
+		}
+	}
+	return true
+}
 
+func metadataOf(info os.FileInfo) (string, string) {
 	modTime := info.ModTime().Format("2006-01-02 15:04")
 	owner := "unknown"
 	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
-		uid := strconv.Itoa(int(stat.Uid))
-		u, err := user.LookupId(uid)
-		if err == nil {
-			owner = u.Username
-		} else {
-			owner = uid
-		}
+		owner = monitor.UserName(stat.Uid)
 	}
 	return owner, modTime
 }
@@ -0,0 +1,741 @@
+package docs
+
+import (
+	"LabMD-backend/audit"
+	"LabMD-backend/auth"
+	"LabMD-backend/monitor"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	maxDocumentBytes = 10 << 20
+	newFileMode      = 0o664 // Group-writable like the usual umask 002 for user private groups
+)
+
+// writeMu serializes changes made through the API so two requests cannot
+// both pass their checks before either touches the tree.
+var writeMu sync.Mutex
+
+// ETag identifies a document version by modification time and size, so
+// edits made outside LabMD (vim over SSH) change it too.
+func ETag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+// cleanPath validates a path from a request against the same rules as the
+// tree: relative, inside the docs directory and no hidden components
+// (editors keep their temporary files there).
+func cleanPath(relPath string) (string, error) {
+	clean := filepath.Clean(relPath)
+	if relPath == "" || filepath.IsAbs(relPath) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", errors.New("invalid path")
+	}
+	for _, part := range strings.Split(clean, "/") {
+		if strings.HasPrefix(part, ".") {
+			return "", errors.New("invalid path")
+		}
+	}
+	return clean, nil
+}
+
+// depth counts the components of a clean path. The tree lists files up
+// to DocsDepth and folders above it.
+func depth(clean string) int {
+	return strings.Count(clean, "/") + 1
+}
+
+// docPath is cleanPath for Markdown files the tree can show, returned
+// joined to the docs directory.
+func docPath(config Config, relPath string) (string, error) {
+	clean, err := cleanPath(relPath)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(strings.ToLower(clean), ".md") {
+		return "", errors.New("only Markdown files are allowed")
+	}
+	if depth(clean) > config.DocsDepth {
+		return "", fmt.Errorf("deeper than %d levels", config.DocsDepth)
+	}
+	return filepath.Join(config.DocsPath, clean), nil
+}
+
+// folderPath is cleanPath for folders the tree can show.
+func folderPath(config Config, relPath string) (string, error) {
+	clean, err := cleanPath(relPath)
+	if err != nil {
+		return "", err
+	}
+	if depth(clean) >= config.DocsDepth {
+		return "", fmt.Errorf("folders must be less than %d levels deep", config.DocsDepth)
+	}
+	return filepath.Join(config.DocsPath, clean), nil
+}
+
+// checkParent makes sure the directory that will hold fullPath exists and,
+// after resolving symlinks, is still inside the docs directory.
+func checkParent(config Config, fullPath string) error {
+	root, err := filepath.EvalSymlinks(config.DocsPath)
+	if err != nil {
+		return err
+	}
+	parent, err := filepath.EvalSymlinks(filepath.Dir(fullPath))
+	if err != nil {
+		return errors.New("parent folder not found")
+	}
+	if parent != root && !strings.HasPrefix(parent, root+string(filepath.Separator)) {
+		return errors.New("parent folder is outside the docs directory")
+	}
+	return nil
+}
+
+// allowChange rejects requests that may not change documents: servers
+// with editing disabled and API tokens without the write-docs scope.
+func allowChange(config Config, w http.ResponseWriter, r *http.Request, event audit.Event) bool {
+	if !config.Editable {
+		http.Error(w, "Editing is disabled on this server", http.StatusForbidden)
+		return false
+	}
+	if !auth.HasScope(r.Context(), auth.ScopeWriteDocs) {
+		event.Result = audit.Denied
+		config.Audit.RecordRequest(r, event)
+		http.Error(w, "Forbidden: token lacks "+string(auth.ScopeWriteDocs)+" scope", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// changed brings the tree and the search index up to date after the API
+// created, changed or removed the given paths.
+func (c Config) changed(fullPaths ...string) {
+	var dirs []string
+	for _, fullPath := range fullPaths {
+		rel, err := filepath.Rel(c.DocsPath, filepath.Dir(fullPath))
+		if err != nil {
+			continue
+		}
+		if rel == "." {
+			rel = ""
+		}
+		dirs = append(dirs, rel)
+	}
+	c.Tree.Update(dirs...)
+	c.Search.Notify(dirs...)
+}
+
+func newEvent(r *http.Request, action, target string) audit.Event {
+	event := audit.Event{Action: action, Target: target}
+	if id := auth.IdentityFrom(r.Context()); id != nil {
+		event.User = id.Username
+	}
+	return event
+}
+
+// saveContent handles PUT /api/docs/content?path=. The client sends the
+// ETag it loaded in If-Match; a document changed since then is answered
+// with 409 and its current ETag instead of being overwritten. With
+// If-None-Match: * it creates a new document instead.
+func saveContent(config Config, w http.ResponseWriter, r *http.Request) {
+	relPath := r.URL.Query().Get("path")
+	event := newEvent(r, "docs.write", relPath)
+	if !allowChange(config, w, r, event) {
+		return
+	}
+
+	fullPath, err := docPath(config, relPath)
+	if err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	ifMatch, create := r.Header.Get("If-Match"), r.Header.Get("If-None-Match") == "*"
+	if ifMatch == "" && !create {
+		http.Error(w, "If-Match header with the document's ETag (or If-None-Match: * to create it) is required", http.StatusPreconditionRequired)
+		return
+	}
+	content, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxDocumentBytes))
+	if err != nil {
+		http.Error(w, "Document too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
+	if create {
+		createContent(config, w, r, fullPath, content)
+		return
+	}
+
+	info, err := os.Lstat(fullPath)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, "Failed to read document", http.StatusInternalServerError)
+		return
+	case !info.Mode().IsRegular():
+		http.Error(w, "Forbidden: only regular files can be edited", http.StatusForbidden)
+		return
+	case hiddenDraft(config, r, fullPath):
+		// Before the ETag comparison, whose 409 would hand out the ETag
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if current := ETag(info); current != ifMatch {
+		event.Result, event.Detail = audit.Failure, "changed since it was loaded"
+		config.Audit.RecordRequest(r, event)
+		w.Header().Set("ETag", current)
+		http.Error(w, "Document was changed by someone else; reload it and apply your edits again", http.StatusConflict)
+		return
+	}
+	if err := checkParent(config, fullPath); err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := config.replaceFile(fullPath, content, info, config.creatorFor(fullPath, info)); err != nil {
+		log.Printf("[ERROR] Saving %s: %v", relPath, err)
+		event.Result, event.Detail = audit.Failure, err.Error()
+		config.Audit.RecordRequest(r, event)
+		http.Error(w, "Failed to save document", http.StatusInternalServerError)
+		return
+	}
+
+	event.Detail = fmt.Sprintf("%d bytes", len(content))
+	config.Audit.RecordRequest(r, event)
+	config.changed(fullPath)
+	writeVersion(w, fullPath)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// hiddenDraft reports whether the document at fullPath is a draft the
+// user of r may not read, and so may not change either.
+func hiddenDraft(config Config, r *http.Request, fullPath string) bool {
+	content, info, err := readFile(fullPath)
+	return err == nil && ViewerOf(r).hidden(config, fullPath, content, info)
+}
+
+// createContent writes a new document owned by the requesting user. It
+// must be called with writeMu held.
+func createContent(config Config, w http.ResponseWriter, r *http.Request, fullPath string, content []byte) {
+	relPath := r.URL.Query().Get("path")
+	event := newEvent(r, "docs.create", relPath)
+	if err := checkParent(config, fullPath); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	uid, gid := ownerIDs(config, event.User)
+	err := config.createFile(fullPath, content, uid, gid, event.User)
+	switch {
+	case errors.Is(err, os.ErrExist):
+		http.Error(w, "A file with this name already exists", http.StatusPreconditionFailed)
+		return
+	case err != nil:
+		log.Printf("[ERROR] Creating %s: %v", relPath, err)
+		event.Result, event.Detail = audit.Failure, err.Error()
+		config.Audit.RecordRequest(r, event)
+		http.Error(w, "Failed to create document", http.StatusInternalServerError)
+		return
+	}
+
+	event.Detail = fmt.Sprintf("%d bytes", len(content))
+	config.Audit.RecordRequest(r, event)
+	config.changed(fullPath)
+	writeVersion(w, fullPath)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// FolderHandler creates (POST) and deletes (DELETE) folders given by the
+// path query parameter. Deleting a folder removes everything inside it, so
+// the requester must be allowed to delete every entry.
+func FolderHandler(config Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		relPath := r.URL.Query().Get("path")
+		var action string
+		switch r.Method {
+		case http.MethodPost:
+			action = "docs.mkdir"
+		case http.MethodDelete:
+			action = "docs.rmdir"
+		default:
+			w.Header().Set("Allow", "POST, DELETE")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		event := newEvent(r, action, relPath)
+		if !allowChange(config, w, r, event) {
+			return
+		}
+
+		var fullPath string
+		var err error
+		if r.Method == http.MethodPost {
+			fullPath, err = folderPath(config, relPath)
+		} else {
+			var clean string
+			clean, err = cleanPath(relPath)
+			fullPath = filepath.Join(config.DocsPath, clean)
+		}
+		if err != nil {
+			http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := checkParent(config, fullPath); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		writeMu.Lock()
+		defer writeMu.Unlock()
+
+		if r.Method == http.MethodPost {
+			uid, gid := ownerIDs(config, event.User)
+			err := config.createFolder(fullPath, uid, gid, event.User)
+			switch {
+			case errors.Is(err, os.ErrExist):
+				http.Error(w, "A file or folder with this name already exists", http.StatusConflict)
+			case err != nil:
+				log.Printf("[ERROR] Creating folder %s: %v", relPath, err)
+				http.Error(w, "Failed to create folder", http.StatusInternalServerError)
+			default:
+				config.Audit.RecordRequest(r, event)
+				config.changed(fullPath)
+				w.WriteHeader(http.StatusCreated)
+			}
+			return
+		}
+
+		info, err := os.Lstat(fullPath)
+		if err != nil || !info.IsDir() {
+			http.Error(w, "Folder not found", http.StatusNotFound)
+			return
+		}
+		if blocked := removalBlocker(config, r, fullPath); blocked != "" {
+			event.Result, event.Detail = audit.Denied, blocked
+			config.Audit.RecordRequest(r, event)
+			http.Error(w, "Forbidden: only the owner may delete "+blocked, http.StatusForbidden)
+			return
+		}
+		if err := os.RemoveAll(fullPath); err != nil {
+			log.Printf("[ERROR] Deleting folder %s: %v", relPath, err)
+			event.Result, event.Detail = audit.Failure, err.Error()
+			config.Audit.RecordRequest(r, event)
+			http.Error(w, "Failed to delete folder", http.StatusInternalServerError)
+			return
+		}
+		config.Audit.RecordRequest(r, event)
+		config.changed(fullPath)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// deleteContent handles DELETE /api/docs/content?path=. An If-Match
+// header, when present, must match like it does for saves.
+func deleteContent(config Config, w http.ResponseWriter, r *http.Request) {
+	relPath := r.URL.Query().Get("path")
+	event := newEvent(r, "docs.delete", relPath)
+	if !allowChange(config, w, r, event) {
+		return
+	}
+	fullPath, err := docPath(config, relPath)
+	if err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkParent(config, fullPath); err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
+	info, err := os.Lstat(fullPath)
+	if err != nil || info.IsDir() || hiddenDraft(config, r, fullPath) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && ifMatch != ETag(info) {
+		w.Header().Set("ETag", ETag(info))
+		http.Error(w, "Document was changed by someone else", http.StatusConflict)
+		return
+	}
+	if !mayRemove(config, r, fullPath, info) {
+		event.Result = audit.Denied
+		config.Audit.RecordRequest(r, event)
+		http.Error(w, "Forbidden: only the owner may delete this file", http.StatusForbidden)
+		return
+	}
+	if err := os.Remove(fullPath); err != nil {
+		log.Printf("[ERROR] Deleting %s: %v", relPath, err)
+		event.Result, event.Detail = audit.Failure, err.Error()
+		config.Audit.RecordRequest(r, event)
+		http.Error(w, "Failed to delete document", http.StatusInternalServerError)
+		return
+	}
+	config.Audit.RecordRequest(r, event)
+	config.changed(fullPath)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type moveRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// MoveHandler renames or moves a document or folder (POST {"from", "to"}).
+// As in a sticky-bit directory, only the owner may move an entry, and an
+// existing destination is never replaced.
+func MoveHandler(config Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req moveRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		event := newEvent(r, "docs.move", req.From)
+		event.Detail = "to " + req.To
+		if !allowChange(config, w, r, event) {
+			return
+		}
+
+		from, err := cleanPath(req.From)
+		if err != nil {
+			http.Error(w, "Invalid from: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		fromPath := filepath.Join(config.DocsPath, from)
+		if err := checkParent(config, fromPath); err != nil {
+			http.Error(w, "Source not found", http.StatusNotFound)
+			return
+		}
+
+		writeMu.Lock()
+		defer writeMu.Unlock()
+
+		info, err := os.Lstat(fromPath)
+		if err != nil {
+			http.Error(w, "Source not found", http.StatusNotFound)
+			return
+		}
+		var toPath string
+		if info.IsDir() {
+			toPath, err = folderPath(config, req.To)
+			if err == nil && depth(req.To)+maxDepth(fromPath) >= config.DocsDepth {
+				err = fmt.Errorf("subfolders would be %d or more levels deep", config.DocsDepth)
+			}
+			if err == nil && (toPath == fromPath || strings.HasPrefix(toPath, fromPath+"/")) {
+				err = errors.New("a folder cannot be moved into itself")
+			}
+		} else {
+			if !strings.HasSuffix(strings.ToLower(from), ".md") {
+				http.Error(w, "Only Markdown files and folders can be moved", http.StatusBadRequest)
+				return
+			}
+			toPath, err = docPath(config, req.To)
+		}
+		if err == nil {
+			err = checkParent(config, toPath)
+		}
+		if err != nil {
+			http.Error(w, "Invalid to: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := os.Lstat(toPath); err == nil {
+			http.Error(w, "A file or folder with this name already exists", http.StatusConflict)
+			return
+		}
+		if !mayRemove(config, r, fromPath, info) {
+			event.Result = audit.Denied
+			config.Audit.RecordRequest(r, event)
+			http.Error(w, "Forbidden: only the owner may move this entry", http.StatusForbidden)
+			return
+		}
+
+		if err := os.Rename(fromPath, toPath); err != nil {
+			log.Printf("[ERROR] Moving %s to %s: %v", req.From, req.To, err)
+			event.Result = audit.Failure
+			config.Audit.RecordRequest(r, event)
+			http.Error(w, "Failed to move", http.StatusInternalServerError)
+			return
+		}
+		config.Audit.RecordRequest(r, event)
+		config.changed(fromPath, toPath)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// mayRemove applies the sticky-bit rule to a web user: an entry may be
+// deleted or moved by its owner or the owner of its directory. Admins
+// stand in for root.
+func mayRemove(config Config, r *http.Request, path string, info os.FileInfo) bool {
+	if auth.RoleFrom(r.Context()) >= auth.RoleAdmin {
+		return true
+	}
+	id := auth.IdentityFrom(r.Context())
+	if id == nil {
+		return false
+	}
+	if config.owns(id.Username, path, info) {
+		return true
+	}
+	dir := filepath.Dir(path)
+	dirInfo, err := os.Stat(dir)
+	return err == nil && config.owns(id.Username, dir, dirInfo)
+}
+
+// owns reports whether username owns the file at path: it created it
+// through LabMD, or, with UnixAccounts, it is the Unix account owning it.
+// A web user name alone never stands for the Unix account of that name.
+func (c Config) owns(username, path string, info os.FileInfo) bool {
+	if username == "" {
+		return false
+	}
+	return creatorOf(path, info) == username || (c.UnixAccounts && ownerName(info) == username)
+}
+
+// creatorFor returns the creator a rewritten copy of path should record.
+// With UnixAccounts the Unix owner stands in when LabMD did not create it,
+// so the author keeps the file even when the copy belongs to the server.
+func (c Config) creatorFor(path string, info os.FileInfo) string {
+	if creator := creatorOf(path, info); creator != "" || !c.UnixAccounts {
+		return creator
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) == os.Geteuid() {
+		return ""
+	}
+	return ownerName(info)
+}
+
+// removalBlocker returns the first entry below dir (relative to it) that
+// the requester may not delete, or "" when the whole folder may go. An
+// entry that cannot be checked blocks the removal too.
+func removalBlocker(config Config, r *http.Request, dir string) string {
+	blocked := ""
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return filepath.Base(dir)
+	}
+	if !mayRemove(config, r, dir, info) {
+		return filepath.Base(dir)
+	}
+	filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err == nil && path == dir {
+			return nil
+		}
+		var info os.FileInfo
+		if err == nil {
+			info, err = entry.Info()
+		}
+		if err != nil || !mayRemove(config, r, path, info) {
+			blocked, _ = filepath.Rel(filepath.Dir(dir), path)
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return blocked
+}
+
+// maxDepth returns how many levels of folders lie below dir.
+func maxDepth(dir string) int {
+	depth := 0
+	filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err == nil && entry.IsDir() && path != dir {
+			rel, _ := filepath.Rel(dir, path)
+			depth = max(depth, strings.Count(rel, "/")+1)
+		}
+		return nil
+	})
+	return depth
+}
+
+func ownerName(info os.FileInfo) string {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	return monitor.UserName(stat.Uid)
+}
+
+// creatorAttr is the extended attribute recording the web user who
+// created a file or folder through LabMD.
+const creatorAttr = "user.labmd.creator"
+
+var xattrWarning sync.Once
+
+// setCreator records username as the creator of path. Filesystems without
+// user extended attributes leave files without one, so only admins (and,
+// with UnixAccounts, the Unix owner) may remove them.
+func setCreator(path, username string) {
+	if username == "" {
+		return
+	}
+	if err := syscall.Setxattr(path, creatorAttr, []byte(username), 0); err != nil {
+		xattrWarning.Do(func() {
+			log.Printf("[WARN] Cannot record creators of docs (%v); only admins may delete files created through LabMD", err)
+		})
+	}
+}
+
+// creatorOf returns the web user who created path, or "". Symlinks have
+// none, so a link cannot lend its target's creator.
+func creatorOf(path string, info os.FileInfo) string {
+	if info.Mode()&os.ModeSymlink != 0 {
+		return ""
+	}
+	buf := make([]byte, 256)
+	n, err := syscall.Getxattr(path, creatorAttr, buf)
+	if err != nil || n <= 0 {
+		return ""
+	}
+	return string(buf[:n])
+}
+
+// ownerIDs returns the IDs new files of username should get: with
+// UnixAccounts the account of the same name when one exists on this
+// machine, else the server's. The server hands them over as root or
+// through the root helper; see handOver.
+func ownerIDs(config Config, username string) (int, int) {
+	if config.UnixAccounts && username != "" {
+		if u, err := user.Lookup(username); err == nil {
+			uid, uidErr := strconv.Atoi(u.Uid)
+			gid, gidErr := strconv.Atoi(u.Gid)
+			if uidErr == nil && gidErr == nil {
+				return uid, gid
+			}
+		}
+	}
+	return os.Geteuid(), os.Getegid()
+}
+
+func writeVersion(w http.ResponseWriter, fullPath string) {
+	if info, err := os.Stat(fullPath); err == nil {
+		w.Header().Set("ETag", ETag(info))
+		w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	}
+}
+
+// writeTemp writes data to a hidden temporary file next to path with the
+// given mode and created by creator. The caller renames or removes it.
+func writeTemp(path string, data []byte, mode os.FileMode, creator string) (tmpName string, err error) {
+	dir, name := filepath.Split(path)
+	tmp, err := os.CreateTemp(dir, "."+name+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		return "", err
+	}
+	if err = tmp.Sync(); err != nil {
+		return "", err
+	}
+	// While the server still owns the file
+	setCreator(tmp.Name(), creator)
+	if err = tmp.Chmod(mode); err != nil {
+		return "", err
+	}
+	return tmp.Name(), tmp.Close()
+}
+
+// handOver gives path, which the server just wrote, to uid:gid. The server
+// runs without CAP_CHOWN, so unless it is root it asks the root helper
+// through Chown. When neither can, the file stays the server's: it is
+// already in place, and its creator still lets its author manage it.
+func (c Config) handOver(path string, uid, gid int) {
+	if uid == os.Geteuid() && gid == os.Getegid() {
+		return
+	}
+	err := os.Lchown(path, uid, gid)
+	if errors.Is(err, syscall.EPERM) && c.Chown != nil {
+		rel, _ := filepath.Rel(c.DocsPath, path)
+		err = c.Chown(rel, uid, gid)
+	}
+	if err != nil {
+		log.Printf("[WARN] Could not give %s to %d:%d, it stays the server's: %v", path, uid, gid, err)
+	}
+}
+
+// replaceFile atomically replaces path with data. The new file gets the
+// mode and creator of the old one, then its owner and group, so a document
+// keeps its author in a shared sticky-bit directory even though the
+// server wrote it.
+func (c Config) replaceFile(path string, data []byte, old os.FileInfo, creator string) error {
+	tmpName, err := writeTemp(path, data, old.Mode().Perm(), creator)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if stat, ok := old.Sys().(*syscall.Stat_t); ok {
+		c.handOver(path, int(stat.Uid), int(stat.Gid))
+	}
+	return nil
+}
+
+// createFile atomically creates path with data, failing with os.ErrExist
+// when it is already there, and gives it to uid:gid.
+func (c Config) createFile(path string, data []byte, uid, gid int, creator string) error {
+	// Link while the server owns the file: with protected_hardlinks it may
+	// only link its own files
+	tmpName, err := writeTemp(path, data, newFileMode, creator)
+	if err != nil {
+		return err
+	}
+	err = os.Link(tmpName, path)
+	os.Remove(tmpName)
+	if err != nil {
+		return err
+	}
+	c.handOver(path, uid, gid)
+	return nil
+}
+
+// createFolder makes a folder with the permissions of its parent, which
+// keeps the sticky bit of the shared directory, and gives it to uid:gid.
+func (c Config) createFolder(path string, uid, gid int, creator string) error {
+	parent, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	mode := parent.Mode() & (os.ModePerm | os.ModeSticky | os.ModeSetgid)
+	if err := os.Mkdir(path, mode.Perm()); err != nil {
+		return err
+	}
+	setCreator(path, creator)
+	// Mkdir applies the umask and drops the special bits. Restore them
+	// while the server still owns the folder.
+	if err := os.Chmod(path, mode); err != nil {
+		os.Remove(path)
+		return err
+	}
+	c.handOver(path, uid, gid)
+	return nil
+}
@@ -0,0 +1,217 @@
+package docs
+
+import (
+	"LabMD-backend/auth"
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Front matter longer than this is not looked for
+const maxFrontMatterBytes = 16 << 10
+
+// FrontMatter is the YAML block a document may start with, between two
+// "---" lines:
+//
+//	---
+//	title: PCR Protocol
+//	tags: [pcr, dna]
+//	authors: alice, bob
+//	draft: true
+//	order: 1
+//	---
+//
+// Tags and authors may be a list or a comma-separated string. Fields of
+// the wrong type are left empty rather than failing the rest.
+type FrontMatter struct {
+	Title   string     `yaml:"title" json:"title,omitempty"`
+	Tags    stringList `yaml:"tags" json:"tags,omitempty"`       // Lowercase
+	Authors stringList `yaml:"authors" json:"authors,omitempty"` // User names, who may read the draft
+	Draft   bool       `yaml:"draft" json:"draft,omitempty"`     // Hidden from everyone but owner, authors and admins
+	Order   int        `yaml:"order" json:"order,omitempty"`     // Sorts before unordered documents, lowest first
+}
+
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(value *yaml.Node) error {
+	var items []string
+	switch value.Kind {
+	case yaml.ScalarNode:
+		items = strings.Split(value.Value, ",")
+	case yaml.SequenceNode:
+		if err := value.Decode(&items); err != nil {
+			return err
+		}
+	}
+	*l = nil
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item != "" && !slices.Contains(*l, item) {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// parseFrontMatter splits content into its front matter and the document
+// after it. Content that does not start with a YAML mapping between "---"
+// lines is all document, so a leading horizontal rule stays one.
+func parseFrontMatter(content []byte) (FrontMatter, []byte) {
+	block, body, ok := splitFrontMatter(content)
+	if !ok {
+		return FrontMatter{}, content
+	}
+	var node yaml.Node
+	if yaml.Unmarshal(block, &node) != nil || len(node.Content) == 0 || node.Content[0].Kind != yaml.MappingNode {
+		return FrontMatter{}, content
+	}
+
+	var front FrontMatter
+	node.Decode(&front)
+	front.Title = strings.TrimSpace(front.Title)
+	tags := front.Tags
+	front.Tags = nil
+	for _, tag := range tags {
+		if tag = strings.ToLower(tag); !slices.Contains(front.Tags, tag) {
+			front.Tags = append(front.Tags, tag)
+		}
+	}
+	return front, body
+}
+
+// frontMatterLines returns how many lines of content parseFrontMatter
+// takes as front matter, 0 when it has none.
+func frontMatterLines(content []byte) int {
+	_, body := parseFrontMatter(content)
+	front := content[:len(content)-len(body)]
+	lines := bytes.Count(front, []byte("\n"))
+	if len(front) > 0 && front[len(front)-1] != '\n' {
+		lines++ // The closing fence ends the file
+	}
+	return lines
+}
+
+func splitFrontMatter(content []byte) ([]byte, []byte, bool) {
+	rest, ok := bytes.CutPrefix(content, []byte("---\n"))
+	if !ok {
+		if rest, ok = bytes.CutPrefix(content, []byte("---\r\n")); !ok {
+			return nil, content, false
+		}
+	}
+	for offset := 0; offset < len(rest); {
+		line, next := rest[offset:], len(rest)
+		if end := bytes.IndexByte(line, '\n'); end >= 0 {
+			line, next = line[:end], offset+end+1
+		}
+		if fence := string(bytes.TrimRight(line, " \t\r")); fence == "---" || fence == "..." {
+			return rest[:offset], rest[next:], true
+		}
+		offset = next
+	}
+	return nil, content, false
+}
+
+// readFrontMatter reads the front matter of the document at path.
+func readFrontMatter(path string) FrontMatter {
+	file, err := os.Open(path)
+	if err != nil {
+		return FrontMatter{}
+	}
+	defer file.Close()
+
+	head := make([]byte, maxFrontMatterBytes)
+	n, _ := io.ReadFull(file, head)
+	front, _ := parseFrontMatter(head[:n])
+	return front
+}
+
+func (n *FileNode) setFrontMatter(front FrontMatter) {
+	n.Title = front.Title
+	n.Tags = front.Tags
+	n.Authors = front.Authors
+	n.Draft = front.Draft
+	n.Order = front.Order
+}
+
+// setDraftOwners records who a draft node belongs to, for hideDrafts.
+func (n *FileNode) setDraftOwners(config Config, path string, info os.FileInfo) {
+	if n.Draft {
+		n.owners = config.draftOwners(path, info)
+	}
+}
+
+// Viewer is who documents are listed for. Drafts only show to their
+// owners (see Config.draftOwners), the authors named in the front matter
+// and admins.
+type Viewer struct {
+	Username string
+	Admin    bool
+}
+
+func ViewerOf(r *http.Request) Viewer {
+	v := Viewer{Admin: auth.RoleFrom(r.Context()) >= auth.RoleAdmin}
+	if id := auth.IdentityFrom(r.Context()); id != nil {
+		v.Username = id.Username
+	}
+	return v
+}
+
+func (v Viewer) mayRead(draft bool, owners, authors []string) bool {
+	if !draft || v.Admin {
+		return true
+	}
+	return v.Username != "" && (slices.Contains(owners, v.Username) || slices.Contains(authors, v.Username))
+}
+
+// hidden reports whether content, read from path with info, is a draft v
+// may not read.
+func (v Viewer) hidden(config Config, path string, content []byte, info os.FileInfo) bool {
+	front, _ := parseFrontMatter(content)
+	if !front.Draft {
+		return false
+	}
+	return !v.mayRead(true, config.draftOwners(path, info), front.Authors)
+}
+
+// draftOwners returns the users path belongs to when it is a draft: the
+// same ones Config.owns lets delete it, that is the web user who created
+// it and, with UnixAccounts, its Unix owner.
+func (c Config) draftOwners(path string, info os.FileInfo) []string {
+	var owners []string
+	if creator := creatorOf(path, info); creator != "" {
+		owners = append(owners, creator)
+	}
+	if c.UnixAccounts {
+		if owner := ownerName(info); owner != "" {
+			owners = append(owners, owner)
+		}
+	}
+	return owners
+}
+
+// hideDrafts returns nodes without the drafts v may not read, copying the
+// folders it changes. Folders that only held such drafts are left out
+// too, so they do not give them away.
+func (v Viewer) hideDrafts(nodes []*FileNode) []*FileNode {
+	shown := []*FileNode{}
+	for _, node := range nodes {
+		switch {
+		case node.Type == "file" && !v.mayRead(node.Draft, node.owners, node.Authors):
+			continue
+		case node.Type == "dir" && len(node.Children) > 0:
+			children := v.hideDrafts(node.Children)
+			if len(children) == 0 {
+				continue
+			}
+			dir := *node
+			dir.Children = children
+			node = &dir
+		}
+		shown = append(shown, node)
+	}
+	return shown
+}
@@ -0,0 +1,182 @@
+package docs
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// mathExtension parses $inline$ and $$display$$ math like remark-math in
+// the web UI and renders the TeX unchanged, in the markup remark-math
+// produces, for KaTeX or MathJax to typeset on the client.
+type mathExtension struct{}
+
+func (mathExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(util.Prioritized(mathBlockParser{}, 701)), // After fenced code
+		parser.WithInlineParsers(util.Prioritized(mathInlineParser{}, 150)),
+	)
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(mathRenderer{}, 500)))
+}
+
+var (
+	kindMathInline = ast.NewNodeKind("MathInline")
+	kindMathBlock  = ast.NewNodeKind("MathBlock")
+)
+
+type mathInline struct {
+	ast.BaseInline
+	tex []byte
+}
+
+func (n *mathInline) Kind() ast.NodeKind { return kindMathInline }
+
+func (n *mathInline) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"TeX": string(n.tex)}, nil)
+}
+
+type mathBlock struct {
+	ast.BaseBlock
+	closed bool // The opening line closed it too
+}
+
+func (n *mathBlock) Kind() ast.NodeKind { return kindMathBlock }
+func (n *mathBlock) IsRaw() bool        { return true }
+
+func (n *mathBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// mathInlineParser reads math delimited by equal runs of $, the way code
+// spans are delimited by backticks. A \$ is an escaped dollar sign and
+// starts nothing.
+type mathInlineParser struct{}
+
+func (mathInlineParser) Trigger() []byte { return []byte{'$'} }
+
+func (mathInlineParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	open := 0
+	for open < len(line) && line[open] == '$' {
+		open++
+	}
+	for i := open; i < len(line); {
+		if line[i] == '\\' {
+			i += 2
+			continue
+		}
+		if line[i] != '$' {
+			i++
+			continue
+		}
+		end := i
+		for end < len(line) && line[end] == '$' {
+			end++
+		}
+		if end-i != open {
+			i = end
+			continue
+		}
+		tex := line[open:i]
+		// One space on both sides is padding, as in code spans
+		if len(tex) > 2 && tex[0] == ' ' && tex[len(tex)-1] == ' ' {
+			tex = tex[1 : len(tex)-1]
+		}
+		if len(bytes.TrimSpace(tex)) == 0 {
+			return nil
+		}
+		block.Advance(end)
+		return &mathInline{tex: tex}
+	}
+	return nil
+}
+
+// mathBlockParser reads display math between lines starting with $$.
+// "$$ x $$" on one line is a complete block.
+type mathBlockParser struct{}
+
+func (mathBlockParser) Trigger() []byte { return []byte{'$'} }
+
+func (mathBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || !bytes.HasPrefix(line[pos:], []byte("$$")) {
+		return nil, parser.NoChildren
+	}
+	node := &mathBlock{}
+	rest := bytes.TrimSpace(line[pos+2:])
+	if len(rest) == 0 {
+		reader.AdvanceToEOL()
+		return node, parser.NoChildren
+	}
+	start := segment.Start + pos + 2
+	stop := segment.Stop
+	if bytes.HasSuffix(rest, []byte("$$")) {
+		stop = start + bytes.LastIndex(line[pos+2:], []byte("$$"))
+		node.closed = true
+	}
+	seg := text.NewSegment(start, stop)
+	seg.ForceNewline = true
+	node.Lines().Append(seg)
+	reader.AdvanceToEOL()
+	return node, parser.NoChildren
+}
+
+func (mathBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	if node.(*mathBlock).closed {
+		return parser.Close
+	}
+	line, segment := reader.PeekLine()
+	if trimmed := bytes.TrimSpace(line); bytes.HasSuffix(trimmed, []byte("$$")) {
+		if stop := bytes.LastIndex(line, []byte("$$")); len(bytes.TrimSpace(line[:stop])) > 0 {
+			seg := text.NewSegment(segment.Start, segment.Start+stop)
+			seg.ForceNewline = true
+			node.Lines().Append(seg)
+		}
+		reader.AdvanceToEOL()
+		return parser.Close
+	}
+	segment.ForceNewline = true
+	node.Lines().Append(segment)
+	reader.AdvanceToEOL()
+	return parser.Continue | parser.NoChildren
+}
+
+func (mathBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+func (mathBlockParser) CanInterruptParagraph() bool                                { return true }
+func (mathBlockParser) CanAcceptIndentedLine() bool                                { return false }
+
+type mathRenderer struct{}
+
+func (mathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMathInline, renderMathInline)
+	reg.Register(kindMathBlock, renderMathBlock)
+}
+
+func renderMathInline(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		w.WriteString(`<code class="language-math math-inline">`)
+		w.Write(util.EscapeHTML(node.(*mathInline).tex))
+		w.WriteString(`</code>`)
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func renderMathBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	w.WriteString(`<pre><code class="language-math math-display">`)
+	lines := node.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		segment := lines.At(i)
+		w.Write(util.EscapeHTML(segment.Value(source)))
+	}
+	w.WriteString("</code></pre>\n")
+	return ast.WalkContinue, nil
+}
@@ -0,0 +1,215 @@
+package docs
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/text"
+)
+
+// Rendered is a document converted to HTML on the server, for scripts,
+// email digests and clients without JavaScript.
+type Rendered struct {
+	Path        string      `json:"path"`
+	FrontMatter FrontMatter `json:"frontMatter"` // Not part of HTML
+	HTML        string      `json:"html"`        // Sanitized; safe to insert as is
+	Headings    []Heading   `json:"headings"`    // In document order, for a table of contents
+}
+
+type Heading struct {
+	Level int    `json:"level"`
+	Text  string `json:"text"`
+	ID    string `json:"id"` // Anchor of the heading in HTML
+}
+
+// markdown follows the web UI: GitHub Flavored Markdown with footnotes and
+// $ math. Raw HTML in documents is dropped, as it is in the browser.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(
+		extension.NewTable(extension.WithTableCellAlignMethod(extension.TableCellAlignAttribute)),
+		extension.Strikethrough,
+		extension.Linkify,
+		extension.TaskList,
+		extension.Footnote,
+		mathExtension{},
+	),
+)
+
+// renderPolicy is the usual user-content policy plus what the renderer
+// itself produces: code and math classes, heading anchors in any script,
+// and task list checkboxes.
+var renderPolicy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^(language-[\w.+#-]+|language-math math-(inline|display))$`)).OnElements("code")
+	p.AllowAttrs("id").Matching(regexp.MustCompile(`^[\p{L}\p{M}\p{N}_:-]+$`)).OnElements("h1", "h2", "h3", "h4", "h5", "h6")
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
+	return p
+}()
+
+// RenderHandler renders the document named by the path query parameter
+// (GET). Relative images and attachments are pointed at /raw/ and links to
+// other documents at the web UI, so the HTML works anywhere. Math is left
+// as TeX in the classes KaTeX and MathJax look for. The response is JSON
+// with the outline, or just the HTML fragment with format=html.
+func RenderHandler(config Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		relPath := r.URL.Query().Get("path")
+		fullPath, err := docPath(config, relPath)
+		if err != nil {
+			http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		content, info, err := readFile(fullPath)
+		if err != nil || ViewerOf(r).hidden(config, fullPath, content, info) {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+
+		rendered, err := render(path.Clean(relPath), content)
+		if err != nil {
+			http.Error(w, "Failed to render document", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", ETag(info))
+		w.Header().Set("Cache-Control", "no-cache")
+		if r.URL.Query().Get("format") == "html" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(rendered.HTML))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rendered)
+	}
+}
+
+// render converts the document at relPath (relative to the docs directory,
+// used to resolve its links).
+func render(relPath string, content []byte) (Rendered, error) {
+	front, source := parseFrontMatter(content)
+	doc := markdown.Parser().Parse(text.NewReader(source))
+
+	headings := []Heading{}
+	slugs := slugger{}
+	ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := node.(type) {
+		case *ast.Heading:
+			title := plainText(n, source)
+			id := slugs.slug(title)
+			if id != "" {
+				n.SetAttributeString("id", []byte(id))
+			}
+			headings = append(headings, Heading{Level: n.Level, Text: title, ID: id})
+		case *ast.Link:
+			n.Destination = []byte(resolveLink(relPath, string(n.Destination), false))
+		case *ast.Image:
+			n.Destination = []byte(resolveLink(relPath, string(n.Destination), true))
+		}
+		return ast.WalkContinue, nil
+	})
+
+	var buf bytes.Buffer
+	if err := markdown.Renderer().Render(&buf, source, doc); err != nil {
+		return Rendered{}, err
+	}
+	return Rendered{Path: relPath, FrontMatter: front, HTML: renderPolicy.Sanitize(buf.String()), Headings: headings}, nil
+}
+
+// resolveLink points a relative link of the document at relPath at the
+// document route of the web UI (/?doc=) when it names Markdown, and at
+// /raw/ otherwise. Absolute URLs, rooted paths, anchors and links leaving
+// the docs directory are kept as written.
+func resolveLink(relPath, dest string, image bool) string {
+	if dest == "" || strings.HasPrefix(dest, "#") || strings.HasPrefix(dest, "/") {
+		return dest
+	}
+	link, err := url.Parse(dest)
+	if err != nil || link.Scheme != "" || link.Host != "" || link.Path == "" {
+		return dest
+	}
+	target := path.Join(path.Dir(relPath), link.Path)
+	if target == ".." || strings.HasPrefix(target, "../") {
+		return dest
+	}
+
+	if !image && strings.HasSuffix(strings.ToLower(target), ".md") {
+		resolved := "/?doc=" + url.QueryEscape(target)
+		if link.Fragment != "" {
+			resolved += "#" + link.EscapedFragment()
+		}
+		return resolved
+	}
+	return (&url.URL{Path: "/raw/" + target, RawQuery: link.RawQuery, Fragment: link.Fragment}).String()
+}
+
+// plainText is the text of node without markup, as a browser shows it.
+func plainText(node ast.Node, source []byte) string {
+	var sb strings.Builder
+	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Image:
+			return ast.WalkSkipChildren, nil
+		case *ast.Text:
+			sb.Write(n.Segment.Value(source))
+			if n.SoftLineBreak() || n.HardLineBreak() {
+				sb.WriteByte(' ')
+			}
+		case *ast.String:
+			sb.Write(n.Value)
+		case *mathInline:
+			sb.Write(n.tex)
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.TrimSpace(sb.String())
+}
+
+// slugger makes heading IDs the way the web UI does (github-slugger), so
+// anchors are the same in both: lowercase, punctuation dropped, spaces
+// turned into dashes, and repeats numbered.
+type slugger map[string]int
+
+func (s slugger) slug(title string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(title) {
+		switch {
+		case r == ' ':
+			sb.WriteByte('-')
+		case r == '-' || r == '_' || unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsMark(r):
+			sb.WriteRune(r)
+		}
+	}
+	base := sb.String()
+	slug := base
+	for {
+		if _, used := s[slug]; !used {
+			break
+		}
+		s[base]++
+		slug = base + "-" + strconv.Itoa(s[base])
+	}
+	s[slug] = 0
+	return slug
+}
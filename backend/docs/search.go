@@ -0,0 +1,746 @@
+package docs
+
+import (
+	"cmp"
+	"encoding/json"
+	"html"
+	"io/fs"
+	"log"
+	"maps"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxIndexedBytes   = 4 << 20
+	maxPrefixTerms    = 64
+	defaultSearchHits = 20
+	maxSearchHits     = 100
+	snippetBefore     = 60
+	snippetAfter      = 140
+
+	// BM25 parameters
+	bm25K1 = 1.2
+	bm25B  = 0.75
+
+	titleWeight   = 3.0
+	h1Weight      = 3.0
+	headingWeight = 2.0
+	bodyWeight    = 1.0
+)
+
+// Index is an inverted index over the Markdown files the tree shows. It
+// is refreshed incrementally: only files whose size or modification time
+// changed are read again.
+type Index struct {
+	config Config
+	notify chan struct{}
+
+	refreshMu sync.Mutex // One refresh at a time
+
+	pendingMu sync.Mutex
+	pending   map[string]bool // Folders to refresh on the next notify
+	full      bool            // Refresh everything instead
+
+	mu       sync.RWMutex
+	docs     map[int]*indexedDoc
+	byPath   map[string]int
+	postings map[string]map[int]*posting
+	nextID   int
+	totalLen int
+}
+
+type indexedDoc struct {
+	path    string
+	title   string
+	modTime time.Time
+	size    int64
+	text    string
+	length  int
+	terms   []string
+
+	// To hide drafts from other users
+	draft   bool
+	owners  []string
+	authors []string
+}
+
+type posting struct {
+	weight    float64 // Term frequency, boosted in titles and headings
+	positions []int
+}
+
+type SearchHit struct {
+	Path    string  `json:"path"`
+	Title   string  `json:"title"`
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet"` // HTML-escaped, matches wrapped in <mark>
+	ModTime string  `json:"modTime"`
+}
+
+type SearchResult struct {
+	Query string      `json:"query"`
+	Total int         `json:"total"`
+	Hits  []SearchHit `json:"hits"`
+}
+
+func NewIndex(config Config) *Index {
+	return &Index{
+		config:   config,
+		notify:   make(chan struct{}, 1),
+		pending:  map[string]bool{},
+		docs:     map[int]*indexedDoc{},
+		byPath:   map[string]int{},
+		postings: map[string]map[int]*posting{},
+	}
+}
+
+// Run builds the index and refreshes it every interval and whenever
+// Notify is called. It never returns.
+func (ix *Index) Run(interval time.Duration) {
+	start := time.Now()
+	ix.Refresh()
+	ix.mu.RLock()
+	log.Printf("[Search] Indexed %d documents in %v", len(ix.docs), time.Since(start).Round(time.Millisecond))
+	ix.mu.RUnlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ix.Refresh()
+		case <-ix.notify:
+			ix.pendingMu.Lock()
+			dirs, full := slices.Collect(maps.Keys(ix.pending)), ix.full
+			clear(ix.pending)
+			ix.full = false
+			ix.pendingMu.Unlock()
+			if full {
+				ix.Refresh()
+			} else {
+				ix.RefreshDirs(dirs)
+			}
+		}
+	}
+}
+
+// Notify schedules a refresh of the given folders (relative, "" for the
+// root), e.g. after documents changed through the API, or of everything
+// when none are given. Calls made while a refresh is pending are
+// coalesced.
+func (ix *Index) Notify(dirs ...string) {
+	if ix == nil {
+		return
+	}
+	ix.pendingMu.Lock()
+	if len(dirs) == 0 {
+		ix.full = true
+	}
+	for _, dir := range dirs {
+		ix.pending[dir] = true
+	}
+	ix.pendingMu.Unlock()
+
+	select {
+	case ix.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Refresh re-reads new and changed documents and drops deleted ones.
+func (ix *Index) Refresh() {
+	ix.refreshMu.Lock()
+	defer ix.refreshMu.Unlock()
+
+	seen := map[string]bool{}
+	ix.walk("", seen)
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for path, id := range ix.byPath {
+		if !seen[path] {
+			ix.remove(id)
+		}
+	}
+}
+
+// RefreshDirs re-reads the Markdown files directly in dirs and drops the
+// documents of files and folders that are gone from them, without walking
+// the rest of the docs directory. Subfolders with nothing indexed below
+// them, such as ones moved in, are read as a whole.
+func (ix *Index) RefreshDirs(dirs []string) {
+	ix.refreshMu.Lock()
+	defer ix.refreshMu.Unlock()
+
+	for _, dir := range dirs {
+		ix.refreshDir(dir)
+	}
+}
+
+// refreshDir must be called with ix.refreshMu held.
+func (ix *Index) refreshDir(dir string) {
+	seen := map[string]bool{}
+	subdirs := map[string]bool{}
+	entries, err := os.ReadDir(filepath.Join(ix.config.DocsPath, dir))
+	if err != nil || dir != "" && depth(dir) >= ix.config.DocsDepth {
+		entries = nil
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		rel := path.Join(dir, entry.Name())
+		if entry.IsDir() {
+			if depth(rel) < ix.config.DocsDepth {
+				subdirs[entry.Name()] = true
+				if !ix.hasBelow(rel) {
+					ix.walk(rel, seen)
+				}
+			}
+			continue
+		}
+		if ix.read(rel, filepath.Join(ix.config.DocsPath, rel)) {
+			seen[rel] = true
+		}
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for indexed, id := range ix.byPath {
+		rest, ok := strings.CutPrefix(indexed, dir+"/")
+		if dir == "" {
+			rest, ok = indexed, true
+		}
+		if !ok {
+			continue
+		}
+		sub, _, nested := strings.Cut(rest, "/")
+		if nested && !subdirs[sub] || !nested && !seen[indexed] {
+			ix.remove(id)
+		}
+	}
+}
+
+// hasBelow reports whether any document below dir is indexed.
+func (ix *Index) hasBelow(dir string) bool {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	for indexed := range ix.byPath {
+		if strings.HasPrefix(indexed, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// walk reads the documents below root (relative, "" for the whole docs
+// directory) and adds their paths to seen. It must be called with
+// ix.refreshMu held.
+func (ix *Index) walk(root string, seen map[string]bool) {
+	filepath.WalkDir(filepath.Join(ix.config.DocsPath, root), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(ix.config.DocsPath, path)
+		if rel == "." {
+			return nil
+		}
+		// Same visibility rules as the tree: no hidden names, folders
+		// above DocsDepth and Markdown files up to it
+		if strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			if depth(rel) >= ix.config.DocsDepth {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ix.read(rel, path) {
+			seen[rel] = true
+		}
+		return nil
+	})
+}
+
+// read indexes the Markdown file at fullPath unless it is unchanged since
+// it was last read, and reports whether it belongs in the index.
+func (ix *Index) read(rel, fullPath string) bool {
+	if !strings.HasSuffix(strings.ToLower(rel), ".md") {
+		return false
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil || info.IsDir() || info.Size() > maxIndexedBytes {
+		return false
+	}
+
+	ix.mu.RLock()
+	id, known := ix.byPath[rel]
+	unchanged := known && ix.docs[id].size == info.Size() && ix.docs[id].modTime.Equal(info.ModTime())
+	ix.mu.RUnlock()
+	if unchanged {
+		return true
+	}
+
+	content, err := os.ReadFile(fullPath)
+	if err != nil {
+		log.Printf("[Search] [WARN] Reading %s: %v", rel, err)
+		return false
+	}
+	ix.add(rel, info, string(content))
+	return true
+}
+
+// add indexes one document, replacing an older version of it.
+func (ix *Index) add(path string, info os.FileInfo, content string) {
+	front, body := parseFrontMatter([]byte(content))
+	content = string(body)
+	doc := &indexedDoc{path: path, title: front.Title, modTime: info.ModTime(), size: info.Size(), text: content}
+	doc.draft, doc.authors = front.Draft, front.Authors
+	if doc.draft {
+		doc.owners = ix.config.draftOwners(filepath.Join(ix.config.DocsPath, path), info)
+	}
+	found := map[string]*posting{}
+	position := 0
+	addTokens := func(text string, weight float64) {
+		// Titles and headings stand apart, so phrases do not run into
+		// them; body lines continue one another like wrapped paragraphs
+		heading := weight != bodyWeight
+		if heading {
+			position++
+		}
+		for _, tok := range tokenize(text) {
+			p := found[tok.term]
+			if p == nil {
+				p = &posting{}
+				found[tok.term] = p
+			}
+			p.weight += weight
+			p.positions = append(p.positions, position)
+			position++
+		}
+		if heading {
+			position++
+		}
+	}
+
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	addTokens(name, titleWeight)
+	addTokens(front.Title, titleWeight)
+	addTokens(strings.Join(front.Tags, " "), headingWeight)
+	inFence := false
+	for line := range strings.Lines(content) {
+		// Shell comments in code blocks look like headings
+		if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = !inFence
+		}
+		level, text := 0, ""
+		if !inFence {
+			level, text = headingOf(line)
+		}
+		switch {
+		case level == 1:
+			if doc.title == "" {
+				doc.title = text
+			}
+			addTokens(text, h1Weight)
+		case level > 1:
+			addTokens(text, headingWeight)
+		default:
+			addTokens(line, bodyWeight)
+		}
+	}
+	if doc.title == "" {
+		doc.title = name
+	}
+	doc.length = position
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if old, ok := ix.byPath[path]; ok {
+		ix.remove(old)
+	}
+	id := ix.nextID
+	ix.nextID++
+	for term, p := range found {
+		if ix.postings[term] == nil {
+			ix.postings[term] = map[int]*posting{}
+		}
+		ix.postings[term][id] = p
+		doc.terms = append(doc.terms, term)
+	}
+	ix.docs[id] = doc
+	ix.byPath[path] = id
+	ix.totalLen += doc.length
+}
+
+// remove drops a document. It must be called with ix.mu held.
+func (ix *Index) remove(id int) {
+	doc := ix.docs[id]
+	for _, term := range doc.terms {
+		delete(ix.postings[term], id)
+		if len(ix.postings[term]) == 0 {
+			delete(ix.postings, term)
+		}
+	}
+	ix.totalLen -= doc.length
+	delete(ix.docs, id)
+	delete(ix.byPath, doc.path)
+}
+
+// headingOf returns the level and text of an ATX heading line, or 0.
+func headingOf(line string) (int, string) {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 {
+		return 0, ""
+	}
+	level := 0
+	for level < len(trimmed) && trimmed[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 || (level < len(trimmed) && trimmed[level] != ' ' && trimmed[level] != '\t' && trimmed[level] != '\n' && trimmed[level] != '\r') {
+		return 0, ""
+	}
+	return level, strings.TrimSpace(strings.TrimRight(strings.TrimSpace(trimmed[level:]), "#"))
+}
+
+type token struct {
+	term       string
+	start, end int // Byte offsets in the tokenized text
+}
+
+// tokenize splits text into lowercase words. Runs of CJK characters have
+// no spaces to split on, so they become overlapping bigrams ("蛋白质"
+// gives "蛋白" and "白质"), which lets any substring of two or more
+// characters be found as a phrase.
+func tokenize(text string) []token {
+	var tokens []token
+	var cjk []token // Single characters of the current CJK run
+	wordStart := -1
+
+	flushWord := func(end int) {
+		if wordStart >= 0 {
+			tokens = append(tokens, token{strings.ToLower(text[wordStart:end]), wordStart, end})
+			wordStart = -1
+		}
+	}
+	flushCJK := func() {
+		switch len(cjk) {
+		case 0:
+		case 1:
+			tokens = append(tokens, cjk[0])
+		default:
+			for i := 0; i+1 < len(cjk); i++ {
+				tokens = append(tokens, token{cjk[i].term + cjk[i+1].term, cjk[i].start, cjk[i+1].end})
+			}
+		}
+		cjk = cjk[:0]
+	}
+
+	for i, r := range text {
+		switch {
+		case isCJK(r):
+			flushWord(i)
+			size := utf8.RuneLen(r)
+			cjk = append(cjk, token{text[i : i+size], i, i + size})
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushCJK()
+			if wordStart < 0 {
+				wordStart = i
+			}
+		default:
+			flushWord(i)
+			flushCJK()
+		}
+	}
+	flushWord(len(text))
+	flushCJK()
+	return tokens
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// clause is one part of a query: a phrase of consecutive terms (a single
+// word is a phrase of one, a CJK word a phrase of its bigrams) whose last
+// term may be a prefix.
+type clause struct {
+	terms  []string
+	prefix bool
+}
+
+// parseQuery splits q into clauses. "Quoted text" is a phrase and a
+// trailing * makes a word a prefix.
+func parseQuery(q string) []clause {
+	var clauses []clause
+	add := func(text string, prefix bool) {
+		var terms []string
+		for _, tok := range tokenize(text) {
+			terms = append(terms, tok.term)
+		}
+		if len(terms) == 0 {
+			return
+		}
+		// A lone CJK character is only indexed inside bigrams
+		if r, size := utf8.DecodeRuneInString(terms[0]); len(terms) == 1 && size == len(terms[0]) && isCJK(r) {
+			prefix = true
+		}
+		clauses = append(clauses, clause{terms, prefix})
+	}
+
+	for i, part := range strings.Split(q, `"`) {
+		if i%2 == 1 {
+			add(part, false)
+			continue
+		}
+		for _, word := range strings.Fields(part) {
+			add(word, strings.HasSuffix(word, "*"))
+		}
+	}
+	return clauses
+}
+
+// Search returns the documents viewer may read that match every clause of
+// q, best first.
+func (ix *Index) Search(q string, limit int, viewer Viewer) SearchResult {
+	result := SearchResult{Query: q, Hits: []SearchHit{}}
+	clauses := parseQuery(q)
+	if len(clauses) == 0 {
+		return result
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	avgLen := 1.0
+	if len(ix.docs) > 0 {
+		avgLen = max(float64(ix.totalLen)/float64(len(ix.docs)), 1)
+	}
+	scores := map[int]float64{}
+	matched := map[string]bool{}
+	for i, c := range clauses {
+		clauseScores := ix.matchClause(c, avgLen, matched)
+		if i == 0 {
+			scores = clauseScores
+			continue
+		}
+		for id, score := range scores {
+			if extra, ok := clauseScores[id]; ok {
+				scores[id] = score + extra
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	for id, score := range scores {
+		doc := ix.docs[id]
+		if !viewer.mayRead(doc.draft, doc.owners, doc.authors) {
+			continue
+		}
+		result.Hits = append(result.Hits, SearchHit{
+			Path:    doc.path,
+			Title:   doc.title,
+			Score:   math.Round(score*1000) / 1000,
+			ModTime: doc.modTime.Format("2006-01-02 15:04"),
+			Snippet: snippet(doc.text, matched),
+		})
+	}
+	slices.SortFunc(result.Hits, func(a, b SearchHit) int {
+		if a.Score != b.Score {
+			if a.Score > b.Score {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Path, b.Path)
+	})
+	result.Total = len(result.Hits)
+	if len(result.Hits) > limit {
+		result.Hits = result.Hits[:limit]
+	}
+	return result
+}
+
+// matchClause scores the documents containing c with BM25 and adds the
+// terms that matched to matched. It must be called with ix.mu held.
+func (ix *Index) matchClause(c clause, avgLen float64, matched map[string]bool) map[int]float64 {
+	// Candidate terms for each position of the phrase
+	options := make([][]string, len(c.terms))
+	for i, term := range c.terms {
+		options[i] = []string{term}
+		if c.prefix && i == len(c.terms)-1 {
+			options[i] = ix.expandPrefix(term)
+		}
+	}
+
+	scores := map[int]float64{}
+	for _, first := range options[0] {
+		for id, p := range ix.postings[first] {
+			starts := p.positions
+			score := ix.bm25(first, p, id, avgLen)
+			used := []string{first}
+			for i := 1; i < len(options) && len(starts) > 0; i++ {
+				var next []int
+				for _, term := range options[i] {
+					q := ix.postings[term][id]
+					if q == nil {
+						continue
+					}
+					hits := followers(starts, q.positions, i)
+					if len(hits) > 0 {
+						next = append(next, hits...)
+						score += ix.bm25(term, q, id, avgLen)
+						used = append(used, term)
+					}
+				}
+				starts = next
+			}
+			if len(starts) == 0 {
+				continue
+			}
+			scores[id] = max(scores[id], score)
+			for _, term := range used {
+				matched[term] = true
+			}
+		}
+	}
+	return scores
+}
+
+// followers returns the phrase starts for which a position offset places
+// later is in positions.
+func followers(starts, positions []int, offset int) []int {
+	var result []int
+	for _, start := range starts {
+		if _, ok := slices.BinarySearch(positions, start+offset); ok {
+			result = append(result, start)
+		}
+	}
+	return result
+}
+
+// expandPrefix returns indexed terms starting with prefix. A single CJK
+// character is matched anywhere in a bigram. Beyond maxPrefixTerms, the
+// terms in the most documents are kept, so short prefixes give the same
+// results every time.
+func (ix *Index) expandPrefix(prefix string) []string {
+	r, _ := utf8.DecodeRuneInString(prefix)
+	single := isCJK(r) && utf8.RuneCountInString(prefix) == 1
+	var terms []string
+	for term := range ix.postings {
+		if strings.HasPrefix(term, prefix) || single && strings.Contains(term, prefix) {
+			terms = append(terms, term)
+		}
+	}
+	if len(terms) > maxPrefixTerms {
+		slices.SortFunc(terms, func(a, b string) int {
+			if c := cmp.Compare(len(ix.postings[b]), len(ix.postings[a])); c != 0 {
+				return c
+			}
+			return strings.Compare(a, b)
+		})
+		terms = terms[:maxPrefixTerms]
+	}
+	return terms
+}
+
+func (ix *Index) bm25(term string, p *posting, id int, avgLen float64) float64 {
+	n, df := float64(len(ix.docs)), float64(len(ix.postings[term]))
+	idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+	norm := bm25K1 * (1 - bm25B + bm25B*float64(ix.docs[id].length)/avgLen)
+	return idf * p.weight * (bm25K1 + 1) / (p.weight + norm)
+}
+
+// snippet returns an HTML-escaped excerpt of text around its first
+// matching term with every match in it wrapped in <mark>.
+func snippet(text string, matched map[string]bool) string {
+	first := -1
+	for _, tok := range tokenize(text) {
+		if matched[tok.term] {
+			first = tok.start
+			break
+		}
+	}
+	if first < 0 {
+		first = 0
+	}
+
+	start, end := max(first-snippetBefore, 0), min(first+snippetAfter, len(text))
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+	window := text[start:end]
+
+	// Overlapping CJK bigrams produce overlapping ranges; merge them
+	var ranges [][2]int
+	for _, tok := range tokenize(window) {
+		if !matched[tok.term] {
+			continue
+		}
+		if n := len(ranges); n > 0 && tok.start <= ranges[n-1][1] {
+			ranges[n-1][1] = max(ranges[n-1][1], tok.end)
+			continue
+		}
+		ranges = append(ranges, [2]int{tok.start, tok.end})
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	last := 0
+	for _, r := range ranges {
+		b.WriteString(html.EscapeString(window[last:r[0]]))
+		b.WriteString("<mark>" + html.EscapeString(window[r[0]:r[1]]) + "</mark>")
+		last = r[1]
+	}
+	b.WriteString(html.EscapeString(window[last:]))
+	if end < len(text) {
+		b.WriteString("…")
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// Handler serves GET /api/docs/search?q=&limit=. Drafts are only found
+// by the users who may read them.
+func (ix *Index) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := strings.TrimSpace(r.URL.Query().Get("q"))
+		if q == "" {
+			http.Error(w, "Query is required", http.StatusBadRequest)
+			return
+		}
+		limit := defaultSearchHits
+		if value := r.URL.Query().Get("limit"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, maxSearchHits)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ix.Search(q, limit, ViewerOf(r)))
+	}
+}
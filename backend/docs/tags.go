@@ -0,0 +1,64 @@
+package docs
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+type Tag struct {
+	Name  string      `json:"name"`
+	Count int         `json:"count"`
+	Docs  []*FileNode `json:"docs,omitempty"` // Only when asked for one tag
+}
+
+// TagsHandler lists the tags of the documents the user may read, most
+// used first (GET). With the tag query parameter it returns that tag with
+// its documents, in tree order.
+func TagsHandler(config Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var nodes []*FileNode
+		if config.Tree != nil {
+			nodes = config.Tree.Root().Children
+		} else {
+			nodes = walk(config, "", 1)
+		}
+		nodes = ViewerOf(r).hideDrafts(nodes)
+
+		byTag := map[string][]*FileNode{}
+		var collect func(nodes []*FileNode)
+		collect = func(nodes []*FileNode) {
+			for _, node := range nodes {
+				for _, tag := range node.Tags {
+					byTag[tag] = append(byTag[tag], node)
+				}
+				collect(node.Children)
+			}
+		}
+		collect(nodes)
+
+		w.Header().Set("Content-Type", "application/json")
+		if name := r.URL.Query().Get("tag"); name != "" {
+			name = strings.ToLower(strings.TrimSpace(name))
+			docs := byTag[name]
+			if docs == nil {
+				docs = []*FileNode{}
+			}
+			json.NewEncoder(w).Encode(Tag{Name: name, Count: len(docs), Docs: docs})
+			return
+		}
+
+		tags := []Tag{}
+		for name, docs := range byTag {
+			tags = append(tags, Tag{Name: name, Count: len(docs)})
+		}
+		slices.SortFunc(tags, func(a, b Tag) int {
+			if a.Count != b.Count {
+				return b.Count - a.Count
+			}
+			return strings.Compare(a.Name, b.Name)
+		})
+		json.NewEncoder(w).Encode(tags)
+	}
+}
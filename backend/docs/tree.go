@@ -0,0 +1,412 @@
+package docs
+
+import (
+	"LabMD-backend/sse"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	// Editors and copies produce bursts of events; apply them together
+	treeDebounce = 200 * time.Millisecond
+	watchMask    = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+		syscall.IN_CLOSE_WRITE | syscall.IN_ATTRIB | syscall.IN_ONLYDIR
+)
+
+// Tree keeps the docs tree in memory so TreeHandler does not walk the
+// docs directory on every request. Each folder the tree can show is
+// watched with inotify and re-read on its own when it changes; the tree
+// is then derived from memory. A periodic full resync catches what
+// inotify misses, such as changes made by other NFS clients.
+type Tree struct {
+	config   Config
+	broker   *sse.Broker
+	onChange func(dirs ...string)
+	events   chan string // Folders (relative, "" for the root) to re-read
+	resync   chan struct{}
+
+	mu      sync.Mutex
+	dirs    map[string]*dirState
+	fd      int // inotify descriptor, -1 without inotify
+	watches map[int32]string
+	wds     map[string]int32
+
+	// publishMu keeps snapshots in the order they were derived, so a
+	// slower publish cannot replace a newer tree with an older one
+	publishMu sync.Mutex
+	snapshot  atomic.Pointer[treeSnapshot]
+}
+
+// treeSnapshot is the tree as last published. It is replaced, never
+// modified.
+type treeSnapshot struct {
+	root   *FileNode
+	json   []byte
+	drafts bool // Some viewers may not see all of root
+}
+
+// dirState is what the tree needs to know about one folder.
+type dirState struct {
+	files   map[string]treeFile // Markdown files by name
+	subdirs []string            // Names of visible subfolders
+	entries int                 // Non-hidden entries of any kind
+}
+
+// treeFile keeps the size and modification time a file's front matter was
+// read at, so rescans only read files that changed.
+type treeFile struct {
+	node    FileNode
+	front   FrontMatter
+	size    int64
+	modTime time.Time
+}
+
+// NewTree builds the tree and starts watching the docs directory.
+// onChange, if set, runs after every applied change, including ones that
+// leave the visible tree as it was (such as an edit within the minute),
+// with the folders that were re-read; a full resync passes none.
+func NewTree(config Config, onChange func(dirs ...string)) *Tree {
+	t := &Tree{
+		config:   config,
+		broker:   sse.NewBroker(1),
+		onChange: onChange,
+		events:   make(chan string, 1024),
+		resync:   make(chan struct{}, 1),
+		dirs:     map[string]*dirState{},
+		fd:       -1,
+		watches:  map[int32]string{},
+		wds:      map[string]int32{},
+	}
+	t.broker.SetFilter(t.streamFilter)
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		log.Printf("[WARN] Watching docs unavailable, tree updates every resync only: %v", err)
+	} else {
+		t.fd = fd
+	}
+
+	start := time.Now()
+	t.mu.Lock()
+	t.rescan("", true)
+	t.mu.Unlock()
+	t.publish(nil)
+	log.Printf("Docs tree loaded in %v, watching %d folder(s)", time.Since(start).Round(time.Millisecond), len(t.wds))
+	return t
+}
+
+// Run applies inotify events and resyncs every interval. It never
+// returns.
+func (t *Tree) Run(interval time.Duration) {
+	if t.fd >= 0 {
+		go t.readEvents()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	debounce := time.NewTimer(treeDebounce)
+	debounce.Stop()
+	pending := map[string]bool{}
+
+	for {
+		select {
+		case dir := <-t.events:
+			pending[dir] = true
+			debounce.Reset(treeDebounce)
+		case <-debounce.C:
+			t.Update(slices.Collect(maps.Keys(pending))...)
+			clear(pending)
+		case <-ticker.C:
+			t.Resync()
+		case <-t.resync:
+			t.Resync()
+		}
+	}
+}
+
+// Update re-reads the given folders (relative paths) right away. Handlers
+// call it after changing the tree so the next request already sees it.
+func (t *Tree) Update(dirs ...string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	for _, dir := range dirs {
+		if _, ok := t.dirs[dir]; ok {
+			t.rescan(dir, false)
+		}
+	}
+	t.mu.Unlock()
+	t.publish(dirs)
+}
+
+// Resync re-reads every folder.
+func (t *Tree) Resync() {
+	t.mu.Lock()
+	t.rescan("", true)
+	t.mu.Unlock()
+	t.publish(nil)
+}
+
+// Root returns the root node. It is shared and must not be modified.
+func (t *Tree) Root() *FileNode {
+	return t.snapshot.Load().root
+}
+
+// JSON returns the encoded root node as viewer may see it.
+func (t *Tree) JSON(viewer Viewer) []byte {
+	snapshot := t.snapshot.Load()
+	if viewer.Admin || !snapshot.drafts {
+		return snapshot.json
+	}
+	root := *snapshot.root
+	root.Children = viewer.hideDrafts(root.Children)
+	data, _ := json.Marshal(&root)
+	return data
+}
+
+// Stream sends the whole tree as a "tree" event to Server-Sent Events
+// clients on connect and whenever it changes, without the drafts each
+// client may not read.
+func (t *Tree) Stream() *sse.Broker {
+	return t.broker
+}
+
+func (t *Tree) streamFilter(r *http.Request) func(data []byte) []byte {
+	viewer := ViewerOf(r)
+	if viewer.Admin {
+		return nil
+	}
+	return func(data []byte) []byte {
+		if !bytes.Contains(data, []byte(`"draft":true`)) {
+			return data
+		}
+		// Who drafts belong to is not in data, so the current snapshot is
+		// sent instead. It is stored before it is published, so it is never
+		// older than data; a client catching up on older events only gets
+		// the latest tree early.
+		return t.JSON(viewer)
+	}
+}
+
+// rescan re-reads dir, starts on folders that appeared and forgets ones
+// that are gone. Existing subfolders are re-read too when recursive. It
+// must be called with t.mu held.
+func (t *Tree) rescan(dir string, recursive bool) {
+	fullPath := filepath.Join(t.config.DocsPath, dir)
+	entries, err := os.ReadDir(fullPath)
+	if err != nil {
+		if dir != "" {
+			t.forget(dir)
+		}
+		return
+	}
+
+	old := t.dirs[dir]
+	state := &dirState{files: map[string]treeFile{}}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		state.entries++
+		childPath := path.Join(dir, name)
+		if entry.IsDir() {
+			if depth(childPath) < t.config.DocsDepth {
+				state.subdirs = append(state.subdirs, name)
+			}
+			continue
+		}
+		if !strings.HasSuffix(strings.ToLower(name), ".md") {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(fullPath, name))
+		if err != nil {
+			continue
+		}
+		owner, modTime := metadataOf(info)
+		file := treeFile{
+			node:    FileNode{Name: name, Path: childPath, Type: "file", Owner: owner, ModTime: modTime},
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		}
+		if cached, ok := old.cached(name); ok && cached.size == file.size && cached.modTime.Equal(file.modTime) {
+			file.front = cached.front
+		} else {
+			file.front = readFrontMatter(filepath.Join(fullPath, name))
+		}
+		file.node.setFrontMatter(file.front)
+		file.node.setDraftOwners(t.config, filepath.Join(fullPath, name), info)
+		state.files[name] = file
+	}
+
+	if _, watched := t.wds[dir]; !watched {
+		t.watch(dir)
+	}
+	t.dirs[dir] = state
+
+	if old != nil {
+		for _, name := range old.subdirs {
+			if !slices.Contains(state.subdirs, name) {
+				t.forget(path.Join(dir, name))
+			}
+		}
+	}
+	for _, name := range state.subdirs {
+		child := path.Join(dir, name)
+		if _, known := t.dirs[child]; recursive || !known {
+			t.rescan(child, recursive)
+		}
+	}
+}
+
+func (d *dirState) cached(name string) (treeFile, bool) {
+	if d == nil {
+		return treeFile{}, false
+	}
+	file, ok := d.files[name]
+	return file, ok
+}
+
+// forget drops dir and everything below it. It must be called with t.mu
+// held.
+func (t *Tree) forget(dir string) {
+	for known := range t.dirs {
+		if known == dir || strings.HasPrefix(known, dir+"/") {
+			delete(t.dirs, known)
+			if wd, ok := t.wds[known]; ok {
+				syscall.InotifyRmWatch(t.fd, uint32(wd))
+				delete(t.watches, wd)
+				delete(t.wds, known)
+			}
+		}
+	}
+}
+
+func (t *Tree) watch(dir string) {
+	if t.fd < 0 {
+		return
+	}
+	wd, err := syscall.InotifyAddWatch(t.fd, filepath.Join(t.config.DocsPath, dir), watchMask)
+	if err != nil {
+		if errors.Is(err, syscall.ENOSPC) {
+			log.Printf("[WARN] Out of inotify watches at %s/; raise fs.inotify.max_user_watches", dir)
+		}
+		return
+	}
+	// A folder moved within the tree keeps its watch descriptor
+	if previous, ok := t.watches[int32(wd)]; ok {
+		delete(t.wds, previous)
+	}
+	t.watches[int32(wd)] = dir
+	t.wds[dir] = int32(wd)
+}
+
+func (t *Tree) readEvents() {
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := syscall.Read(t.fd, buf)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if err != nil || n <= 0 {
+			log.Printf("[WARN] Reading docs inotify events stopped: %v", err)
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				select {
+				case t.resync <- struct{}{}:
+				default:
+				}
+				continue
+			}
+			t.mu.Lock()
+			dir, ok := t.watches[event.Wd]
+			t.mu.Unlock()
+			if ok {
+				t.events <- dir
+			}
+		}
+	}
+}
+
+// publish derives the tree and sends it to stream clients if it changed.
+// dirs are the folders that were re-read, nil for all of them.
+func (t *Tree) publish(dirs []string) {
+	t.publishMu.Lock()
+	t.mu.Lock()
+	children, _ := t.derive("")
+	drafts := false
+	for _, state := range t.dirs {
+		for _, file := range state.files {
+			drafts = drafts || file.front.Draft
+		}
+	}
+	t.mu.Unlock()
+
+	root := &FileNode{Name: "root", Path: "", Type: "dir", Children: children}
+	data, err := json.Marshal(root)
+	if err != nil {
+		t.publishMu.Unlock()
+		return
+	}
+	// Stored even when data is unchanged, as the owners of drafts are not
+	// part of it
+	previous := t.snapshot.Swap(&treeSnapshot{root: root, json: data, drafts: drafts})
+	if previous == nil || !bytes.Equal(previous.json, data) {
+		t.broker.Publish("tree", data)
+	}
+	t.publishMu.Unlock()
+
+	if t.onChange != nil {
+		t.onChange(dirs...)
+	}
+}
+
+// derive builds the nodes of dir with the rules of walk: folders are
+// shown when they hold Markdown somewhere below or are empty. It must be
+// called with t.mu held.
+func (t *Tree) derive(dir string) ([]*FileNode, bool) {
+	state := t.dirs[dir]
+	if state == nil {
+		return nil, false
+	}
+
+	var nodes []*FileNode
+	for _, file := range state.files {
+		nodes = append(nodes, &file.node)
+	}
+	hasMarkdown := len(nodes) > 0
+	for _, name := range state.subdirs {
+		child := path.Join(dir, name)
+		sub := t.dirs[child]
+		if sub == nil {
+			continue
+		}
+		children, childMarkdown := t.derive(child)
+		if childMarkdown || sub.entries == 0 {
+			nodes = append(nodes, &FileNode{Name: name, Path: child, Type: "dir", Children: children})
+		}
+		hasMarkdown = hasMarkdown || childMarkdown
+	}
+	sortNodes(t.config, nodes)
+	return nodes, hasMarkdown
+}
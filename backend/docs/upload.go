@@ -0,0 +1,242 @@
+package docs
+
+import (
+	"LabMD-backend/audit"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// AttachmentsDir is the folder next to a document that receives its
+// uploads. The tree hides it as long as it holds no Markdown.
+const AttachmentsDir = "attachments"
+
+// DefaultUploadTypes are the MIME types accepted when none are configured.
+// SVG is left out because it can carry scripts.
+var DefaultUploadTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp", "application/pdf"}
+
+var uploadExtensions = map[string]string{
+	"image/png":       ".png",
+	"image/jpeg":      ".jpg",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"image/bmp":       ".bmp",
+	"application/pdf": ".pdf",
+	"application/zip": ".zip",
+	"text/plain":      ".txt",
+}
+
+type Upload struct {
+	Path      string `json:"path"`      // Relative to the docs directory, for /raw/
+	Markdown  string `json:"markdown"`  // Snippet to insert into the document
+	Type      string `json:"type"`      // Detected MIME type
+	Size      int    `json:"size"`      // Bytes
+	Duplicate bool   `json:"duplicate"` // The same content was already uploaded here
+}
+
+// UploadHandler stores files for the document named by the path query
+// parameter in its attachments folder (POST). The body is either
+// multipart/form-data with one or more file parts, or the raw bytes of a
+// single file such as a pasted clipboard image, named by the name query
+// parameter. Types are detected from the content rather than trusted from
+// the client, and files are named by content hash so the same image
+// uploaded twice is stored once.
+func UploadHandler(config Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		relPath := r.URL.Query().Get("path")
+		event := newEvent(r, "docs.upload", relPath)
+		if !allowChange(config, w, r, event) {
+			return
+		}
+		docFile, err := docPath(config, relPath)
+		if err != nil {
+			http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := checkParent(config, docFile); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		files, err := readUploads(w, r, config.MaxUploadBytes)
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge) || errors.Is(err, errUploadTooLarge):
+			http.Error(w, fmt.Sprintf("File larger than %d MB", config.MaxUploadBytes>>20), http.StatusRequestEntityTooLarge)
+			return
+		case err != nil:
+			http.Error(w, "Invalid upload: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		allowed := config.UploadTypes
+		if len(allowed) == 0 {
+			allowed = DefaultUploadTypes
+		}
+		for _, file := range files {
+			if !slices.Contains(allowed, file.mimeType) {
+				http.Error(w, fmt.Sprintf("%s: type %s is not allowed", file.name, file.mimeType), http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+
+		writeMu.Lock()
+		defer writeMu.Unlock()
+
+		docDir := filepath.Dir(docFile)
+		dir := filepath.Join(docDir, AttachmentsDir)
+		uid, gid := ownerIDs(config, event.User)
+		if info, err := os.Lstat(dir); errors.Is(err, os.ErrNotExist) {
+			err = config.createFolder(dir, uid, gid, event.User)
+			if err != nil {
+				log.Printf("[ERROR] Creating %s: %v", dir, err)
+				http.Error(w, "Failed to create attachments folder", http.StatusInternalServerError)
+				return
+			}
+		} else if err != nil || !info.IsDir() {
+			http.Error(w, AttachmentsDir+" next to the document is not a folder", http.StatusConflict)
+			return
+		}
+
+		uploads := []Upload{}
+		for _, file := range files {
+			sum := sha256.Sum256(file.data)
+			name := hex.EncodeToString(sum[:8]) + extensionFor(file.mimeType)
+			fullPath := filepath.Join(dir, name)
+
+			err := config.createFile(fullPath, file.data, uid, gid, event.User)
+			duplicate := errors.Is(err, os.ErrExist)
+			if err != nil && !duplicate {
+				log.Printf("[ERROR] Saving upload %s: %v", fullPath, err)
+				event.Result, event.Detail = audit.Failure, err.Error()
+				config.Audit.RecordRequest(r, event)
+				http.Error(w, "Failed to save "+file.name, http.StatusInternalServerError)
+				return
+			}
+
+			link := AttachmentsDir + "/" + name
+			rawPath, _ := filepath.Rel(config.DocsPath, fullPath)
+			uploads = append(uploads, Upload{
+				Path:      filepath.ToSlash(rawPath),
+				Markdown:  markdownLink(file.name, file.mimeType, link),
+				Type:      file.mimeType,
+				Size:      len(file.data),
+				Duplicate: duplicate,
+			})
+			event.Target = filepath.ToSlash(rawPath)
+			event.Result, event.Detail = audit.Success, fmt.Sprintf("%s from %q, %d bytes", file.mimeType, file.name, len(file.data))
+			config.Audit.RecordRequest(r, event)
+		}
+
+		config.changed(dir)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(uploads)
+	}
+}
+
+type uploadFile struct {
+	name     string // Client's file name, only used for the link text
+	mimeType string
+	data     []byte
+}
+
+var errUploadTooLarge = errors.New("file too large")
+
+// readUploads reads every file of the request, each at most maxBytes.
+func readUploads(w http.ResponseWriter, r *http.Request, maxBytes int64) ([]uploadFile, error) {
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		body := http.MaxBytesReader(w, r.Body, maxBytes)
+		file, err := readUpload(r.URL.Query().Get("name"), body, maxBytes)
+		if err != nil {
+			return nil, err
+		}
+		return []uploadFile{file}, nil
+	}
+
+	// Allow a handful of files of the maximum size per request
+	body := http.MaxBytesReader(w, r.Body, 10*maxBytes+1<<20)
+	reader := multipart.NewReader(body, params["boundary"])
+	var files []uploadFile
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FileName() == "" {
+			part.Close()
+			continue
+		}
+		file, err := readUpload(part.FileName(), part, maxBytes)
+		part.Close()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no file parts")
+	}
+	return files, nil
+}
+
+func readUpload(name string, r io.Reader, maxBytes int64) (uploadFile, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	switch {
+	case err != nil:
+		return uploadFile{}, err
+	case int64(len(data)) > maxBytes:
+		return uploadFile{}, errUploadTooLarge
+	case len(data) == 0:
+		return uploadFile{}, errors.New("empty file")
+	}
+	mimeType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	return uploadFile{name: filepath.Base(name), mimeType: mimeType, data: data}, nil
+}
+
+func extensionFor(mimeType string) string {
+	if ext, ok := uploadExtensions[mimeType]; ok {
+		return ext
+	}
+	if exts, _ := mime.ExtensionsByType(mimeType); len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+// markdownLink embeds images and links everything else, titled by the
+// client's file name. Clipboard images arrive with generic names such as
+// "image.png", which still make serviceable alt text.
+func markdownLink(name, mimeType, link string) string {
+	title := strings.TrimSuffix(name, filepath.Ext(name))
+	title = strings.NewReplacer("[", "", "]", "", "\n", " ").Replace(title)
+	if title == "" || title == "." {
+		title = "image"
+		if !strings.HasPrefix(mimeType, "image/") {
+			title = "attachment"
+		}
+	}
+	if strings.HasPrefix(mimeType, "image/") {
+		return fmt.Sprintf("![%s](%s)", title, link)
+	}
+	return fmt.Sprintf("[%s](%s)", title, link)
+}
@@ -0,0 +1,226 @@
+package exporter
+
+import (
+	"LabMD-backend/metrics"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	FormatInflux = "influx"
+	FormatOTLP   = "otlp"
+
+	maxBackoff = 30 * time.Second
+)
+
+type Config struct {
+	Format      string
+	URL         string
+	Headers     map[string]string
+	IntervalSec int
+	BatchSize   int
+	TimeoutSec  int
+	MaxRetries  int
+	SpoolDir    string
+	SpoolMaxMB  int
+}
+
+// Point is one sampled snapshot, flattened into metric families.
+type Point struct {
+	Time     time.Time
+	Host     string
+	Version  string
+	Families []metrics.Family
+}
+
+type Encoder interface {
+	ContentType() string
+	Extension() string
+	Encode(points []Point) ([]byte, error)
+}
+
+type Exporter struct {
+	config  Config
+	encoder Encoder
+	source  func() metrics.Snapshot
+	client  *http.Client
+	spool   *spool
+	logf    func(string, ...any)
+	backoff time.Duration
+
+	mu      sync.Mutex
+	pending []Point
+}
+
+// errPermanent marks a rejection that retrying or spooling cannot fix.
+var errPermanent = errors.New("permanent push failure")
+
+func New(config Config, source func() metrics.Snapshot, logf func(string, ...any)) (*Exporter, error) {
+	var encoder Encoder
+	switch config.Format {
+	case FormatInflux:
+		encoder = influxEncoder{}
+	case FormatOTLP:
+		encoder = otlpEncoder{}
+	default:
+		return nil, fmt.Errorf("unknown exporter format: %q", config.Format)
+	}
+	if config.URL == "" {
+		return nil, errors.New("exporter URL is required")
+	}
+
+	e := &Exporter{
+		config:  config,
+		encoder: encoder,
+		source:  source,
+		client:  &http.Client{Timeout: time.Duration(config.TimeoutSec) * time.Second},
+		logf:    logf,
+		backoff: time.Second,
+	}
+	if config.SpoolDir != "" {
+		s, err := newSpool(config.SpoolDir, encoder.Extension(), int64(config.SpoolMaxMB)*1024*1024)
+		if err != nil {
+			return nil, err
+		}
+		e.spool = s
+	}
+	return e, nil
+}
+
+// Start samples the source every IntervalSec and pushes once BatchSize
+// points have accumulated.
+func (e *Exporter) Start() {
+	go func() {
+		ticker := time.NewTicker(time.Duration(e.config.IntervalSec) * time.Second)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if e.Sample() >= e.config.BatchSize {
+				e.Flush()
+			}
+		}
+	}()
+}
+
+// Sample appends the current snapshot to the batch and returns its size.
+func (e *Exporter) Sample() int {
+	snapshot := e.source()
+	point := Point{
+		Time:     time.Now(),
+		Host:     snapshot.System.Hostname,
+		Version:  snapshot.Version,
+		Families: metrics.Collect(snapshot),
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.pending = append(e.pending, point)
+	return len(e.pending)
+}
+
+// Flush pushes all pending points. Payloads that cannot be delivered after
+// MaxRetries attempts are moved to the spool and replayed after the next
+// successful push.
+func (e *Exporter) Flush() {
+	e.mu.Lock()
+	points := e.pending
+	e.pending = nil
+	e.mu.Unlock()
+	if len(points) == 0 {
+		return
+	}
+
+	payload, err := e.encoder.Encode(points)
+	if err != nil {
+		e.log("Encode failed: %v", err)
+		return
+	}
+
+	if err := e.pushWithRetry(payload); err != nil {
+		if errors.Is(err, errPermanent) || e.spool == nil {
+			e.log("Dropping batch: %v", err)
+			return
+		}
+		if err := e.spool.put(payload); err != nil {
+			e.log("Spool write failed: %v", err)
+		}
+		return
+	}
+
+	e.drainSpool()
+}
+
+func (e *Exporter) drainSpool() {
+	if e.spool == nil {
+		return
+	}
+
+	for {
+		name, payload, ok := e.spool.oldest()
+		if !ok {
+			return
+		}
+		if err := e.push(payload); err != nil && !errors.Is(err, errPermanent) {
+			e.log("Spool replay paused: %v", err)
+			return
+		}
+		e.spool.remove(name)
+	}
+}
+
+func (e *Exporter) pushWithRetry(payload []byte) error {
+	var err error
+	for attempt := 0; attempt <= e.config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(e.backoff)
+			e.backoff = min(e.backoff*2, maxBackoff)
+		}
+
+		err = e.push(payload)
+		if err == nil || errors.Is(err, errPermanent) {
+			break
+		}
+	}
+	if err == nil {
+		e.backoff = time.Second
+	}
+	return err
+}
+
+func (e *Exporter) push(payload []byte) error {
+	req, err := http.NewRequest(http.MethodPost, e.config.URL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPermanent, err)
+	}
+	req.Header.Set("Content-Type", e.encoder.ContentType())
+	for key, value := range e.config.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("endpoint returned %s", resp.Status)
+	default:
+		return fmt.Errorf("%w: endpoint returned %s", errPermanent, resp.Status)
+	}
+}
+
+func (e *Exporter) log(format string, args ...any) {
+	if e.logf != nil {
+		e.logf("[Exporter] "+format, args...)
+	}
+}
@@ -0,0 +1,366 @@
+package exporter
+
+import (
+	"LabMD-backend/metrics"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeReceiver stands in for an InfluxDB or OTLP endpoint. It answers with
+// the queued statuses in turn, then with 204, and records every request.
+type fakeReceiver struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	requests []receivedRequest
+}
+
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+	at     time.Time
+}
+
+func newFakeReceiver(t *testing.T, statuses ...int) *fakeReceiver {
+	t.Helper()
+	rcv := &fakeReceiver{statuses: statuses}
+	rcv.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rcv.mu.Lock()
+		rcv.requests = append(rcv.requests, receivedRequest{header: r.Header.Clone(), body: body, at: time.Now()})
+		status := http.StatusNoContent
+		if len(rcv.statuses) > 0 {
+			status, rcv.statuses = rcv.statuses[0], rcv.statuses[1:]
+		}
+		rcv.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(rcv.server.Close)
+	return rcv
+}
+
+// respond queues statuses for the next requests.
+func (rcv *fakeReceiver) respond(statuses ...int) {
+	rcv.mu.Lock()
+	rcv.statuses = append(rcv.statuses, statuses...)
+	rcv.mu.Unlock()
+}
+
+func (rcv *fakeReceiver) received() []receivedRequest {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return append([]receivedRequest(nil), rcv.requests...)
+}
+
+// counterSource returns snapshots whose CPU load counts up from 1, so each
+// sample can be told apart in a payload.
+func counterSource() func() metrics.Snapshot {
+	load := 0
+	return func() metrics.Snapshot {
+		load++
+		var s metrics.Snapshot
+		s.Version = "v1.2.3"
+		s.System.Hostname = "node 1"
+		s.CPU.Load = load
+		return s
+	}
+}
+
+func newTestExporter(t *testing.T, config Config) *Exporter {
+	t.Helper()
+	if config.TimeoutSec == 0 {
+		config.TimeoutSec = 5
+	}
+	e, err := New(config, counterSource(), t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Keep retries fast; the doubling is what is tested
+	e.backoff = 5 * time.Millisecond
+	return e
+}
+
+// influxValues returns the values of measurement in line protocol, in order.
+func influxValues(t *testing.T, payload []byte, measurement string) []string {
+	t.Helper()
+	var values []string
+	for _, line := range strings.Split(strings.TrimSpace(string(payload)), "\n") {
+		name, _, _ := strings.Cut(line, ",")
+		if name != measurement {
+			continue
+		}
+		// The tags may hold escaped spaces; field and timestamp come last
+		fields := strings.Fields(line)
+		if len(fields) < 3 || !strings.HasPrefix(fields[len(fields)-2], "value=") {
+			t.Fatalf("malformed line %q", line)
+		}
+		values = append(values, strings.TrimPrefix(fields[len(fields)-2], "value="))
+	}
+	return values
+}
+
+func TestInfluxBatch(t *testing.T) {
+	rcv := newFakeReceiver(t)
+	e := newTestExporter(t, Config{
+		Format:  FormatInflux,
+		URL:     rcv.server.URL + "/api/v2/write?bucket=lab",
+		Headers: map[string]string{"Authorization": "Token secret"},
+	})
+
+	for i := 1; i <= 3; i++ {
+		if n := e.Sample(); n != i {
+			t.Fatalf("Sample = %d, want %d", n, i)
+		}
+	}
+	if len(rcv.received()) != 0 {
+		t.Fatal("pushed before Flush")
+	}
+	e.Flush()
+
+	requests := rcv.received()
+	if len(requests) != 1 {
+		t.Fatalf("%d requests, want one batch", len(requests))
+	}
+	req := requests[0]
+	if got := req.header.Get("Authorization"); got != "Token secret" {
+		t.Errorf("Authorization = %q", got)
+	}
+	if got := req.header.Get("Content-Type"); !strings.HasPrefix(got, "text/plain") {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := influxValues(t, req.body, "labmd_cpu_usage_percent"); strings.Join(got, " ") != "1 2 3" {
+		t.Errorf("cpu values = %v, want one per sample in order", got)
+	}
+
+	var timestamps []int64
+	for _, line := range strings.Split(strings.TrimSpace(string(req.body)), "\n") {
+		if !strings.HasPrefix(line, "labmd_cpu_usage_percent,host=node\\ 1 ") {
+			continue
+		}
+		fields := strings.Fields(line)
+		ts, err := strconv.ParseInt(fields[len(fields)-1], 10, 64)
+		if err != nil {
+			t.Fatalf("timestamp of %q: %v", line, err)
+		}
+		timestamps = append(timestamps, ts)
+	}
+	if len(timestamps) != 3 || timestamps[0] > timestamps[1] || timestamps[1] > timestamps[2] {
+		t.Errorf("timestamps = %v, want three in sample order with the host tag escaped", timestamps)
+	}
+
+	e.Flush()
+	if len(rcv.received()) != 1 {
+		t.Error("an empty batch was pushed")
+	}
+}
+
+func TestInfluxEscaping(t *testing.T) {
+	payload, err := influxEncoder{}.Encode([]Point{{
+		Time: time.Unix(0, 42),
+		Host: "gpu,node=1",
+		Families: []metrics.Family{{
+			Name: "labmd_gpu_utilization_percent",
+			Metrics: []metrics.Metric{{
+				Labels: []metrics.Label{{Name: "name", Value: "RTX 4090"}, {Name: "empty", Value: ""}},
+				Value:  87.5,
+			}},
+		}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `labmd_gpu_utilization_percent,host=gpu\,node\=1,name=RTX\ 4090 value=87.5 42` + "\n"
+	if string(payload) != want {
+		t.Errorf("payload = %q, want %q", payload, want)
+	}
+}
+
+func TestOTLPBatch(t *testing.T) {
+	rcv := newFakeReceiver(t)
+	e := newTestExporter(t, Config{Format: FormatOTLP, URL: rcv.server.URL + "/v1/metrics"})
+
+	e.Sample()
+	e.Sample()
+	e.Flush()
+
+	requests := rcv.received()
+	if len(requests) != 1 {
+		t.Fatalf("%d requests, want one batch", len(requests))
+	}
+	if got := requests[0].header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	var body otlpRequest
+	if err := json.Unmarshal(requests[0].body, &body); err != nil {
+		t.Fatalf("decode: %v\n%s", err, requests[0].body)
+	}
+	if len(body.ResourceMetrics) != 1 || len(body.ResourceMetrics[0].ScopeMetrics) != 1 {
+		t.Fatalf("resource metrics = %+v", body.ResourceMetrics)
+	}
+	resource := map[string]string{}
+	for _, attr := range body.ResourceMetrics[0].Resource.Attributes {
+		resource[attr.Key] = attr.Value.StringValue
+	}
+	if resource["service.name"] != "labmd" || resource["host.name"] != "node 1" || resource["service.version"] != "v1.2.3" {
+		t.Errorf("resource = %v", resource)
+	}
+
+	seen := map[string]bool{}
+	var cpu *otlpMetric
+	for i, metric := range body.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		if seen[metric.Name] {
+			t.Errorf("%s appears twice; samples of a batch share one gauge", metric.Name)
+		}
+		seen[metric.Name] = true
+		if metric.Name == "labmd_cpu_usage_percent" {
+			cpu = &body.ResourceMetrics[0].ScopeMetrics[0].Metrics[i]
+		}
+	}
+	if cpu == nil {
+		t.Fatal("no labmd_cpu_usage_percent metric")
+	}
+	points := cpu.Gauge.DataPoints
+	if len(points) != 2 || points[0].AsDouble != 1 || points[1].AsDouble != 2 {
+		t.Fatalf("cpu data points = %+v, want one per sample", points)
+	}
+	first, _ := strconv.ParseInt(points[0].TimeUnixNano, 10, 64)
+	second, _ := strconv.ParseInt(points[1].TimeUnixNano, 10, 64)
+	if first == 0 || first > second {
+		t.Errorf("timestamps = %s, %s", points[0].TimeUnixNano, points[1].TimeUnixNano)
+	}
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	rcv := newFakeReceiver(t, http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusBadGateway)
+	e := newTestExporter(t, Config{Format: FormatInflux, URL: rcv.server.URL, MaxRetries: 3})
+
+	e.Sample()
+	e.Flush()
+
+	requests := rcv.received()
+	if len(requests) != 4 {
+		t.Fatalf("%d attempts, want the first plus 3 retries", len(requests))
+	}
+	for i := 1; i < len(requests); i++ {
+		if string(requests[i].body) != string(requests[0].body) {
+			t.Errorf("attempt %d sent a different payload", i+1)
+		}
+		// 5ms, 10ms, 20ms
+		want := 5 * time.Millisecond << (i - 1)
+		if gap := requests[i].at.Sub(requests[i-1].at); gap < want {
+			t.Errorf("attempt %d came %v after the previous one, want at least %v", i+1, gap, want)
+		}
+	}
+	if e.backoff != time.Second {
+		t.Errorf("backoff = %v after a success, want it reset to 1s", e.backoff)
+	}
+}
+
+func TestPermanentFailureIsNotRetried(t *testing.T) {
+	rcv := newFakeReceiver(t, http.StatusBadRequest)
+	spoolDir := t.TempDir()
+	e := newTestExporter(t, Config{Format: FormatInflux, URL: rcv.server.URL, MaxRetries: 3, SpoolDir: spoolDir})
+
+	e.Sample()
+	e.Flush()
+
+	if n := len(rcv.received()); n != 1 {
+		t.Errorf("%d attempts, want 1 for a 400", n)
+	}
+	if names := e.spool.files(); len(names) != 0 {
+		t.Errorf("spooled %v; a rejected batch cannot succeed later", names)
+	}
+}
+
+func TestSpoolReplay(t *testing.T) {
+	rcv := newFakeReceiver(t)
+	spoolDir := t.TempDir()
+	e := newTestExporter(t, Config{Format: FormatInflux, URL: rcv.server.URL, MaxRetries: 1, SpoolDir: spoolDir})
+
+	// The endpoint is down for two batches, each tried twice
+	rcv.respond(http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	e.Sample()
+	e.Flush()
+	rcv.respond(http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	e.Sample()
+	e.Flush()
+
+	if names := e.spool.files(); len(names) != 2 {
+		t.Fatalf("spool = %v, want both undelivered batches", names)
+	}
+	if n := len(rcv.received()); n != 4 {
+		t.Fatalf("%d attempts while down, want 4", n)
+	}
+
+	// Back up: the new batch goes first, then the spool oldest first
+	e.Sample()
+	e.Flush()
+
+	var delivered []string
+	for _, req := range rcv.received()[4:] {
+		delivered = append(delivered, influxValues(t, req.body, "labmd_cpu_usage_percent")...)
+	}
+	if strings.Join(delivered, " ") != "3 1 2" {
+		t.Errorf("delivered cpu values %v, want the new batch then the spooled ones in order", delivered)
+	}
+	if names := e.spool.files(); len(names) != 0 {
+		t.Errorf("spool still holds %v after replay", names)
+	}
+}
+
+func TestSpoolReplayPausesWhileDown(t *testing.T) {
+	rcv := newFakeReceiver(t)
+	spoolDir := t.TempDir()
+	e := newTestExporter(t, Config{Format: FormatInflux, URL: rcv.server.URL, MaxRetries: 0, SpoolDir: spoolDir})
+
+	rcv.respond(http.StatusServiceUnavailable)
+	e.Sample()
+	e.Flush()
+	rcv.respond(http.StatusServiceUnavailable)
+	e.Sample()
+	e.Flush()
+
+	// The next batch gets through, then the endpoint fails again on the
+	// first replay
+	rcv.respond(http.StatusNoContent, http.StatusServiceUnavailable)
+	e.Sample()
+	e.Flush()
+
+	if names := e.spool.files(); len(names) != 2 {
+		t.Errorf("spool = %v, want both batches kept for the next replay", names)
+	}
+}
+
+func TestSpoolTrim(t *testing.T) {
+	s, err := newSpool(t.TempDir(), "lp", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, payload := range []string{"first", "second", "third"} {
+		if err := s.put([]byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond) // Distinct timestamps in the names
+	}
+
+	names := s.files()
+	if len(names) != 1 {
+		t.Fatalf("spool = %v, want only the newest batch within 10 bytes", names)
+	}
+	if _, payload, _ := s.oldest(); string(payload) != "third" {
+		t.Errorf("kept %q, want the newest batch", payload)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(s.dir, ".*")); len(leftovers) != 0 {
+		t.Errorf("temporary files left: %v", leftovers)
+	}
+}
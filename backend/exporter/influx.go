@@ -0,0 +1,45 @@
+package exporter
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// influxEncoder writes InfluxDB line protocol with nanosecond timestamps.
+// Each metric family becomes a measurement with a single "value" field.
+type influxEncoder struct{}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+func (influxEncoder) ContentType() string { return "text/plain; charset=utf-8" }
+
+func (influxEncoder) Extension() string { return "lp" }
+
+func (influxEncoder) Encode(points []Point) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, point := range points {
+		timestamp := strconv.FormatInt(point.Time.UnixNano(), 10)
+		for _, family := range point.Families {
+			for _, metric := range family.Metrics {
+				buf.WriteString(measurementEscaper.Replace(family.Name))
+				if point.Host != "" {
+					buf.WriteString(",host=" + tagEscaper.Replace(point.Host))
+				}
+				for _, label := range metric.Labels {
+					// Influx rejects empty tag values
+					if label.Value == "" {
+						continue
+					}
+					buf.WriteString("," + tagEscaper.Replace(label.Name) + "=" + tagEscaper.Replace(label.Value))
+				}
+				buf.WriteString(" value=" + strconv.FormatFloat(metric.Value, 'f', -1, 64))
+				buf.WriteString(" " + timestamp + "\n")
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,109 @@
+package exporter
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// otlpEncoder writes OTLP/HTTP metrics using the JSON protobuf mapping, so
+// collectors accept it on /v1/metrics without a protobuf dependency.
+type otlpEncoder struct{}
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpMetric struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Gauge       otlpGauge `json:"gauge"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpDataPoint struct {
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	TimeUnixNano string          `json:"timeUnixNano"`
+	AsDouble     float64         `json:"asDouble"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+func (otlpEncoder) ContentType() string { return "application/json" }
+
+func (otlpEncoder) Extension() string { return "json" }
+
+func (otlpEncoder) Encode(points []Point) ([]byte, error) {
+	if len(points) == 0 {
+		return json.Marshal(otlpRequest{})
+	}
+
+	// Merge all points into one gauge per family, keeping first-seen order
+	index := make(map[string]int)
+	var metrics []otlpMetric
+	for _, point := range points {
+		timestamp := strconv.FormatInt(point.Time.UnixNano(), 10)
+		for _, family := range point.Families {
+			i, ok := index[family.Name]
+			if !ok {
+				i = len(metrics)
+				index[family.Name] = i
+				metrics = append(metrics, otlpMetric{Name: family.Name, Description: family.Help})
+			}
+			for _, metric := range family.Metrics {
+				dp := otlpDataPoint{TimeUnixNano: timestamp, AsDouble: metric.Value}
+				for _, label := range metric.Labels {
+					dp.Attributes = append(dp.Attributes, stringAttribute(label.Name, label.Value))
+				}
+				metrics[i].Gauge.DataPoints = append(metrics[i].Gauge.DataPoints, dp)
+			}
+		}
+	}
+
+	last := points[len(points)-1]
+	return json.Marshal(otlpRequest{
+		ResourceMetrics: []otlpResourceMetrics{{
+			Resource: otlpResource{Attributes: []otlpAttribute{
+				stringAttribute("service.name", "labmd"),
+				stringAttribute("service.version", last.Version),
+				stringAttribute("host.name", last.Host),
+			}},
+			ScopeMetrics: []otlpScopeMetrics{{
+				Scope:   otlpScope{Name: "labmd", Version: last.Version},
+				Metrics: metrics,
+			}},
+		}},
+	})
+}
+
+func stringAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{StringValue: value}}
+}
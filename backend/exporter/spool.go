@@ -0,0 +1,105 @@
+package exporter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// spool keeps undelivered payloads on disk, one file per batch. File names
+// start with a nanosecond timestamp so lexical order is delivery order.
+type spool struct {
+	sync.Mutex
+	dir      string
+	ext      string
+	maxBytes int64
+}
+
+func newSpool(dir, ext string, maxBytes int64) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create spool dir: %w", err)
+	}
+	return &spool{dir: dir, ext: "." + ext, maxBytes: maxBytes}, nil
+}
+
+func (s *spool) put(payload []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	name := fmt.Sprintf("%020d%s", time.Now().UnixNano(), s.ext)
+	tmp := filepath.Join(s.dir, "."+name)
+	if err := os.WriteFile(tmp, payload, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	s.trim()
+	return nil
+}
+
+func (s *spool) oldest() (string, []byte, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, name := range s.files() {
+		payload, err := os.ReadFile(filepath.Join(s.dir, name))
+		if err != nil {
+			continue
+		}
+		return name, payload, true
+	}
+	return "", nil, false
+}
+
+func (s *spool) remove(name string) {
+	s.Lock()
+	defer s.Unlock()
+	os.Remove(filepath.Join(s.dir, name))
+}
+
+// trim drops the oldest batches until the spool fits in maxBytes.
+func (s *spool) trim() {
+	if s.maxBytes <= 0 {
+		return
+	}
+
+	names := s.files()
+	sizes := make([]int64, len(names))
+	var total int64
+	for i, name := range names {
+		if info, err := os.Stat(filepath.Join(s.dir, name)); err == nil {
+			sizes[i] = info.Size()
+			total += sizes[i]
+		}
+	}
+
+	for i := 0; i < len(names) && total > s.maxBytes; i++ {
+		os.Remove(filepath.Join(s.dir, names[i]))
+		total -= sizes[i]
+	}
+}
+
+func (s *spool) files() []string {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, s.ext) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
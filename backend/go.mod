@@ -2,4 +2,20 @@ module LabMD-backend
 
 go 1.25.5
 
-require github.com/NVIDIA/go-nvml v0.13.0-1
+require (
+	github.com/NVIDIA/go-nvml v0.13.0-1
+	github.com/go-ldap/ldap/v3 v3.4.12
+	github.com/microcosm-cc/bluemonday v1.0.27
+	github.com/yuin/goldmark v1.8.2
+	golang.org/x/crypto v0.54.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/Azure/go-ntlmssp v0.0.0-20221128193559-754e69321358 // indirect
+	github.com/aymerick/douceur v0.2.0 // indirect
+	github.com/go-asn1-ber/asn1-ber v1.5.8-0.20250403174932-29230038a667 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/gorilla/css v1.0.1 // indirect
+	golang.org/x/net v0.56.0 // indirect
+)
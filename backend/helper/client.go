@@ -0,0 +1,112 @@
+package helper
+
+import (
+	"LabMD-backend/monitor"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	ownersTimeout = 5 * time.Second
+	// du over every home directory can take a long time on large disks
+	scanTimeout = 2 * time.Hour
+)
+
+// Client implements monitor.Privileged by asking the root helper.
+type Client struct {
+	socket string
+	logf   func(string, ...any)
+
+	mu      sync.Mutex
+	failing bool
+}
+
+func NewClient(socket string, logf func(string, ...any)) *Client {
+	return &Client{socket: socket, logf: logf}
+}
+
+func (c *Client) UserUsage() ([]monitor.UserUsage, error) {
+	resp, err := c.call(Request{Op: OpUserUsage}, scanTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Usage, nil
+}
+
+func (c *Client) ProcessOwners(pids []int) (map[int]string, error) {
+	resp, err := c.call(Request{Op: OpProcessOwners, PIDs: pids}, ownersTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Owners, nil
+}
+
+// ChownDoc gives path, relative to the docs directory, to uid:gid. The
+// helper only does so for files and folders the server owns.
+func (c *Client) ChownDoc(path string, uid, gid int) error {
+	_, err := c.call(Request{Op: OpChownDoc, Path: path, UID: uid, GID: gid}, ownersTimeout)
+	return err
+}
+
+// remoteError is an error the helper answered with, so it is reachable.
+type remoteError string
+
+func (e remoteError) Error() string { return string(e) }
+
+func (c *Client) call(req Request, timeout time.Duration) (Response, error) {
+	resp, err := c.exchange(req, timeout)
+	if errors.As(err, new(remoteError)) {
+		c.noteResult(nil)
+	} else {
+		c.noteResult(err)
+	}
+	return resp, err
+}
+
+func (c *Client) exchange(req Request, timeout time.Duration) (Response, error) {
+	conn, err := net.DialTimeout("unix", c.socket, ownersTimeout)
+	if err != nil {
+		return Response{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return Response{}, err
+	}
+
+	var resp Response
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&resp); err != nil {
+		return Response{}, err
+	}
+	if resp.Error != "" {
+		return Response{}, remoteError(resp.Error)
+	}
+	return resp, nil
+}
+
+// noteResult logs when the helper becomes unreachable and when it comes
+// back, rather than on every failed call.
+func (c *Client) noteResult(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case err != nil && !c.failing:
+		c.failing = true
+		c.log("[WARN] Root helper at %s unavailable: %v", c.socket, err)
+	case err == nil && c.failing:
+		c.failing = false
+		c.log("Root helper at %s reachable again", c.socket)
+	}
+}
+
+func (c *Client) log(format string, args ...any) {
+	if c.logf != nil {
+		c.logf("[Helper] "+format, args...)
+	}
+}
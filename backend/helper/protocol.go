@@ -0,0 +1,94 @@
+// Package helper splits the few collection steps that need root out of the
+// web server. The root helper listens on a unix socket and answers a fixed
+// set of requests; the unprivileged server talks to it through Client.
+//
+// Each connection carries exactly one exchange: a single JSON Request
+// terminated by a newline, answered by a single JSON Response. Requests
+// name no commands, and the only path one may carry is relative to the
+// docs directory, so the helper only ever does what it was built to do.
+package helper
+
+import (
+	"LabMD-backend/monitor"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	// OpUserUsage sizes each directory below monitor.UserHomeBase.
+	OpUserUsage = "userUsage"
+	// OpProcessOwners resolves the owning user of up to maxPIDs processes.
+	OpProcessOwners = "processOwners"
+	// OpChownDoc gives a file or folder the server owns in the docs
+	// directory to another user, so documents saved from the web keep
+	// their author.
+	OpChownDoc = "chownDoc"
+
+	maxRequestBytes = 64 << 10
+	maxPIDs         = 4096
+	maxPathBytes    = 4096
+)
+
+type Request struct {
+	Op   string `json:"op"`
+	PIDs []int  `json:"pids,omitempty"`
+
+	// chownDoc: Path is relative to the docs directory
+	Path string `json:"path,omitempty"`
+	UID  int    `json:"uid,omitempty"`
+	GID  int    `json:"gid,omitempty"`
+}
+
+type Response struct {
+	Error  string              `json:"error,omitempty"`
+	Usage  []monitor.UserUsage `json:"usage,omitempty"`
+	Owners map[int]string      `json:"owners,omitempty"` // PID -> user, "" for exited processes
+}
+
+func (r Request) validate() error {
+	switch r.Op {
+	case OpUserUsage:
+		if len(r.PIDs) != 0 || r.Path != "" || r.UID != 0 || r.GID != 0 {
+			return errors.New("userUsage takes no arguments")
+		}
+	case OpProcessOwners:
+		if r.Path != "" || r.UID != 0 || r.GID != 0 {
+			return errors.New("processOwners takes only pids")
+		}
+		if len(r.PIDs) == 0 || len(r.PIDs) > maxPIDs {
+			return fmt.Errorf("processOwners needs 1-%d pids", maxPIDs)
+		}
+		for _, pid := range r.PIDs {
+			if pid <= 0 {
+				return fmt.Errorf("invalid pid %d", pid)
+			}
+		}
+	case OpChownDoc:
+		if len(r.PIDs) != 0 {
+			return errors.New("chownDoc takes no pids")
+		}
+		if r.UID <= 0 || r.GID <= 0 {
+			return errors.New("chownDoc needs a non-root uid and gid")
+		}
+		return validDocPath(r.Path)
+	default:
+		return fmt.Errorf("unknown op %q", r.Op)
+	}
+	return nil
+}
+
+// validDocPath accepts the paths the docs API creates: relative, clean and
+// without hidden components (which covers "..").
+func validDocPath(p string) error {
+	if p == "" || len(p) > maxPathBytes || path.IsAbs(p) || path.Clean(p) != p {
+		return errors.New("invalid path")
+	}
+	for _, part := range strings.Split(p, "/") {
+		if strings.HasPrefix(part, ".") {
+			return errors.New("invalid path")
+		}
+	}
+	return nil
+}
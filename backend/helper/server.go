@@ -0,0 +1,227 @@
+package helper
+
+import (
+	"LabMD-backend/monitor"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	requestTimeout = 5 * time.Second
+	writeTimeout   = 10 * time.Second
+	maxConns       = 8
+	// Scans are expensive; a client asking more often gets the last result
+	minScanInterval = time.Minute
+
+	// Not in package syscall
+	oPath       = 0x200000 // O_PATH
+	atEmptyPath = 0x1000   // AT_EMPTY_PATH
+)
+
+type Config struct {
+	AllowedUIDs []uint32 // Peers allowed besides root
+	DocsPath    string   // Root of chownDoc paths; empty refuses chownDoc
+	Logf        func(string, ...any)
+}
+
+// Server answers requests from allowed peers. It runs as root and trusts
+// nothing in a request beyond the fixed operations in protocol.go.
+type Server struct {
+	config Config
+	slots  chan struct{}
+
+	scanMu   sync.Mutex
+	scanned  time.Time
+	lastScan []monitor.UserUsage
+}
+
+func NewServer(config Config) *Server {
+	return &Server{config: config, slots: make(chan struct{}, maxConns)}
+}
+
+func (s *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		select {
+		case s.slots <- struct{}{}:
+			go func() {
+				defer func() { <-s.slots }()
+				s.handle(conn)
+			}()
+		default:
+			conn.Close()
+			s.log("[WARN] Too many concurrent connections, dropping one")
+		}
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	uid, err := peerUID(conn)
+	if err != nil {
+		s.log("[WARN] Rejected connection: %v", err)
+		return
+	}
+	if uid != 0 && !slices.Contains(s.config.AllowedUIDs, uid) {
+		s.log("[WARN] Rejected connection from uid %d", uid)
+		return
+	}
+
+	conn.SetReadDeadline(time.Now().Add(requestTimeout))
+	line, err := bufio.NewReader(io.LimitReader(conn, maxRequestBytes)).ReadBytes('\n')
+	if err != nil {
+		s.log("[WARN] Reading request from uid %d: %v", uid, err)
+		return
+	}
+
+	var req Request
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil || decoder.More() {
+		s.reply(conn, Response{Error: "malformed request"})
+		return
+	}
+	if err := req.validate(); err != nil {
+		s.reply(conn, Response{Error: err.Error()})
+		return
+	}
+
+	switch req.Op {
+	case OpUserUsage:
+		usage, err := s.userUsage()
+		if err != nil {
+			s.reply(conn, Response{Error: err.Error()})
+			return
+		}
+		s.reply(conn, Response{Usage: usage})
+
+	case OpProcessOwners:
+		owners := make(map[int]string, len(req.PIDs))
+		for _, pid := range req.PIDs {
+			owners[pid] = monitor.LocalProcessOwner(pid)
+		}
+		s.reply(conn, Response{Owners: owners})
+
+	case OpChownDoc:
+		if err := s.chownDoc(uid, req); err != nil {
+			s.log("[WARN] chownDoc %s for uid %d: %v", req.Path, uid, err)
+			s.reply(conn, Response{Error: err.Error()})
+			return
+		}
+		s.reply(conn, Response{})
+	}
+}
+
+// chownDoc gives req.Path below the docs directory to req.UID:req.GID. It
+// opens every component without following symlinks and only changes a
+// regular file or folder that peer owns, so the server can give away its
+// own documents but cannot point the helper anywhere else.
+func (s *Server) chownDoc(peer uint32, req Request) error {
+	if s.config.DocsPath == "" {
+		return errors.New("no docs directory configured")
+	}
+	fd, err := syscall.Open(s.config.DocsPath, oPath|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("open docs directory: %w", err)
+	}
+	parts := strings.Split(req.Path, "/")
+	for i, part := range parts {
+		flags := oPath | syscall.O_NOFOLLOW | syscall.O_CLOEXEC
+		if i < len(parts)-1 {
+			flags |= syscall.O_DIRECTORY
+		}
+		next, err := syscall.Openat(fd, part, flags, 0)
+		syscall.Close(fd)
+		if err != nil {
+			return fmt.Errorf("open %s: %w", req.Path, err)
+		}
+		fd = next
+	}
+	defer syscall.Close(fd)
+
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(fd, &stat); err != nil {
+		return err
+	}
+	kind := stat.Mode & syscall.S_IFMT
+	switch {
+	case kind != syscall.S_IFREG && kind != syscall.S_IFDIR:
+		return errors.New("not a regular file or folder")
+	case stat.Uid != peer:
+		return errors.New("not owned by the caller")
+	case kind == syscall.S_IFREG && stat.Nlink != 1:
+		// A hard link could be another name for a file outside the docs
+		return errors.New("file has other links")
+	}
+	return syscall.Fchownat(fd, "", req.UID, req.GID, atEmptyPath)
+}
+
+// userUsage runs one scan at a time and reuses a result younger than
+// minScanInterval, so clients cannot keep du running back to back.
+func (s *Server) userUsage() ([]monitor.UserUsage, error) {
+	s.scanMu.Lock()
+	defer s.scanMu.Unlock()
+
+	if s.lastScan != nil && time.Since(s.scanned) < minScanInterval {
+		return s.lastScan, nil
+	}
+	usage, err := monitor.ScanUserDirs(monitor.UserHomeBase)
+	if err != nil {
+		return nil, fmt.Errorf("scan %s: %w", monitor.UserHomeBase, err)
+	}
+	s.lastScan, s.scanned = usage, time.Now()
+	return usage, nil
+}
+
+func (s *Server) reply(conn net.Conn, resp Response) {
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	json.NewEncoder(conn).Encode(resp)
+}
+
+func (s *Server) log(format string, args ...any) {
+	if s.config.Logf != nil {
+		s.config.Logf("[Helper] "+format, args...)
+	}
+}
+
+// peerUID returns the UID of the process on the other end of a unix socket.
+func peerUID(conn net.Conn) (uint32, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return 0, errors.New("not a unix socket")
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return 0, err
+	}
+	if credErr != nil {
+		return 0, credErr
+	}
+	return cred.Uid, nil
+}
@@ -0,0 +1,207 @@
+package hub
+
+import (
+	"LabMD-backend/monitor"
+	"LabMD-backend/slurm"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxResponseBytes = 8 << 20
+
+type Peer struct {
+	Name  string `json:"name"`
+	URL   string `json:"url"`
+	Token string `json:"token"` // Optional bearer token for protected peers
+}
+
+type Config struct {
+	Peers      []Peer
+	Interval   time.Duration
+	StaleAfter time.Duration
+	Timeout    time.Duration
+}
+
+// Stats mirrors the subset of /api/stats that the hub aggregates.
+type Stats struct {
+	System  monitor.SystemInfo    `json:"system"`
+	CPU     monitor.CPUStats      `json:"cpu"`
+	RAM     monitor.RAMStats      `json:"ram"`
+	GPU     monitor.GPUStats      `json:"gpu"`
+	GPUs    []monitor.GPUStatsSeq `json:"gpus"`
+	Disk    monitor.DiskStats     `json:"disk"`
+	Updated string                `json:"updated"`
+	// Collection time on the peer's clock; zero from older peers
+	UpdatedAt time.Time `json:"updatedAt,omitzero"`
+}
+
+type entry struct {
+	peer      Peer
+	stats     *Stats
+	slurm     *slurm.OverviewResponse
+	reachable bool
+	lastSeen  time.Time
+	collected time.Time // When the stats were collected, on the hub's clock
+	lastError string
+}
+
+type Hub struct {
+	config Config
+	client *http.Client
+	local  func() (Stats, *slurm.OverviewResponse)
+	logf   func(string, ...any)
+
+	mu      sync.RWMutex
+	entries []*entry
+}
+
+// New creates a hub for the configured peers. local, if not nil, supplies
+// the hub's own data so it appears in the overview next to its peers.
+func New(config Config, local func() (Stats, *slurm.OverviewResponse), logf func(string, ...any)) (*Hub, error) {
+	h := &Hub{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+		local:  local,
+		logf:   logf,
+	}
+
+	names := make(map[string]bool)
+	for _, peer := range config.Peers {
+		peer.URL = strings.TrimRight(peer.URL, "/")
+		if peer.URL == "" {
+			return nil, errors.New("hub peer without url")
+		}
+		if peer.Name == "" {
+			peer.Name = peer.URL
+		}
+		if names[peer.Name] {
+			return nil, fmt.Errorf("duplicate hub peer name %q", peer.Name)
+		}
+		names[peer.Name] = true
+		h.entries = append(h.entries, &entry{peer: peer})
+	}
+	return h, nil
+}
+
+func (h *Hub) Start() {
+	go func() {
+		h.refresh()
+
+		ticker := time.NewTicker(h.config.Interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			h.refresh()
+		}
+	}()
+}
+
+func (h *Hub) refresh() {
+	var wg sync.WaitGroup
+	for _, e := range h.entries {
+		wg.Add(1)
+		go func(e *entry) {
+			defer wg.Done()
+			h.poll(e)
+		}(e)
+	}
+	wg.Wait()
+}
+
+func (h *Hub) poll(e *entry) {
+	var stats Stats
+	peerNow, err := h.fetch(e.peer, "/api/stats?passive=1", &stats)
+
+	var overview *slurm.OverviewResponse
+	if err == nil {
+		var o slurm.OverviewResponse
+		_, slurmErr := h.fetch(e.peer, "/api/slurm/overview", &o)
+		if slurmErr == nil {
+			overview = &o
+		} else if !errors.Is(slurmErr, errNotFound) {
+			h.log("[WARN] Slurm overview from %s failed: %v", e.peer.Name, slurmErr)
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err != nil {
+		if e.reachable || e.lastError == "" {
+			h.log("[WARN] Peer %s unreachable: %v", e.peer.Name, err)
+		}
+		e.reachable = false
+		e.lastError = err.Error()
+		return
+	}
+
+	if !e.reachable && e.lastError != "" {
+		h.log("Peer %s reachable again", e.peer.Name)
+	}
+	e.stats = &stats
+	e.slurm = overview
+	e.reachable = true
+	e.lastSeen = time.Now()
+	e.collected = collectedAt(stats.UpdatedAt, peerNow, e.lastSeen)
+	e.lastError = ""
+}
+
+// collectedAt moves the peer's collection time to the hub's clock using the
+// peer's Date header, so clock skew between hosts does not hide or fake
+// stale data. A passive fetch of an idle peer returns stats up to its idle
+// interval old. Peers that do not report a collection time count as
+// collected when fetched.
+func collectedAt(updated, peerNow, fetched time.Time) time.Time {
+	if updated.IsZero() {
+		return fetched
+	}
+	if peerNow.IsZero() {
+		return updated
+	}
+	return fetched.Add(-max(peerNow.Sub(updated), 0))
+}
+
+// stale reports whether a peer's data is older than StaleAfter.
+func (h *Hub) stale(e *entry, now time.Time) bool {
+	return e.collected.IsZero() || now.Sub(e.collected) > h.config.StaleAfter
+}
+
+var errNotFound = errors.New("not found")
+
+// fetch decodes the JSON at path into v and returns the peer's current
+// time from the Date header, or zero without one.
+func (h *Hub) fetch(peer Peer, path string, v any) (time.Time, error) {
+	req, err := http.NewRequest(http.MethodGet, peer.URL+path, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if peer.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+peer.Token)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return time.Time{}, errNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return time.Time{}, fmt.Errorf("%s returned %s", path, resp.Status)
+	}
+	peerNow, _ := http.ParseTime(resp.Header.Get("Date"))
+	return peerNow, json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(v)
+}
+
+func (h *Hub) log(format string, args ...any) {
+	if h.logf != nil {
+		h.logf("[Hub] "+format, args...)
+	}
+}
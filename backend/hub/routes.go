@@ -0,0 +1,165 @@
+package hub
+
+import (
+	"LabMD-backend/slurm"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type GPUSummary struct {
+	Name     string `json:"name"`
+	Count    int    `json:"count"`
+	AvgUtil  int    `json:"avgUtil"`
+	MemUsed  int    `json:"memUsed"`
+	MemTotal int    `json:"memTotal"`
+	MaxTemp  int    `json:"maxTemp"`
+}
+
+type SlurmSummary struct {
+	CPU         slurm.ResourceMetric `json:"cpu"`
+	Memory      slurm.ResourceMetric `json:"memory"`
+	GPU         slurm.ResourceMetric `json:"gpu"`
+	RunningJobs int                  `json:"runningJobs"`
+	PendingJobs int                  `json:"pendingJobs"`
+}
+
+type HostSummary struct {
+	Name      string        `json:"name"`
+	URL       string        `json:"url,omitempty"`
+	Local     bool          `json:"local"`
+	Reachable bool          `json:"reachable"`
+	Stale     bool          `json:"stale"`
+	LastSeen  time.Time     `json:"lastSeen,omitzero"`
+	Error     string        `json:"error,omitempty"`
+	Hostname  string        `json:"hostname,omitempty"`
+	Uptime    string        `json:"uptime,omitempty"`
+	Updated   string        `json:"updated,omitempty"`
+	CPULoad   int           `json:"cpuLoad"`
+	CPUCores  int           `json:"cpuCores"`
+	RAMUsed   float64       `json:"ramUsed"`
+	RAMTotal  float64       `json:"ramTotal"`
+	GPU       GPUSummary    `json:"gpu"`
+	DiskUsed  float64       `json:"diskUsed"`
+	DiskTotal float64       `json:"diskTotal"`
+	Slurm     *SlurmSummary `json:"slurm,omitempty"`
+}
+
+type OverviewResponse struct {
+	Hosts       []HostSummary `json:"hosts"`
+	Unreachable int           `json:"unreachable"`
+	Stale       int           `json:"stale"`
+}
+
+type HostResponse struct {
+	Summary HostSummary             `json:"summary"`
+	Stats   *Stats                  `json:"stats,omitempty"`
+	Slurm   *slurm.OverviewResponse `json:"slurm,omitempty"`
+}
+
+// Overview returns one summary per host, the local host first. Hosts keep
+// their last known data while unreachable and are marked stale once that
+// data was collected more than StaleAfter ago.
+func (h *Hub) Overview() OverviewResponse {
+	resp := OverviewResponse{Hosts: []HostSummary{}}
+	if h.local != nil {
+		stats, overview := h.local()
+		resp.Hosts = append(resp.Hosts, summarize(Peer{Name: stats.System.Hostname}, &stats, overview, true, time.Now(), "", false))
+		resp.Hosts[0].Local = true
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	now := time.Now()
+	for _, e := range h.entries {
+		stale := h.stale(e, now)
+		summary := summarize(e.peer, e.stats, e.slurm, e.reachable, e.lastSeen, e.lastError, stale)
+		if !summary.Reachable {
+			resp.Unreachable++
+		}
+		if summary.Stale {
+			resp.Stale++
+		}
+		resp.Hosts = append(resp.Hosts, summary)
+	}
+	return resp
+}
+
+func (h *Hub) OverviewHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(h.Overview())
+}
+
+func (h *Hub) HostHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	name := r.URL.Query().Get("name")
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, e := range h.entries {
+		if e.peer.Name != name {
+			continue
+		}
+		stale := h.stale(e, time.Now())
+		json.NewEncoder(w).Encode(HostResponse{
+			Summary: summarize(e.peer, e.stats, e.slurm, e.reachable, e.lastSeen, e.lastError, stale),
+			Stats:   e.stats,
+			Slurm:   e.slurm,
+		})
+		return
+	}
+	http.Error(w, "Unknown host", http.StatusNotFound)
+}
+
+func summarize(peer Peer, stats *Stats, overview *slurm.OverviewResponse, reachable bool, lastSeen time.Time, lastError string, stale bool) HostSummary {
+	summary := HostSummary{
+		Name:      peer.Name,
+		URL:       peer.URL,
+		Reachable: reachable,
+		Stale:     stale,
+		LastSeen:  lastSeen,
+		Error:     lastError,
+	}
+	if stats == nil {
+		return summary
+	}
+
+	summary.Hostname = stats.System.Hostname
+	summary.Uptime = stats.System.Uptime
+	summary.Updated = stats.Updated
+	summary.CPULoad = stats.CPU.Load
+	summary.CPUCores = stats.CPU.Cores
+	summary.RAMUsed = stats.RAM.Used
+	summary.RAMTotal = stats.RAM.Total
+	summary.GPU = GPUSummary{
+		Name:     stats.GPU.Name,
+		Count:    len(stats.GPUs),
+		AvgUtil:  stats.GPU.AvgUtil,
+		MemUsed:  stats.GPU.MemUsed,
+		MemTotal: stats.GPU.MemTotal,
+		MaxTemp:  stats.GPU.MaxTemp,
+	}
+	summary.DiskUsed = stats.Disk.Used
+	summary.DiskTotal = stats.Disk.Total
+
+	if overview != nil {
+		s := &SlurmSummary{
+			CPU:    overview.Resources.CPU,
+			Memory: overview.Resources.Memory,
+			GPU:    overview.Resources.GPU,
+		}
+		for _, job := range overview.Jobs {
+			switch job.State {
+			case "RUNNING":
+				s.RunningJobs++
+			case "PENDING":
+				s.PendingJobs++
+			}
+		}
+		summary.Slurm = s
+	}
+	return summary
+}
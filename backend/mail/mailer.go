@@ -0,0 +1,212 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SecurityStartTLS = "starttls"
+	SecurityTLS      = "tls"
+	SecurityNone     = "none"
+
+	rateWindow  = time.Hour
+	dialTimeout = 15 * time.Second
+)
+
+type Config struct {
+	Host           string
+	Port           int
+	Username       string
+	Password       string
+	From           string
+	Security       string
+	AddressPattern string            // e.g. "{user}@example.edu"
+	Addresses      map[string]string // Username -> address, overrides the pattern
+	RatePerHour    int               // Max mails per recipient per hour, 0 = unlimited
+	TemplatesDir   string
+}
+
+// ErrRateLimited is returned when a recipient has exhausted its hourly quota.
+var ErrRateLimited = errors.New("recipient rate limit exceeded")
+
+type Mailer struct {
+	config    Config
+	templates *templates
+
+	mu   sync.Mutex
+	sent map[string][]time.Time
+}
+
+func New(config Config) (*Mailer, error) {
+	if config.Host == "" || config.From == "" {
+		return nil, errors.New("mail host and from address are required")
+	}
+	switch config.Security {
+	case "":
+		config.Security = SecurityStartTLS
+	case SecurityStartTLS, SecurityTLS, SecurityNone:
+	default:
+		return nil, fmt.Errorf("unknown mail security mode %q", config.Security)
+	}
+
+	tmpl, err := loadTemplates(config.TemplatesDir)
+	if err != nil {
+		return nil, err
+	}
+	return &Mailer{config: config, templates: tmpl, sent: make(map[string][]time.Time)}, nil
+}
+
+// AddressFor maps a Linux user name to an email address, or "" when no
+// mapping is configured.
+func (m *Mailer) AddressFor(username string) string {
+	if addr, ok := m.config.Addresses[username]; ok {
+		return addr
+	}
+	if m.config.AddressPattern == "" {
+		return ""
+	}
+	return strings.ReplaceAll(m.config.AddressPattern, "{user}", username)
+}
+
+// SendTemplate renders the named template with data and mails it to to.
+func (m *Mailer) SendTemplate(to, name string, data any) error {
+	subject, body, err := m.templates.render(name, data)
+	if err != nil {
+		return err
+	}
+	return m.Send(to, subject, body)
+}
+
+func (m *Mailer) Send(to, subject, body string) error {
+	if !m.allow(to, time.Now()) {
+		return ErrRateLimited
+	}
+
+	msg, err := m.buildMessage(to, subject, body)
+	if err != nil {
+		return err
+	}
+	return m.deliver(to, msg)
+}
+
+func (m *Mailer) allow(to string, now time.Time) bool {
+	if m.config.RatePerHour <= 0 {
+		return true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	recent := m.sent[to][:0]
+	for _, t := range m.sent[to] {
+		if now.Sub(t) < rateWindow {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= m.config.RatePerHour {
+		m.sent[to] = recent
+		return false
+	}
+	m.sent[to] = append(recent, now)
+	return true
+}
+
+func (m *Mailer) buildMessage(to, subject, body string) ([]byte, error) {
+	id := make([]byte, 12)
+	rand.Read(id)
+	domain := m.config.Host
+	if at := strings.LastIndex(m.config.From, "@"); at >= 0 {
+		domain = m.config.From[at+1:]
+	}
+
+	var buf bytes.Buffer
+	header := func(key, value string) {
+		buf.WriteString(key + ": " + value + "\r\n")
+	}
+	header("From", m.config.From)
+	header("To", to)
+	header("Subject", mime.QEncoding.Encode("utf-8", subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", "<"+hex.EncodeToString(id)+"@"+domain+">")
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=UTF-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	header("Auto-Submitted", "auto-generated")
+	buf.WriteString("\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (m *Mailer) deliver(to string, msg []byte) error {
+	addr := net.JoinHostPort(m.config.Host, strconv.Itoa(m.config.Port))
+	tlsConfig := &tls.Config{ServerName: m.config.Host}
+
+	var conn net.Conn
+	var err error
+	if m.config.Security == SecurityTLS {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, dialTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(time.Minute))
+
+	client, err := smtp.NewClient(conn, m.config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if m.config.Security == SecurityStartTLS {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("starttls: %w", err)
+		}
+	}
+	if m.config.Username != "" {
+		auth := smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+
+	if err := client.Mail(m.config.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,186 @@
+package mail
+
+import (
+	"LabMD-backend/alert"
+	"LabMD-backend/monitor"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type alertNotifier struct {
+	mailer *Mailer
+	to     string
+}
+
+// AlertNotifier returns an alert.Notifier that mails every transition to
+// the given address, typically Config.Admin.Email.
+func AlertNotifier(mailer *Mailer, to string) alert.Notifier {
+	return &alertNotifier{mailer: mailer, to: to}
+}
+
+func (n *alertNotifier) Notify(a alert.Alert) error {
+	return n.mailer.SendTemplate(n.to, TemplateAlert, struct {
+		Alert alert.Alert
+		Text  string
+	}{a, alert.FormatText(a)})
+}
+
+type WatchConfig struct {
+	Host         string
+	Admin        string
+	DiskLimitGB  float64       // 0 disables disk notices
+	GPUIdle      time.Duration // 0 disables idle GPU notices
+	GPUIdleUtil  int           // Utilization below which a GPU counts as idle
+	NoticeRepeat time.Duration // Minimum gap between notices of one kind per user
+	IgnoredUsers []string
+}
+
+// UserWatch mails users who exceed the disk limit or keep GPUs allocated
+// without using them.
+type UserWatch struct {
+	mailer *Mailer
+	config WatchConfig
+	logf   func(string, ...any)
+
+	mu        sync.Mutex
+	idleSince map[string]time.Time
+	lastSent  map[string]time.Time
+}
+
+func NewUserWatch(mailer *Mailer, config WatchConfig, logf func(string, ...any)) *UserWatch {
+	return &UserWatch{
+		mailer:    mailer,
+		config:    config,
+		logf:      logf,
+		idleSince: make(map[string]time.Time),
+		lastSent:  make(map[string]time.Time),
+	}
+}
+
+func (w *UserWatch) CheckDisk(users []monitor.UserUsage, now time.Time) {
+	if w.config.DiskLimitGB <= 0 {
+		return
+	}
+
+	for _, u := range users {
+		if u.Used < w.config.DiskLimitGB || w.ignored(u.Name) {
+			continue
+		}
+		w.notify(TemplateDisk+"|"+u.Name, u.Name, TemplateDisk, map[string]any{
+			"Host":    w.config.Host,
+			"Admin":   w.config.Admin,
+			"User":    u.Name,
+			"UsedGB":  u.Used,
+			"LimitGB": w.config.DiskLimitGB,
+		}, now)
+	}
+}
+
+func (w *UserWatch) CheckGPUs(gpus []monitor.GPUStatsSeq, now time.Time) {
+	if w.config.GPUIdle <= 0 {
+		return
+	}
+
+	type holding struct {
+		pids  []string
+		memMB int
+	}
+
+	w.mu.Lock()
+	seen := make(map[string]bool)
+	var due []func()
+	for _, gpu := range gpus {
+		if gpu.Util >= w.config.GPUIdleUtil {
+			continue
+		}
+
+		byUser := make(map[string]*holding)
+		for _, proc := range gpu.Processes {
+			if proc.User == "" || w.ignored(proc.User) {
+				continue
+			}
+			h, ok := byUser[proc.User]
+			if !ok {
+				h = &holding{}
+				byUser[proc.User] = h
+			}
+			h.pids = append(h.pids, fmt.Sprint(proc.PID))
+			h.memMB += proc.MemUsed
+		}
+
+		for name, h := range byUser {
+			key := fmt.Sprintf("%s|%s|%d", TemplateGPUIdle, name, gpu.ID)
+			seen[key] = true
+			since, ok := w.idleSince[key]
+			if !ok {
+				w.idleSince[key] = now
+				continue
+			}
+			if now.Sub(since) < w.config.GPUIdle {
+				continue
+			}
+
+			sort.Strings(h.pids)
+			data := map[string]any{
+				"Host":        w.config.Host,
+				"Admin":       w.config.Admin,
+				"User":        name,
+				"GPU":         gpu.ID,
+				"PIDs":        strings.Join(h.pids, ", "),
+				"MemUsedMB":   h.memMB,
+				"UtilPercent": w.config.GPUIdleUtil,
+				"IdleMinutes": int(w.config.GPUIdle.Minutes()),
+			}
+			user := name
+			due = append(due, func() { w.notify(key, user, TemplateGPUIdle, data, now) })
+		}
+	}
+	for key := range w.idleSince {
+		if !seen[key] {
+			delete(w.idleSince, key)
+		}
+	}
+	w.mu.Unlock()
+
+	for _, send := range due {
+		send()
+	}
+}
+
+func (w *UserWatch) notify(key, user, template string, data map[string]any, now time.Time) {
+	w.mu.Lock()
+	if last, ok := w.lastSent[key]; ok && now.Sub(last) < w.config.NoticeRepeat {
+		w.mu.Unlock()
+		return
+	}
+	w.lastSent[key] = now
+	w.mu.Unlock()
+
+	to := w.mailer.AddressFor(user)
+	if to == "" {
+		return
+	}
+
+	// Delivery may take seconds; keep it off the collector goroutines
+	go func() {
+		if err := w.mailer.SendTemplate(to, template, data); err != nil {
+			w.log("[WARN] %s notice to %s failed: %v", template, user, err)
+			return
+		}
+		w.log("Sent %s notice to %s", template, user)
+	}()
+}
+
+func (w *UserWatch) ignored(user string) bool {
+	return slices.Contains(w.config.IgnoredUsers, user)
+}
+
+func (w *UserWatch) log(format string, args ...any) {
+	if w.logf != nil {
+		w.logf("[Mail] "+format, args...)
+	}
+}
@@ -0,0 +1,93 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const (
+	TemplateAlert   = "alert"
+	TemplateDisk    = "disk"
+	TemplateGPUIdle = "gpu-idle"
+)
+
+// Templates use a "Subject: ..." first line followed by a blank line and the
+// body. Files named <name>.tmpl in TemplatesDir replace the defaults.
+var defaultTemplates = map[string]string{
+	TemplateAlert: `Subject: [LabMD][{{.Alert.Severity}}] {{.Alert.Rule}} {{.Alert.State}} on {{.Alert.Host}}
+
+{{.Text}}
+`,
+	TemplateDisk: `Subject: [LabMD] Your home directory on {{.Host}} uses {{printf "%.1f" .UsedGB}} GB
+
+Hello {{.User}},
+
+Your home directory on {{.Host}} currently uses {{printf "%.1f" .UsedGB}} GB,
+which is above the lab limit of {{printf "%.0f" .LimitGB}} GB.
+
+Please remove or archive data you no longer need.
+{{if .Admin}}
+Questions: {{.Admin}}{{end}}
+`,
+	TemplateGPUIdle: `Subject: [LabMD] Idle GPU {{.GPU}} held on {{.Host}}
+
+Hello {{.User}},
+
+Your processes ({{.PIDs}}) on {{.Host}} have held GPU {{.GPU}} with
+{{.MemUsedMB}} MB of memory at under {{.UtilPercent}}% utilization for more than
+{{.IdleMinutes}} minutes.
+
+If the job is finished or stuck, please release the GPU for others.
+{{if .Admin}}
+Questions: {{.Admin}}{{end}}
+`,
+}
+
+type templates struct {
+	set map[string]*template.Template
+}
+
+func loadTemplates(dir string) (*templates, error) {
+	t := &templates{set: make(map[string]*template.Template)}
+	for name, text := range defaultTemplates {
+		if dir != "" {
+			custom, err := os.ReadFile(filepath.Join(dir, name+".tmpl"))
+			if err == nil {
+				text = string(custom)
+			} else if !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+
+		parsed, err := template.New(name).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("template %s: %w", name, err)
+		}
+		t.set[name] = parsed
+	}
+	return t, nil
+}
+
+func (t *templates) render(name string, data any) (string, string, error) {
+	tmpl, ok := t.set[name]
+	if !ok {
+		return "", "", fmt.Errorf("unknown mail template %q", name)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", "", err
+	}
+
+	text := buf.String()
+	subject := "[LabMD] Notification"
+	if first, rest, ok := strings.Cut(text, "\n"); ok && strings.HasPrefix(first, "Subject:") {
+		subject = strings.TrimSpace(strings.TrimPrefix(first, "Subject:"))
+		text = strings.TrimLeft(rest, "\r\n")
+	}
+	return subject, text, nil
+}
@@ -1,18 +1,37 @@
 package main
 
 import (
+	"LabMD-backend/alert"
+	"LabMD-backend/audit"
+	"LabMD-backend/auth"
 	"LabMD-backend/docs"
+	"LabMD-backend/exporter"
+	"LabMD-backend/helper"
+	"LabMD-backend/hub"
+	"LabMD-backend/mail"
+	"LabMD-backend/metrics"
 	"LabMD-backend/monitor"
+	"LabMD-backend/reserve"
+	"LabMD-backend/server"
 	"LabMD-backend/slurm"
+	"LabMD-backend/sse"
 	"encoding/json"
+	"expvar"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
-	_ "net/http/pprof"
 	"os"
 	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -30,8 +49,20 @@ var (
 	idleMutex      sync.RWMutex
 	stateChangeCh  = make(chan bool, 1)
 	isDevMode      bool
+	alertEngine    *alert.Engine
+	auditLog       *audit.Log
+	userWatch      *mail.UserWatch
+	statsStream    = sse.NewBroker(statsStreamHistory)
+	// mux holds every public route. http.DefaultServeMux is never served,
+	// because importing expvar or net/http/pprof registers debug handlers
+	// on it.
+	mux       = http.NewServeMux()
+	startTime = time.Now()
 )
 
+// Snapshots kept for Last-Event-ID resume on /api/stats/stream
+const statsStreamHistory = 16
+
 type SystemStats struct {
 	System  monitor.SystemInfo    `json:"system"`
 	CPU     monitor.CPUStats      `json:"cpu"`
@@ -41,6 +72,8 @@ type SystemStats struct {
 	Disk    monitor.DiskStats     `json:"disk"`
 	History HistoryStats          `json:"history"`
 	Updated string                `json:"updated"`
+	// When CPU, RAM and GPU were last collected, for hubs judging staleness
+	UpdatedAt time.Time `json:"updatedAt,omitzero"`
 }
 
 type HistoryStats struct {
@@ -63,6 +96,8 @@ Usage:
   labmd [options]
   labmd server [options]
   labmd upgrade [version]
+  labmd passwd <user>
+  labmd helper
 
 Options:
   --version, -v    Show version information
@@ -74,6 +109,8 @@ Commands:
     --skip-frontend  Skip frontend directory check (for local frontend development)
   upgrade          Download and install the latest release
     version        Optional tag such as v0.2.0
+  passwd           Set a password in the auth users file
+  helper           Run the root helper for privileged disk and process scans
 
 Version: %s
 Build Time: %s
@@ -118,6 +155,31 @@ Build Time: %s
 			}
 			runUpgrade(targetVersion)
 			return
+
+		case "passwd":
+			passwdCmd := flag.NewFlagSet("passwd", flag.ExitOnError)
+			passwdCmd.Usage = func() {
+				fmt.Fprintf(passwdCmd.Output(), "Usage: labmd passwd <user>\n\nCreate a user or change its password in auth.usersFile.\nThe password is read from the terminal, or from stdin when piped.\n")
+			}
+			passwdCmd.Parse(os.Args[2:])
+			if passwdCmd.NArg() != 1 {
+				passwdCmd.Usage()
+				os.Exit(1)
+			}
+			log.SetOutput(io.Discard)
+			LoadConfig(ConfigPath)
+			runPasswd(passwdCmd.Arg(0))
+			return
+
+		case "helper":
+			helperCmd := flag.NewFlagSet("helper", flag.ExitOnError)
+			helperCmd.Usage = func() {
+				fmt.Fprintf(helperCmd.Output(), "Usage: labmd helper\n\nServe privileged disk and process scans to the LabMD server on helper.socket.\nRuns as root, normally started by labmd-helper.socket.\n")
+			}
+			helperCmd.Parse(os.Args[2:])
+			LoadConfig(ConfigPath)
+			runHelper()
+			return
 		}
 	}
 
@@ -149,6 +211,46 @@ Build Time: %s
 	}
 }
 
+// runHelper serves the root helper on the systemd-activated socket, or on
+// helper.socket when started directly.
+func runHelper() {
+	if os.Geteuid() != 0 {
+		log.Printf("[WARN] Helper is not running as root; scans only see what this user can read")
+	}
+
+	var allowed []uint32
+	for _, name := range globalConfig.Helper.AllowedUsers {
+		u, err := user.Lookup(name)
+		if err != nil {
+			log.Printf("[WARN] Helper: skipping allowed user %q: %v", name, err)
+			continue
+		}
+		uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+		allowed = append(allowed, uint32(uid))
+	}
+
+	listener, err := server.ActivationListener()
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to use activation socket: %v", err)
+	}
+	if listener == nil {
+		socketOpts := server.SocketOptions{Mode: 0o660, Group: globalConfig.Helper.SocketGroup}
+		if _, err := user.LookupGroup(socketOpts.Group); err != nil {
+			log.Printf("[WARN] Helper socket group %q not found, socket is root-only: %v", socketOpts.Group, err)
+			socketOpts.Group = ""
+		}
+		listener, err = server.Listen("unix:"+globalConfig.Helper.Socket, socketOpts)
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to listen on %s: %v", globalConfig.Helper.Socket, err)
+		}
+	}
+
+	log.Printf("Root helper (%s) serving %s for uid(s) %v", Version, listener.Addr(), allowed)
+	if err := helper.NewServer(helper.Config{AllowedUIDs: allowed, DocsPath: globalConfig.DocsPath, Logf: log.Printf}).Serve(listener); err != nil {
+		log.Fatalf("[ERROR] Helper failed: %v", err)
+	}
+}
+
 func runUpgrade(targetVersion string) {
 	upgradeScript := "/usr/share/labmd/upgrade.sh"
 	if _, err := os.Stat(upgradeScript); err != nil {
@@ -167,6 +269,65 @@ func runUpgrade(targetVersion string) {
 	}
 }
 
+func runPasswd(username string) {
+	password, err := readPassword("New password: ")
+	if err == nil && isTerminal(os.Stdin) {
+		var confirm string
+		confirm, err = readPassword("Repeat password: ")
+		if err == nil && confirm != password {
+			err = fmt.Errorf("passwords do not match")
+		}
+	}
+	if err == nil && len(password) < 8 {
+		err = fmt.Errorf("password must be at least 8 characters")
+	}
+	if err == nil {
+		err = auth.SetPassword(globalConfig.Auth.UsersFile, username, password)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "passwd failed: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Password for %s updated in %s\n", username, globalConfig.Auth.UsersFile)
+}
+
+// readPassword reads one line from stdin, hiding the input with stty when
+// stdin is a terminal.
+func readPassword(prompt string) (string, error) {
+	if isTerminal(os.Stdin) {
+		fmt.Fprint(os.Stderr, prompt)
+		stty := func(arg string) {
+			cmd := exec.Command("stty", arg)
+			cmd.Stdin = os.Stdin
+			cmd.Run()
+		}
+		stty("-echo")
+		defer func() {
+			stty("echo")
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if n == 0 || err != nil || buf[0] == '\n' {
+			if len(line) == 0 && err != nil {
+				return "", err
+			}
+			break
+		}
+		line = append(line, buf[0])
+	}
+	return strings.TrimSuffix(string(line), "\r"), nil
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
 func showInfo() {
 	log.SetOutput(io.Discard)
 	LoadConfig(ConfigPath)
@@ -252,6 +413,11 @@ func runServer(skipFrontendCheck bool) {
 		DocsPath:   globalConfig.DocsPath,
 		DocsDepth:  globalConfig.DocsDepth,
 		DefaultDoc: globalConfig.DefaultDoc,
+		// Without auth every request is admin, so editing would be open to anyone
+		Editable:       globalConfig.DocsEditable && globalConfig.Auth.Enabled,
+		UnixAccounts:   globalConfig.DocsUnixUsers,
+		MaxUploadBytes: int64(globalConfig.Uploads.MaxSizeMB) << 20,
+		UploadTypes:    globalConfig.Uploads.AllowedTypes,
 	}
 	if globalConfig.Slurm.Enabled {
 		slurm.ConfigureHistory(
@@ -290,10 +456,60 @@ func runServer(skipFrontendCheck bool) {
 	// Initialize as active
 	lastAccessTime = time.Now()
 	isIdle = false
+	// A new subscriber wakes an idle monitor; the last one leaving starts
+	// the idle timeout from the moment of disconnect
+	statsStream.OnSubscribersChange(func(count int) {
+		markActive()
+	})
+
+	// Without root, user disk scans and process owners come from the helper
+	// and documents saved from the web are given back to their owners by it
+	if os.Geteuid() != 0 && globalConfig.Helper.Socket != "" {
+		helperClient := helper.NewClient(globalConfig.Helper.Socket, log.Printf)
+		monitor.SetPrivileged(helperClient)
+		docsConfig.Chown = helperClient.ChownDoc
+		log.Printf("Privileged scans via root helper at %s", globalConfig.Helper.Socket)
+	}
 
 	// Cleanup NVML on exit
 	defer monitor.ShutdownNVML()
 
+	if globalConfig.Alerts.Enabled {
+		alertEngine = alert.NewEngine(alert.Config{
+			Host:        globalStats.System.Hostname,
+			Rules:       globalConfig.Alerts.Rules,
+			RepeatAfter: time.Duration(globalConfig.Alerts.RepeatAfterMin) * time.Minute,
+		}, log.Printf)
+		for _, hook := range globalConfig.Alerts.Webhooks {
+			notifier, err := alert.NewWebhookNotifier(hook)
+			if err != nil {
+				log.Printf("[WARN] Skipping alert webhook: %v", err)
+				continue
+			}
+			alertEngine.AddNotifier(notifier)
+		}
+		log.Printf("Alerting enabled: %d rule(s), %d webhook(s)", alertEngine.RuleCount(), len(globalConfig.Alerts.Webhooks))
+	}
+
+	if globalConfig.Mail.Enabled {
+		setupMail()
+	}
+
+	if globalConfig.Audit.Enabled {
+		var err error
+		auditLog, err = audit.Open(audit.Config{
+			Dir:      filepath.Join(globalConfig.DataDir, "audit"),
+			MaxSize:  int64(globalConfig.Audit.MaxSizeMB) << 20,
+			MaxFiles: globalConfig.Audit.MaxFiles,
+			Logf:     log.Printf,
+		})
+		if err != nil {
+			log.Printf("[WARN] Audit log disabled: %v", err)
+			auditLog = nil
+		}
+		auditLog.Record(audit.Event{Action: "config.load", Target: ConfigPath, Detail: "server " + Version + " started"})
+	}
+
 	// 2. Start High-Frequency Monitoring (CRG: CPU, RAM, GPU) with adaptive interval
 	go func() {
 		currentInterval := time.Duration(globalConfig.Monitor.IntervalCRG) * time.Second
@@ -301,14 +517,15 @@ func runServer(skipFrontendCheck bool) {
 		defer ticker.Stop()
 
 		checkAndUpdate := func() {
-			// Determine current state based on last access time
+			// Determine current state: connected stream subscribers keep the
+			// monitor active; otherwise fall back to the last access time
 			idleMutex.RLock()
 			timeSinceAccess := time.Since(lastAccessTime)
 			idleMutex.RUnlock()
 
 			// Check if IdleTimeout is 0 (never idle)
 			idleTimeout := time.Duration(globalConfig.Monitor.IdleTimeout) * time.Second
-			shouldBeIdle := idleTimeout > 0 && timeSinceAccess > idleTimeout
+			shouldBeIdle := idleTimeout > 0 && statsStream.Subscribers() == 0 && timeSinceAccess > idleTimeout
 
 			// Update idle state and adjust interval if needed
 			idleMutex.Lock()
@@ -332,6 +549,14 @@ func runServer(skipFrontendCheck bool) {
 			}
 
 			updateRealTimeStats()
+			publishStats()
+			evaluateAlerts()
+			if userWatch != nil {
+				dataMutex.RLock()
+				gpus := globalStats.GPUs
+				dataMutex.RUnlock()
+				userWatch.CheckGPUs(gpus, time.Now())
+			}
 		}
 
 		for {
@@ -350,6 +575,8 @@ func runServer(skipFrontendCheck bool) {
 	go func() {
 		// Initial scan
 		updateDiskStats(diskConfig)
+		publishStats()
+		evaluateAlerts()
 
 		// Disk data changes slowly, use fixed interval (no idle adjustment)
 		interval := time.Duration(globalConfig.Monitor.IntervalDisk * float64(time.Hour))
@@ -359,20 +586,127 @@ func runServer(skipFrontendCheck bool) {
 
 		for range ticker.C {
 			updateDiskStats(diskConfig)
+			publishStats()
+			evaluateAlerts()
 		}
 	}()
 
 	if globalConfig.Slurm.Enabled && globalConfig.Slurm.Available {
+		slurm.SetPollHook(evaluateAlerts)
 		slurm.StartPolling(globalConfig.Slurm.IntervalSec, log.Printf)
 	}
 
+	if globalConfig.Exporter.Enabled {
+		exp, err := exporter.New(exporter.Config{
+			Format:      globalConfig.Exporter.Format,
+			URL:         globalConfig.Exporter.URL,
+			Headers:     globalConfig.Exporter.Headers,
+			IntervalSec: globalConfig.Exporter.IntervalSec,
+			BatchSize:   globalConfig.Exporter.BatchSize,
+			TimeoutSec:  globalConfig.Exporter.TimeoutSec,
+			MaxRetries:  globalConfig.Exporter.MaxRetries,
+			SpoolDir:    filepath.Join(globalConfig.DataDir, "spool"),
+			SpoolMaxMB:  globalConfig.Exporter.SpoolMaxMB,
+		}, metricsSnapshot, log.Printf)
+		if err != nil {
+			log.Printf("[WARN] Metrics exporter disabled: %v", err)
+		} else {
+			exp.Start()
+			log.Printf("Metrics exporter pushing %s to %s every %ds",
+				globalConfig.Exporter.Format, globalConfig.Exporter.URL, globalConfig.Exporter.IntervalSec)
+		}
+	}
+
 	// 4. Configure Web Routes
-	http.HandleFunc("/api/stats", handleStats)
-	http.HandleFunc("/api/config", handleConfig)
-	http.HandleFunc("/api/docs/tree", docs.TreeHandler(docsConfig))
-	http.HandleFunc("/api/docs/content", docs.ContentHandler(docsConfig))
+	// Every route states the lowest role allowed to call it and the scope an
+	// API token needs; handlers for anonymous routes filter their responses
+	// by role themselves
+	//
+	// routed runs after auth so the access log can name the user
+	routed := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := auth.IdentityFrom(r.Context()); id != nil {
+			server.SetLogUser(r, id.Username)
+		}
+		mux.ServeHTTP(w, r)
+	})
+	var handler http.Handler = auth.Open(routed)
+	if globalConfig.Auth.Enabled {
+		authService, sso := setupAuth()
+		route("/api/auth/login", auth.RoleAnonymous, "", authService.LoginHandler)
+		route("/api/auth/logout", auth.RoleAnonymous, "", authService.LogoutHandler)
+		route("/api/auth/me", auth.RoleAnonymous, "", authService.MeHandler)
+		if authService.Tokens() != nil {
+			route("/api/tokens", auth.RoleViewer, auth.ScopeAdmin, authService.TokensHandler)
+		}
+		if auditLog != nil {
+			route("/api/audit", auth.RoleAdmin, auth.ScopeAdmin, auditLog.Handler())
+		}
+		if sso != nil {
+			route("/api/auth/oidc/login", auth.RoleAnonymous, "", sso.LoginHandler)
+			route("/api/auth/oidc/callback", auth.RoleAnonymous, "", sso.CallbackHandler)
+		}
+		handler = authService.Middleware(routed)
+	}
+	statsStream.SetFilter(statsStreamFilter)
+	route("/api/stats", auth.RoleAnonymous, auth.ScopeReadStats, handleStats)
+	route("/api/stats/stream", auth.RoleAnonymous, auth.ScopeReadStats, statsStream.ServeHTTP)
+	route("/api/config", auth.RoleAnonymous, auth.ScopeReadStats, handleConfig)
+	docsConfig.Audit = auditLog
+	if globalConfig.Search.Enabled {
+		docsConfig.Search = docs.NewIndex(docsConfig)
+		go docsConfig.Search.Run(time.Duration(globalConfig.Search.RefreshSec) * time.Second)
+		route("/api/docs/search", auth.RoleMember, auth.ScopeReadDocs, docsConfig.Search.Handler())
+	}
+	// Changes seen by the tree's watches also refresh the search index
+	docsConfig.Tree = docs.NewTree(docsConfig, docsConfig.Search.Notify)
+	go docsConfig.Tree.Run(time.Duration(globalConfig.DocsResyncSec) * time.Second)
+	route("/api/docs/tree/stream", auth.RoleMember, auth.ScopeReadDocs, docsConfig.Tree.Stream().ServeHTTP)
+	route("/api/docs/tree", auth.RoleMember, auth.ScopeReadDocs, docs.TreeHandler(docsConfig))
+	route("/api/docs/content", auth.RoleMember, auth.ScopeReadDocs, docs.ContentHandler(docsConfig))
+	route("/api/docs/render", auth.RoleMember, auth.ScopeReadDocs, docs.RenderHandler(docsConfig))
+	route("/api/docs/tags", auth.RoleMember, auth.ScopeReadDocs, docs.TagsHandler(docsConfig))
+	route("/api/docs/folder", auth.RoleMember, auth.ScopeWriteDocs, docs.FolderHandler(docsConfig))
+	route("/api/docs/move", auth.RoleMember, auth.ScopeWriteDocs, docs.MoveHandler(docsConfig))
+	route("/api/docs/upload", auth.RoleMember, auth.ScopeWriteDocs, docs.UploadHandler(docsConfig))
+	if alertEngine != nil {
+		route("/api/alerts", auth.RoleAdmin, auth.ScopeReadStats, alertEngine.Handler())
+	}
+	if globalConfig.Reservations.Enabled {
+		store, err := reserve.Open(reserve.Config{
+			Path:        filepath.Join(globalConfig.DataDir, "reservations.json"),
+			MaxDuration: time.Duration(globalConfig.Reservations.MaxHours) * time.Hour,
+			MaxAhead:    time.Duration(globalConfig.Reservations.MaxAheadDays) * 24 * time.Hour,
+			ExemptUsers: globalConfig.Reservations.ExemptUsers,
+		})
+		if err != nil {
+			log.Printf("[WARN] GPU reservations disabled: %v", err)
+		} else {
+			route("/api/reservations", auth.RoleMember, auth.ScopeReservations, reserve.Handler(store, currentGPUs, auditLog))
+			log.Printf("GPU reservations enabled")
+		}
+	}
+	if globalConfig.Hub.Enabled {
+		fleet, err := hub.New(hub.Config{
+			Peers:      globalConfig.Hub.Peers,
+			Interval:   time.Duration(globalConfig.Hub.IntervalSec) * time.Second,
+			StaleAfter: time.Duration(globalConfig.Hub.StaleAfterSec) * time.Second,
+			Timeout:    time.Duration(globalConfig.Hub.TimeoutSec) * time.Second,
+		}, hubLocalSnapshot, log.Printf)
+		if err != nil {
+			log.Printf("[WARN] Hub mode disabled: %v", err)
+		} else {
+			fleet.Start()
+			route("/api/hub/overview", auth.RoleViewer, auth.ScopeReadStats, fleet.OverviewHandler)
+			route("/api/hub/host", auth.RoleAdmin, auth.ScopeReadStats, fleet.HostHandler)
+			log.Printf("Hub mode enabled: %d peer(s) every %ds", len(globalConfig.Hub.Peers), globalConfig.Hub.IntervalSec)
+		}
+	}
+	if globalConfig.Metrics.Enabled {
+		route("/metrics", auth.RoleAdmin, auth.ScopeReadStats, metrics.Handler(metricsSnapshot))
+		log.Printf("Prometheus metrics enabled at /metrics")
+	}
 	if globalConfig.Slurm.Available {
-		http.HandleFunc("/api/slurm/overview", slurm.OverviewHandler)
+		route("/api/slurm/overview", auth.RoleAnonymous, auth.ScopeReadStats, slurm.OverviewHandler)
 		log.Printf("Slurm integration enabled")
 	} else if globalConfig.Slurm.Enabled {
 		log.Printf("[WARN] Slurm enabled in config but commands are not available")
@@ -387,7 +721,7 @@ func runServer(skipFrontendCheck bool) {
 		}
 
 		fs := http.FileServer(http.Dir(DistPath))
-		http.Handle("/", fs)
+		route("/", auth.RoleAnonymous, "", fs.ServeHTTP)
 		log.Printf("Frontend loaded: %s", DistPath)
 	}
 
@@ -399,21 +733,212 @@ func runServer(skipFrontendCheck bool) {
 			log.Printf("[WARN] Docs directory not found: %s", docsPath)
 		} else {
 			// Mount raw docs directory (for assets/images)
-			http.Handle("/raw/", http.StripPrefix("/raw/", http.FileServer(http.Dir(docsPath))))
+			route("/raw/", auth.RoleMember, auth.ScopeReadDocs, docs.RawHandler(docsConfig))
 			log.Printf("Raw Assets Server started: %s -> /raw/", docsPath)
 		}
 	} else {
 		log.Printf("[WARN] DocsPath not configured")
 	}
 
+	if globalConfig.Debug.Enabled {
+		setupDebug()
+	}
+
 	// 7. Start Server
-	serverAddr := fmt.Sprintf(":%d", globalConfig.Port)
-	log.Printf("Server running at: http://localhost:%d", globalConfig.Port)
-	if err := http.ListenAndServe(serverAddr, nil); err != nil {
-		log.Fatalf("[ERROR] Server startup failed: %v", err)
+	addrs := globalConfig.Listen
+	if len(addrs) == 0 {
+		addrs = []string{fmt.Sprintf(":%d", globalConfig.Port)}
+	}
+	socketMode, _ := strconv.ParseUint(globalConfig.SocketMode, 8, 32)
+	socketOpts := server.SocketOptions{Mode: os.FileMode(socketMode), Group: globalConfig.SocketGroup}
+
+	handler = withMiddleware(handler)
+	srv := &http.Server{Handler: handler}
+	if globalConfig.TLS.Enabled {
+		srv.Handler = server.HSTS(time.Duration(globalConfig.TLS.HSTSMaxAgeSec)*time.Second, globalConfig.TLS.HSTSIncludeSubdomains, handler)
+		srv.TLSConfig = setupTLS().TLSConfig()
+	}
+
+	errs := make(chan error, len(addrs)+1)
+	for _, addr := range addrs {
+		listener, err := server.Listen(addr, socketOpts)
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to listen on %s: %v", addr, err)
+		}
+		// Unix sockets sit behind a local reverse proxy and stay plain HTTP
+		if server.IsUnix(addr) || !globalConfig.TLS.Enabled {
+			log.Printf("Server running at: %s", displayAddr("http", addr))
+			go func() { errs <- srv.Serve(listener) }()
+		} else {
+			log.Printf("Server running at: %s", displayAddr("https", addr))
+			go func() { errs <- srv.ServeTLS(listener, "", "") }()
+		}
+	}
+
+	if port := globalConfig.TLS.RedirectPort; globalConfig.TLS.Enabled && port != 0 {
+		httpsPort, ok := server.TCPPort(addrs)
+		if !ok {
+			log.Fatalf("[ERROR] tls.redirectPort needs a TCP listen address to redirect to")
+		}
+		for _, addr := range redirectAddrs(addrs, port) {
+			listener, err := server.Listen(addr, socketOpts)
+			if err != nil {
+				log.Fatalf("[ERROR] Failed to listen on %s: %v", addr, err)
+			}
+			log.Printf("Redirecting %s to HTTPS", displayAddr("http", addr))
+			go func() { errs <- http.Serve(listener, server.RedirectHandler(httpsPort)) }()
+		}
+	}
+
+	if err := <-errs; err != nil {
+		log.Fatalf("[ERROR] Server failed: %v", err)
+	}
+}
+
+// setupDebug serves pprof and expvar on the debug listener and, when
+// configured, to admins on the main port under /debug/.
+func setupDebug() {
+	expvar.Publish("labmd", expvar.Func(debugVars))
+	debugMux := server.DebugMux()
+
+	if addr := globalConfig.Debug.Listen; addr != "" {
+		if !server.IsLocal(addr) {
+			log.Printf("[WARN] Debug listener %s is reachable from the network without authentication", addr)
+		}
+		listener, err := server.Listen(addr, server.SocketOptions{Mode: 0o600})
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to listen on %s: %v", addr, err)
+		}
+		log.Printf("Debug server (pprof, expvar) running at: %s", displayAddr("http", addr))
+		go func() {
+			if err := http.Serve(listener, debugMux); err != nil {
+				log.Printf("[ERROR] Debug server failed: %v", err)
+			}
+		}()
+	}
+
+	if globalConfig.Debug.AdminRoutes {
+		if !globalConfig.Auth.Enabled {
+			// Without auth every request is admin, so this would make profiles public
+			log.Printf("[WARN] debug.adminRoutes requires auth to be enabled, not serving /debug/ on the main port")
+			return
+		}
+		route("/debug/", auth.RoleAdmin, auth.ScopeAdmin, debugMux.ServeHTTP)
 	}
 }
 
+// debugVars reports the server's own state at /debug/vars, next to the
+// memstats and cmdline that expvar publishes itself.
+func debugVars() any {
+	idleMutex.RLock()
+	idle, lastAccess := isIdle, lastAccessTime
+	idleMutex.RUnlock()
+
+	dataMutex.RLock()
+	updated := globalStats.Updated
+	dataMutex.RUnlock()
+
+	return map[string]any{
+		"version":           Version,
+		"uptimeSec":         int64(time.Since(startTime).Seconds()),
+		"goroutines":        runtime.NumGoroutine(),
+		"streamSubscribers": statsStream.Subscribers(),
+		"idle":              idle,
+		"lastAccess":        lastAccess,
+		"statsUpdated":      updated,
+	}
+}
+
+// redirectAddrs binds the HTTP redirect port on the same hosts as the TCP
+// listen addresses, so restricting listen also restricts the redirect.
+func redirectAddrs(addrs []string, port int) []string {
+	var result []string
+	for _, addr := range addrs {
+		if server.IsUnix(addr) {
+			continue
+		}
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			continue
+		}
+		redirect := net.JoinHostPort(host, strconv.Itoa(port))
+		if !slices.Contains(result, redirect) {
+			result = append(result, redirect)
+		}
+	}
+	return result
+}
+
+func displayAddr(scheme, addr string) string {
+	if server.IsUnix(addr) {
+		return addr
+	}
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+	return scheme + "://" + addr
+}
+
+// withMiddleware wraps the server in the request chain, from the outside
+// in: client address behind trusted proxies, access log, security headers,
+// CORS and per-IP rate limiting.
+func withMiddleware(next http.Handler) http.Handler {
+	httpConfig := globalConfig.HTTP
+	if httpConfig.RateLimitPerSec > 0 {
+		next = server.NewRateLimiter(httpConfig.RateLimitPerSec, httpConfig.RateLimitBurst).Middleware(next)
+	}
+
+	origins := httpConfig.CORSOrigins
+	if isDevMode {
+		origins = []string{"*"}
+	}
+	next = server.CORS(origins, next)
+	next = server.SecurityHeaders(httpConfig.ContentSecurityPolicy, httpConfig.FrameOptions, next)
+
+	if httpConfig.AccessLog.Enabled {
+		out := io.Writer(os.Stderr)
+		if path := httpConfig.AccessLog.Path; path != "" {
+			file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+			if err != nil {
+				log.Fatalf("[ERROR] Failed to open access log: %v", err)
+			}
+			out = file
+		}
+		var logHandler slog.Handler = slog.NewTextHandler(out, nil)
+		if httpConfig.AccessLog.Format == "json" {
+			logHandler = slog.NewJSONHandler(out, nil)
+		}
+		next = server.AccessLog(slog.New(logHandler), next)
+	}
+
+	proxies, err := server.ParseTrustedProxies(httpConfig.TrustedProxies)
+	if err != nil {
+		log.Fatalf("[ERROR] http.trustedProxies: %v", err)
+	}
+	return proxies.Middleware(next)
+}
+
+// setupTLS loads the configured certificate, generating a self-signed one
+// first when requested and the files do not exist yet.
+func setupTLS() *server.CertReloader {
+	certFile, keyFile := globalConfig.TLS.CertFile, globalConfig.TLS.KeyFile
+	if globalConfig.TLS.SelfSigned {
+		created, err := server.GenerateSelfSigned(certFile, keyFile, globalConfig.TLS.SelfSignedHosts, 365*24*time.Hour)
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to generate self-signed certificate: %v", err)
+		}
+		if created {
+			log.Printf("[WARN] Generated self-signed certificate %s; browsers will warn until it is replaced", certFile)
+		}
+	}
+
+	certs, err := server.NewCertReloader(certFile, keyFile, log.Printf)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to load TLS certificate: %v", err)
+	}
+	return certs
+}
+
 func updateRealTimeStats() {
 	cpu := monitor.GetCPURealTime()
 	ram := monitor.GetRAMRealTime()
@@ -426,7 +951,8 @@ func updateRealTimeStats() {
 	globalStats.RAM = ram
 	globalStats.GPU = gpu
 	globalStats.GPUs = gpus
-	globalStats.Updated = time.Now().Format("15:04:05")
+	globalStats.UpdatedAt = time.Now()
+	globalStats.Updated = globalStats.UpdatedAt.Format("15:04:05")
 	globalStats.System.Uptime = monitor.GetUptime()
 	globalStats.System.LoadAvg = monitor.GetLoadAvg()
 
@@ -444,15 +970,95 @@ func updateDiskStats(diskConfig monitor.DiskConfig) {
 	disk := monitor.GetDiskUsage(diskConfig, false)
 
 	dataMutex.Lock()
-	defer dataMutex.Unlock()
 	globalStats.Disk = disk
+	dataMutex.Unlock()
+
+	if userWatch != nil {
+		userWatch.CheckDisk(disk.Users, time.Now())
+	}
 }
 
 func handleStats(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Content-Type", "application/json")
 
-	// Update last access time
+	// Polling clients without a stream still count as activity; hubs poll
+	// with passive=1 so they do not keep this host out of idle mode
+	if r.URL.Query().Get("passive") != "1" {
+		markActive()
+	}
+
+	dataMutex.RLock()
+	defer dataMutex.RUnlock()
+
+	json.NewEncoder(w).Encode(visibleStats(globalStats, r))
+}
+
+// route registers h behind a role and token scope check. All routes in
+// runServer go through it so none is reachable below its intended role.
+func route(pattern string, min auth.Role, scope auth.Scope, h http.HandlerFunc) {
+	mux.Handle(pattern, auth.Require(min, scope, h))
+}
+
+// visibleStats trims a snapshot to what the requester may see: per-user
+// disk usage and GPU process owners are admin-only, members keep their
+// own entries and everyone else gets aggregates.
+func visibleStats(stats SystemStats, r *http.Request) SystemStats {
+	role := auth.RoleFrom(r.Context())
+	if role >= auth.RoleAdmin {
+		return stats
+	}
+
+	self := ""
+	if id := auth.IdentityFrom(r.Context()); id != nil && role >= auth.RoleMember {
+		self = id.Username
+	}
+
+	users := []monitor.UserUsage{}
+	for _, user := range stats.Disk.Users {
+		if self != "" && user.Name == self {
+			users = append(users, user)
+		}
+	}
+	stats.Disk.Users = users
+
+	gpus := make([]monitor.GPUStatsSeq, len(stats.GPUs))
+	for i, gpu := range stats.GPUs {
+		var processes []monitor.GPUProcess
+		for _, proc := range gpu.Processes {
+			if self != "" && proc.User == self {
+				processes = append(processes, proc)
+			}
+		}
+		gpu.Processes = processes
+		gpus[i] = gpu
+	}
+	stats.GPUs = gpus
+	return stats
+}
+
+// statsStreamFilter applies visibleStats to every event of a stream
+// subscriber below admin.
+func statsStreamFilter(r *http.Request) func([]byte) []byte {
+	if auth.RoleFrom(r.Context()) >= auth.RoleAdmin {
+		return nil
+	}
+
+	return func(data []byte) []byte {
+		var stats SystemStats
+		if err := json.Unmarshal(data, &stats); err != nil {
+			return []byte("{}")
+		}
+		filtered, err := json.Marshal(visibleStats(stats, r))
+		if err != nil {
+			return []byte("{}")
+		}
+		return filtered
+	}
+}
+
+// markActive records user activity and wakes the CRG goroutine if the
+// monitor is currently idle.
+func markActive() {
 	idleMutex.Lock()
 	wasIdle := isIdle
 	lastAccessTime = time.Now()
@@ -465,16 +1071,267 @@ func handleStats(w http.ResponseWriter, r *http.Request) {
 		default: // Skip if channel full (update already pending)
 		}
 	}
+}
 
+// publishStats pushes the current snapshot to /api/stats/stream subscribers.
+func publishStats() {
 	dataMutex.RLock()
-	defer dataMutex.RUnlock()
-
-	json.NewEncoder(w).Encode(globalStats)
+	data, err := json.Marshal(globalStats)
+	dataMutex.RUnlock()
+	if err != nil {
+		log.Printf("[WARN] Failed to encode stats snapshot: %v", err)
+		return
+	}
+	statsStream.Publish("stats", data)
 }
 
 func handleConfig(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Content-Type", "application/json")
 
-	json.NewEncoder(w).Encode(globalConfig)
+	json.NewEncoder(w).Encode(visibleConfig(auth.RoleFrom(r.Context())))
+}
+
+// visibleConfig returns the full configuration without credentials to
+// admins and only the fields the frontend needs to everyone else.
+func visibleConfig(role auth.Role) Config {
+	config := publicConfig()
+	if role >= auth.RoleAdmin {
+		return config
+	}
+
+	var limited Config
+	limited.ProjectName = config.ProjectName
+	limited.LabName = config.LabName
+	limited.DefaultDoc = config.DefaultDoc
+	limited.Version = config.Version
+	limited.Admin = config.Admin
+	limited.Monitor = config.Monitor
+	limited.Slurm = config.Slurm
+	limited.Reservations.Enabled = config.Reservations.Enabled
+	limited.Auth.Enabled = config.Auth.Enabled
+	limited.Auth.OIDC.Enabled = config.Auth.OIDC.Enabled
+	limited.Auth.OIDC.ProviderName = config.Auth.OIDC.ProviderName
+	return limited
+}
+
+// publicConfig returns a copy of the configuration without credentials,
+// since /api/config stays readable before login.
+func publicConfig() Config {
+	config := globalConfig
+	config.Mail.Password = ""
+	config.Exporter.Headers = nil
+	config.Alerts.Webhooks = nil
+	config.Hub.Peers = nil
+	config.Auth.LDAP.BindPassword = ""
+	config.Auth.OIDC.ClientSecret = ""
+	return config
+}
+
+// setupAuth builds the login service and, when configured, the OIDC flow.
+// Local users are tried first, then LDAP. A broken authenticator is fatal
+// so a misconfigured server never falls back to serving protected routes
+// openly.
+func setupAuth() (*auth.Service, *auth.OIDC) {
+	var authenticators []auth.Authenticator
+
+	users, err := auth.NewLocalUsers(globalConfig.Auth.UsersFile)
+	switch {
+	case err == nil:
+		authenticators = append(authenticators, users)
+	case os.IsNotExist(err) && (globalConfig.Auth.LDAP.Enabled || globalConfig.Auth.OIDC.Enabled):
+		log.Printf("No local users file at %s, skipping local logins", globalConfig.Auth.UsersFile)
+	default:
+		log.Fatalf("[ERROR] Auth users file: %v (create it with: labmd passwd <user>)", err)
+	}
+
+	if ldapConfig := globalConfig.Auth.LDAP; ldapConfig.Enabled {
+		directory, err := auth.NewLDAPDirectory(auth.LDAPConfig{
+			URL:                ldapConfig.URL,
+			StartTLS:           ldapConfig.StartTLS,
+			CACert:             ldapConfig.CACert,
+			InsecureSkipVerify: ldapConfig.InsecureSkipVerify,
+			Timeout:            time.Duration(ldapConfig.TimeoutSec) * time.Second,
+			BindDN:             ldapConfig.BindDN,
+			BindPassword:       ldapConfig.BindPassword,
+			BaseDN:             ldapConfig.BaseDN,
+			UserFilter:         ldapConfig.UserFilter,
+			UserAttr:           ldapConfig.UserAttr,
+			GroupBaseDN:        ldapConfig.GroupBaseDN,
+			GroupFilter:        ldapConfig.GroupFilter,
+			GroupAttr:          ldapConfig.GroupAttr,
+			GroupRoles:         ldapConfig.GroupRoles,
+			DefaultRoles:       ldapConfig.DefaultRoles,
+		})
+		if err != nil {
+			log.Fatalf("[ERROR] LDAP authentication: %v", err)
+		}
+		authenticators = append(authenticators, directory)
+		log.Printf("LDAP authentication against %s", ldapConfig.URL)
+	}
+
+	defaultRole, err := auth.ParseRole(globalConfig.Auth.DefaultRole)
+	if err != nil {
+		log.Fatalf("[ERROR] auth.defaultRole: %v", err)
+	}
+	userRoles := make(map[string]auth.Role)
+	for user, name := range globalConfig.Auth.UserRoles {
+		role, err := auth.ParseRole(name)
+		if err != nil {
+			log.Fatalf("[ERROR] auth.userRoles[%s]: %v", user, err)
+		}
+		userRoles[user] = role
+	}
+
+	tokens, err := auth.OpenTokens(
+		filepath.Join(globalConfig.DataDir, "tokens.json"),
+		time.Duration(globalConfig.Auth.TokenMaxDays)*24*time.Hour,
+	)
+	if err != nil {
+		log.Printf("[WARN] API tokens disabled: %v", err)
+		tokens = nil
+	}
+
+	sessions := auth.NewSessions(time.Duration(globalConfig.Auth.SessionTTLHours)*time.Hour, globalConfig.Auth.CookieSecure)
+	service := auth.NewService(auth.Config{
+		Authenticators: authenticators,
+		Sessions:       sessions,
+		Protect:        globalConfig.Auth.Protect,
+		UserRoles:      userRoles,
+		DefaultRole:    defaultRole,
+		Tokens:         tokens,
+		Audit:          auditLog,
+		Logf:           log.Printf,
+	})
+
+	var sso *auth.OIDC
+	if oidcConfig := globalConfig.Auth.OIDC; oidcConfig.Enabled {
+		sso, err = auth.NewOIDC(auth.OIDCConfig{
+			Issuer:        oidcConfig.Issuer,
+			ClientID:      oidcConfig.ClientID,
+			ClientSecret:  oidcConfig.ClientSecret,
+			RedirectURL:   oidcConfig.RedirectURL,
+			Scopes:        oidcConfig.Scopes,
+			UsernameClaim: oidcConfig.UsernameClaim,
+			StripDomain:   oidcConfig.StripDomain,
+			GroupsClaim:   oidcConfig.GroupsClaim,
+			GroupRoles:    oidcConfig.GroupRoles,
+			DefaultRoles:  oidcConfig.DefaultRoles,
+			Audit:         auditLog,
+		}, sessions, log.Printf)
+		if err != nil {
+			log.Fatalf("[ERROR] OIDC login: %v", err)
+		}
+		log.Printf("OIDC login via %s", oidcConfig.Issuer)
+	}
+
+	log.Printf("Authentication enabled, protecting %v", globalConfig.Auth.Protect)
+	return service, sso
+}
+
+func setupMail() {
+	mailer, err := mail.New(mail.Config{
+		Host:           globalConfig.Mail.Host,
+		Port:           globalConfig.Mail.Port,
+		Username:       globalConfig.Mail.Username,
+		Password:       globalConfig.Mail.Password,
+		From:           globalConfig.Mail.From,
+		Security:       globalConfig.Mail.Security,
+		AddressPattern: globalConfig.Mail.AddressPattern,
+		Addresses:      globalConfig.Mail.Addresses,
+		RatePerHour:    globalConfig.Mail.RatePerHour,
+		TemplatesDir:   globalConfig.Mail.TemplatesDir,
+	})
+	if err != nil {
+		log.Printf("[WARN] Mail notifications disabled: %v", err)
+		return
+	}
+
+	if globalConfig.Mail.AlertsToAdmin && alertEngine != nil {
+		if globalConfig.Admin.Email != "" {
+			alertEngine.AddNotifier(mail.AlertNotifier(mailer, globalConfig.Admin.Email))
+		} else {
+			log.Printf("[WARN] mail.alertsToAdmin set but admin.email is empty")
+		}
+	}
+
+	if globalConfig.Mail.UserDiskLimitGB > 0 || globalConfig.Mail.GPUIdleMinutes > 0 {
+		admin := globalConfig.Admin.Email
+		if globalConfig.Admin.Name != "" && admin != "" {
+			admin = fmt.Sprintf("%s <%s>", globalConfig.Admin.Name, admin)
+		}
+		userWatch = mail.NewUserWatch(mailer, mail.WatchConfig{
+			Host:         globalStats.System.Hostname,
+			Admin:        admin,
+			DiskLimitGB:  globalConfig.Mail.UserDiskLimitGB,
+			GPUIdle:      time.Duration(globalConfig.Mail.GPUIdleMinutes) * time.Minute,
+			GPUIdleUtil:  globalConfig.Mail.GPUIdleUtil,
+			NoticeRepeat: time.Duration(globalConfig.Mail.NoticeRepeatHours) * time.Hour,
+			IgnoredUsers: globalConfig.Disk.IgnoredUsers,
+		}, log.Printf)
+	}
+	log.Printf("Mail notifications enabled via %s:%d", globalConfig.Mail.Host, globalConfig.Mail.Port)
+}
+
+func currentGPUs() []monitor.GPUStatsSeq {
+	dataMutex.RLock()
+	defer dataMutex.RUnlock()
+	return globalStats.GPUs
+}
+
+// hubLocalSnapshot supplies this host's own entry in the hub overview.
+func hubLocalSnapshot() (hub.Stats, *slurm.OverviewResponse) {
+	dataMutex.RLock()
+	stats := hub.Stats{
+		System:    globalStats.System,
+		CPU:       globalStats.CPU,
+		RAM:       globalStats.RAM,
+		GPU:       globalStats.GPU,
+		GPUs:      globalStats.GPUs,
+		Disk:      globalStats.Disk,
+		Updated:   globalStats.Updated,
+		UpdatedAt: globalStats.UpdatedAt,
+	}
+	dataMutex.RUnlock()
+
+	if !globalConfig.Slurm.Available {
+		return stats, nil
+	}
+	summary, ok := slurm.CachedResourceSummary()
+	if !ok {
+		return stats, nil
+	}
+	jobs, _ := slurm.CachedJobs()
+	return stats, &slurm.OverviewResponse{Resources: summary, Jobs: jobs}
+}
+
+// evaluateAlerts runs the alert rules against the latest cached stats. It is
+// called after every CRG, disk and Slurm update.
+func evaluateAlerts() {
+	if alertEngine == nil {
+		return
+	}
+	alertEngine.Evaluate(metrics.Collect(metricsSnapshot()), time.Now())
+}
+
+// metricsSnapshot copies the cached stats for exporters. It never triggers a
+// collection and does not count as user access for idle mode.
+func metricsSnapshot() metrics.Snapshot {
+	dataMutex.RLock()
+	snapshot := metrics.Snapshot{
+		Version: Version,
+		System:  globalStats.System,
+		CPU:     globalStats.CPU,
+		RAM:     globalStats.RAM,
+		GPUs:    append([]monitor.GPUStatsSeq(nil), globalStats.GPUs...),
+		Disk:    globalStats.Disk,
+	}
+	dataMutex.RUnlock()
+
+	if globalConfig.Slurm.Available {
+		if summary, ok := slurm.CachedResourceSummary(); ok {
+			snapshot.Slurm = &summary
+		}
+		snapshot.Jobs, _ = slurm.CachedJobs()
+	}
+	return snapshot
 }
@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"LabMD-backend/monitor"
+	"LabMD-backend/slurm"
+	"sort"
+	"strconv"
+)
+
+const (
+	gbToBytes = 1024 * 1024 * 1024
+	mbToBytes = 1024 * 1024
+)
+
+// Snapshot is a copy of the cached collector state. It is assembled by the
+// caller so that exporting never triggers a collection.
+type Snapshot struct {
+	Version string
+	System  monitor.SystemInfo
+	CPU     monitor.CPUStats
+	RAM     monitor.RAMStats
+	GPUs    []monitor.GPUStatsSeq
+	Disk    monitor.DiskStats
+	Slurm   *slurm.ResourceSummary
+	Jobs    []slurm.Job
+}
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+type Metric struct {
+	Labels []Label
+	Value  float64
+}
+
+type Family struct {
+	Name    string
+	Help    string
+	Type    string
+	Metrics []Metric
+}
+
+// Collect flattens a snapshot into metric families. Families without any
+// samples are omitted.
+func Collect(s Snapshot) []Family {
+	var families []Family
+	add := func(name, help string, metrics ...Metric) {
+		if len(metrics) == 0 {
+			return
+		}
+		families = append(families, Family{Name: name, Help: help, Type: "gauge", Metrics: metrics})
+	}
+	value := func(v float64, labels ...Label) Metric {
+		return Metric{Labels: labels, Value: v}
+	}
+
+	add("labmd_build_info", "LabMD build information.",
+		value(1, Label{"version", s.Version}))
+	add("labmd_host_info", "Static host information.",
+		value(1, Label{"hostname", s.System.Hostname}, Label{"os", s.System.OS}, Label{"kernel", s.System.Kernel}))
+	add("labmd_load_average_1m", "One-minute load average.", value(s.System.LoadAvg))
+
+	add("labmd_cpu_usage_percent", "CPU utilization in percent.", value(float64(s.CPU.Load)))
+	add("labmd_cpu_cores", "Number of physical CPU cores.", value(float64(s.CPU.Cores)))
+	add("labmd_cpu_threads", "Number of logical CPU threads.", value(float64(s.CPU.Threads)))
+
+	add("labmd_memory_used_bytes", "Used system memory in bytes.", value(s.RAM.Used*gbToBytes))
+	add("labmd_memory_total_bytes", "Total system memory in bytes.", value(s.RAM.Total*gbToBytes))
+
+	var gpuUtil, gpuMemUtil, gpuMemUsed, gpuMemTotal, gpuTemp, gpuPower, gpuFan []Metric
+	for _, gpu := range s.GPUs {
+		labels := []Label{{"gpu", strconv.Itoa(gpu.ID)}, {"name", gpu.Name}}
+		gpuUtil = append(gpuUtil, value(float64(gpu.Util), labels...))
+		gpuMemUtil = append(gpuMemUtil, value(float64(gpu.MemUtil), labels...))
+		gpuMemUsed = append(gpuMemUsed, value(float64(gpu.MemUsed)*mbToBytes, labels...))
+		gpuMemTotal = append(gpuMemTotal, value(float64(gpu.MemTotal)*mbToBytes, labels...))
+		gpuTemp = append(gpuTemp, value(float64(gpu.Temp), labels...))
+		gpuPower = append(gpuPower, value(float64(gpu.Power), labels...))
+		gpuFan = append(gpuFan, value(float64(gpu.Fan), labels...))
+	}
+	add("labmd_gpu_utilization_percent", "GPU core utilization in percent.", gpuUtil...)
+	add("labmd_gpu_memory_utilization_percent", "GPU memory controller utilization in percent.", gpuMemUtil...)
+	add("labmd_gpu_memory_used_bytes", "Used GPU memory in bytes.", gpuMemUsed...)
+	add("labmd_gpu_memory_total_bytes", "Total GPU memory in bytes.", gpuMemTotal...)
+	add("labmd_gpu_temperature_celsius", "GPU temperature in degrees Celsius.", gpuTemp...)
+	add("labmd_gpu_power_watts", "GPU power draw in watts.", gpuPower...)
+	add("labmd_gpu_fan_speed_percent", "GPU fan speed in percent.", gpuFan...)
+
+	var partUsed, partTotal, partPercent []Metric
+	for _, part := range s.Disk.Partitions {
+		labels := []Label{{"mount", part.Path}, {"label", part.Label}}
+		partUsed = append(partUsed, value(part.Used*gbToBytes, labels...))
+		partTotal = append(partTotal, value(part.Total*gbToBytes, labels...))
+		if part.Total > 0 {
+			partPercent = append(partPercent, value(part.Used/part.Total*100, labels...))
+		}
+	}
+	add("labmd_partition_used_bytes", "Used space of a monitored partition in bytes.", partUsed...)
+	add("labmd_partition_total_bytes", "Total space of a monitored partition in bytes.", partTotal...)
+	add("labmd_partition_used_percent", "Used space of a monitored partition in percent.", partPercent...)
+
+	var userUsed []Metric
+	for _, u := range s.Disk.Users {
+		userUsed = append(userUsed, value(u.Used*gbToBytes, Label{"user", u.Name}, Label{"mount", "/home"}))
+	}
+	add("labmd_user_disk_used_bytes", "Disk space used by a home directory in bytes.", userUsed...)
+
+	if s.Slurm != nil {
+		resource := func(m slurm.ResourceMetric, scale float64) []Metric {
+			return []Metric{
+				value(float64(m.Used)*scale, Label{"state", "used"}),
+				value(float64(m.Available)*scale, Label{"state", "available"}),
+				value(float64(m.Total)*scale, Label{"state", "total"}),
+			}
+		}
+		add("labmd_slurm_cpus", "Slurm CPUs by allocation state.", resource(s.Slurm.CPU, 1)...)
+		add("labmd_slurm_memory_bytes", "Slurm memory by allocation state in bytes.", resource(s.Slurm.Memory, mbToBytes)...)
+		add("labmd_slurm_gpus", "Slurm GPUs by allocation state.", resource(s.Slurm.GPU, 1)...)
+	}
+	add("labmd_slurm_jobs", "Slurm jobs by partition and state.", jobCounts(s.Jobs)...)
+
+	return families
+}
+
+func jobCounts(jobs []slurm.Job) []Metric {
+	type key struct{ partition, state string }
+	counts := make(map[key]int)
+	for _, job := range jobs {
+		counts[key{job.Partition, job.State}]++
+	}
+
+	keys := make([]key, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].partition != keys[j].partition {
+			return keys[i].partition < keys[j].partition
+		}
+		return keys[i].state < keys[j].state
+	})
+
+	metrics := make([]Metric, 0, len(keys))
+	for _, k := range keys {
+		metrics = append(metrics, Metric{
+			Labels: []Label{{"partition", k.partition}, {"state", k.state}},
+			Value:  float64(counts[k]),
+		})
+	}
+	return metrics
+}
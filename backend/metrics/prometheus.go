@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	contentTypeText        = "text/plain; version=0.0.4; charset=utf-8"
+	contentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// Handler serves the snapshot returned by source in the Prometheus text
+// format, or OpenMetrics when the scraper asks for it.
+func Handler(source func() Snapshot) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+		if openMetrics {
+			w.Header().Set("Content-Type", contentTypeOpenMetrics)
+		} else {
+			w.Header().Set("Content-Type", contentTypeText)
+		}
+
+		WriteText(w, Collect(source()), openMetrics)
+	}
+}
+
+// WriteText encodes families in the Prometheus text exposition format. With
+// openMetrics set, the output is terminated with the OpenMetrics EOF marker.
+func WriteText(w io.Writer, families []Family, openMetrics bool) error {
+	bw := bufio.NewWriter(w)
+	for _, family := range families {
+		bw.WriteString("# HELP " + family.Name + " " + escapeHelp(family.Help) + "\n")
+		bw.WriteString("# TYPE " + family.Name + " " + family.Type + "\n")
+		for _, metric := range family.Metrics {
+			bw.WriteString(family.Name)
+			writeLabels(bw, metric.Labels)
+			bw.WriteString(" " + formatValue(metric.Value) + "\n")
+		}
+	}
+	if openMetrics {
+		bw.WriteString("# EOF\n")
+	}
+	return bw.Flush()
+}
+
+func writeLabels(bw *bufio.Writer, labels []Label) {
+	if len(labels) == 0 {
+		return
+	}
+	bw.WriteByte('{')
+	for i, label := range labels {
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		bw.WriteString(label.Name + `="` + escapeLabel(label.Value) + `"`)
+	}
+	bw.WriteByte('}')
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
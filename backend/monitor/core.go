@@ -35,15 +35,16 @@ type RAMStats struct {
 }
 
 type GPUStatsSeq struct {
-	ID       int    `json:"id"`
-	Util     int    `json:"util"`
-	MemUtil  int    `json:"memUtil"`
-	MemUsed  int    `json:"memUsed"`
-	MemTotal int    `json:"memTotal"`
-	Temp     int    `json:"temp"`
-	Power    int    `json:"power"`
-	Fan      int    `json:"fan"`
-	Name     string `json:"name"`
+	ID        int          `json:"id"`
+	Util      int          `json:"util"`
+	MemUtil   int          `json:"memUtil"`
+	MemUsed   int          `json:"memUsed"`
+	MemTotal  int          `json:"memTotal"`
+	Temp      int          `json:"temp"`
+	Power     int          `json:"power"`
+	Fan       int          `json:"fan"`
+	Name      string       `json:"name"`
+	Processes []GPUProcess `json:"processes,omitempty"`
 }
 
 type GPUStats struct {
@@ -274,21 +275,31 @@ func getGPUStatsNVML() (GPUStats, []GPUStatsSeq) {
 		temp, _ := dev.GetTemperature(nvml.TEMPERATURE_GPU)
 		power, _ := dev.GetPowerUsage()
 		fan, _ := dev.GetFanSpeed()
+		procs, _ := dev.GetComputeRunningProcesses()
 
 		memTotalMB := int(mem.Total / bytesToMB)
 		memUsedMB := int(mem.Used / bytesToMB)
 		powerW := int(power / 1000)
 
+		var processes []GPUProcess
+		for _, proc := range procs {
+			processes = append(processes, GPUProcess{
+				PID:     int(proc.Pid),
+				MemUsed: int(proc.UsedGpuMemory / bytesToMB),
+			})
+		}
+
 		gpus = append(gpus, GPUStatsSeq{
-			ID:       i,
-			Util:     int(util.Gpu),
-			MemUtil:  int(util.Memory),
-			MemUsed:  memUsedMB,
-			MemTotal: memTotalMB,
-			Temp:     int(temp),
-			Power:    powerW,
-			Fan:      int(fan),
-			Name:     name,
+			ID:        i,
+			Util:      int(util.Gpu),
+			MemUtil:   int(util.Memory),
+			MemUsed:   memUsedMB,
+			MemTotal:  memTotalMB,
+			Temp:      int(temp),
+			Power:     powerW,
+			Fan:       int(fan),
+			Name:      name,
+			Processes: processes,
 		})
 
 		memTotal += memTotalMB
@@ -302,6 +313,7 @@ func getGPUStatsNVML() (GPUStats, []GPUStatsSeq) {
 			maxTemp = int(temp)
 		}
 	}
+	attachProcessOwners(gpus)
 
 	if !gpuInfoLoaded {
 		staticGPUInfo.MemTotal = memTotal
@@ -324,7 +336,7 @@ func getGPUStatsNVML() (GPUStats, []GPUStatsSeq) {
 func getGPUStatsNvidiaSMI() (GPUStats, []GPUStatsSeq) {
 	cmd := exec.Command(
 		"nvidia-smi",
-		"--query-gpu=index,name,memory.total,temperature.gpu,utilization.gpu,utilization.memory,memory.used,power.draw,fan.speed",
+		"--query-gpu=index,name,memory.total,temperature.gpu,utilization.gpu,utilization.memory,memory.used,power.draw,fan.speed,pci.bus_id",
 		"--format=csv,noheader,nounits",
 	)
 
@@ -335,6 +347,7 @@ func getGPUStatsNvidiaSMI() (GPUStats, []GPUStatsSeq) {
 	}
 
 	gpus := make([]GPUStatsSeq, 0, 8)
+	busIDs := make(map[string]int)
 	var memTotal, memUsed, utilTotal, memUtilTotal, tempTotal, powerTotal, maxTemp int
 
 	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
@@ -358,6 +371,9 @@ func getGPUStatsNvidiaSMI() (GPUStats, []GPUStatsSeq) {
 		powerFloat, _ := strconv.ParseFloat(parts[7], 64)
 		power := int(powerFloat)
 		fan, _ := strconv.Atoi(parts[8])
+		if len(parts) > 9 {
+			busIDs[parts[9]] = len(gpus)
+		}
 
 		gpus = append(gpus, GPUStatsSeq{
 			ID:       idx,
@@ -387,6 +403,8 @@ func getGPUStatsNvidiaSMI() (GPUStats, []GPUStatsSeq) {
 		return GPUStats{}, []GPUStatsSeq{}
 	}
 
+	attachProcessesNvidiaSMI(gpus, busIDs)
+
 	if !gpuInfoLoaded {
 		staticGPUInfo.MemTotal = memTotal
 		staticGPUInfo.Name = generateGPUDisplayName(gpus)
@@ -405,6 +423,41 @@ func getGPUStatsNvidiaSMI() (GPUStats, []GPUStatsSeq) {
 	return stats, gpus
 }
 
+func attachProcessesNvidiaSMI(gpus []GPUStatsSeq, busIDs map[string]int) {
+	cmd := exec.Command(
+		"nvidia-smi",
+		"--query-compute-apps=gpu_bus_id,pid,used_memory",
+		"--format=csv,noheader,nounits",
+	)
+	out, err := cmd.Output()
+	if err != nil {
+		return
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		parts := strings.Split(strings.TrimSpace(line), ", ")
+		if len(parts) < 3 {
+			continue
+		}
+
+		idx, ok := busIDs[parts[0]]
+		if !ok {
+			continue
+		}
+		pid, err := strconv.Atoi(parts[1])
+		if err != nil {
+			continue
+		}
+		memUsedMB, _ := strconv.Atoi(parts[2])
+
+		gpus[idx].Processes = append(gpus[idx].Processes, GPUProcess{
+			PID:     pid,
+			MemUsed: memUsedMB,
+		})
+	}
+	attachProcessOwners(gpus)
+}
+
 func getCUDAVersionNvidiaSMI() string {
 	cmd := exec.Command("nvidia-smi")
 	out, err := cmd.Output()
@@ -1,6 +1,7 @@
 package monitor
 
 import (
+	"log"
 	"os/exec"
 	"slices"
 	"sort"
@@ -10,6 +11,9 @@ import (
 
 const bytesToGB = 1024 * 1024 * 1024
 
+// UserHomeBase is the directory whose subdirectories are sized per user.
+const UserHomeBase = "/home"
+
 type DiskConfig struct {
 	IncludedPartitions map[string]string
 	IgnoredPartitions  []string
@@ -40,7 +44,7 @@ func GetDiskUsage(config DiskConfig, skipUsers bool) DiskStats {
 	stats := DiskStats{}
 	stats.Partitions, stats.Total, stats.Used = getPartitions(config)
 	if !skipUsers {
-		stats.Users = getUserUsage(config, "/home")
+		stats.Users = getUserUsage(config, UserHomeBase)
 	}
 	return stats
 }
@@ -89,13 +93,44 @@ func getPartitions(config DiskConfig) ([]Partition, float64, float64) {
 }
 
 func getUserUsage(config DiskConfig, basePath string) []UserUsage {
-	cmd := exec.Command("du", "-d", "1", "-B1", basePath)
-	out, err := cmd.Output()
+	var users []UserUsage
+	var err error
+	if privileged != nil && basePath == UserHomeBase {
+		users, err = privileged.UserUsage()
+	} else {
+		users, err = ScanUserDirs(basePath)
+	}
 	if err != nil {
+		log.Printf("[WARN] User disk scan failed: %v", err)
 		return []UserUsage{}
 	}
 
-	var users []UserUsage
+	users = slices.DeleteFunc(users, func(u UserUsage) bool {
+		return slices.Contains(config.IgnoredUsers, u.Name)
+	})
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Used > users[j].Used
+	})
+
+	maxList := config.MaxUsersToList
+	if maxList > 0 && len(users) > maxList {
+		users = users[:maxList]
+	}
+
+	return users
+}
+
+// ScanUserDirs sizes every directory directly below basePath with du. It
+// needs read access to all of them, which is why an unprivileged server
+// leaves it to the root helper.
+func ScanUserDirs(basePath string) ([]UserUsage, error) {
+	cmd := exec.Command("du", "-d", "1", "-B1", basePath)
+	out, err := cmd.Output()
+	if err != nil && len(out) == 0 {
+		return nil, err
+	}
+
+	users := []UserUsage{}
 	lines := strings.SplitSeq(string(out), "\n")
 
 	for line := range lines {
@@ -111,27 +146,12 @@ func getUserUsage(config DiskConfig, basePath string) []UserUsage {
 		}
 
 		parts := strings.Split(path, "/")
-		name := parts[len(parts)-1]
-		if slices.Contains(config.IgnoredUsers, name) {
-			continue
-		}
-
 		users = append(users, UserUsage{
-			Name: name,
+			Name: parts[len(parts)-1],
 			Used: toGB(sizeBytes),
 		})
 	}
-
-	sort.Slice(users, func(i, j int) bool {
-		return users[i].Used > users[j].Used
-	})
-
-	maxList := config.MaxUsersToList
-	if maxList > 0 && len(users) > maxList {
-		users = users[:maxList]
-	}
-
-	return users
+	return users, nil
 }
 
 func toGB(bytes float64) float64 {
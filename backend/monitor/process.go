@@ -0,0 +1,116 @@
+package monitor
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type GPUProcess struct {
+	PID     int    `json:"pid"`
+	User    string `json:"user"`
+	MemUsed int    `json:"memUsed"`
+}
+
+// Privileged performs the collection steps that need root. An
+// unprivileged server sets it to a client of the root helper.
+type Privileged interface {
+	UserUsage() ([]UserUsage, error)
+	ProcessOwners(pids []int) (map[int]string, error)
+}
+
+var privileged Privileged
+
+// SetPrivileged routes user disk scans and process owner lookups through p.
+func SetPrivileged(p Privileged) {
+	privileged = p
+}
+
+const uidNameTTL = 10 * time.Minute
+
+type cachedName struct {
+	name    string
+	expires time.Time
+}
+
+// uidNames caches UID lookups, which go through NSS (and so possibly LDAP)
+// for every process and file otherwise. Entries expire so renamed accounts
+// show up.
+var uidNames = struct {
+	sync.Mutex
+	names map[uint32]cachedName
+}{names: make(map[uint32]cachedName)}
+
+// ProcessOwners returns the user names owning pids; processes that have
+// exited map to "". Without root, /proc may hide other users' processes
+// (hidepid), so the privileged helper is asked first when one is set, in
+// a single request for all of them.
+func ProcessOwners(pids []int) map[int]string {
+	if privileged != nil && len(pids) > 0 {
+		if owners, err := privileged.ProcessOwners(pids); err == nil {
+			return owners
+		}
+	}
+	owners := make(map[int]string, len(pids))
+	for _, pid := range pids {
+		owners[pid] = LocalProcessOwner(pid)
+	}
+	return owners
+}
+
+// attachProcessOwners fills in the owners of every GPU's processes with
+// one ProcessOwners call.
+func attachProcessOwners(gpus []GPUStatsSeq) {
+	var pids []int
+	for _, gpu := range gpus {
+		for _, proc := range gpu.Processes {
+			pids = append(pids, proc.PID)
+		}
+	}
+	if len(pids) == 0 {
+		return
+	}
+
+	owners := ProcessOwners(pids)
+	for i := range gpus {
+		for j := range gpus[i].Processes {
+			gpus[i].Processes[j].User = owners[gpus[i].Processes[j].PID]
+		}
+	}
+}
+
+// LocalProcessOwner looks the owner up in this process's view of /proc.
+func LocalProcessOwner(pid int) string {
+	info, err := os.Stat("/proc/" + strconv.Itoa(pid))
+	if err != nil {
+		return ""
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	return UserName(stat.Uid)
+}
+
+// UserName resolves a UID through a process-wide cache, falling back to the
+// numeric ID for unknown users.
+func UserName(uid uint32) string {
+	uidNames.Lock()
+	cached, ok := uidNames.names[uid]
+	uidNames.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.name
+	}
+
+	name := strconv.Itoa(int(uid))
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+	uidNames.Lock()
+	uidNames.names[uid] = cachedName{name, time.Now().Add(uidNameTTL)}
+	uidNames.Unlock()
+	return name
+}
@@ -0,0 +1,183 @@
+package reserve
+
+import (
+	"LabMD-backend/audit"
+	"LabMD-backend/auth"
+	"LabMD-backend/monitor"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+type GPUBookings struct {
+	ID         int           `json:"id"`
+	Name       string        `json:"name"`
+	Current    *Reservation  `json:"current,omitempty"`
+	Upcoming   []Reservation `json:"upcoming"`
+	Violations []Violation   `json:"violations,omitempty"`
+}
+
+type ListResponse struct {
+	GPUs         []GPUBookings `json:"gpus"`
+	Reservations []Reservation `json:"reservations"`
+	Violations   []Violation   `json:"violations"`
+}
+
+type createRequest struct {
+	User  string    `json:"user"`
+	GPUs  []int     `json:"gpus"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Note  string    `json:"note"`
+}
+
+// Handler serves GET (list), POST (create) and DELETE (?id=&user=) on the
+// reservation collection. gpus returns the latest cached GPU stats. When
+// the request is authenticated, the logged-in user replaces any user
+// named in the body or query. Admins may cancel anyone's booking. Bookings
+// and cancellations are recorded in auditLog.
+func Handler(store *Store, gpus func() []monitor.GPUStatsSeq, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(visibleOverview(store.Overview(gpus(), time.Now()), r))
+
+		case http.MethodPost:
+			var req createRequest
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+
+			created, err := store.Create(Reservation{
+				User:  requestUser(r, req.User),
+				GPUs:  req.GPUs,
+				Start: req.Start,
+				End:   req.End,
+				Note:  req.Note,
+			}, len(gpus()), time.Now())
+			var conflict *ConflictError
+			switch {
+			case errors.As(err, &conflict):
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(struct {
+					Error    string      `json:"error"`
+					Conflict Reservation `json:"conflict"`
+				}{conflict.Error(), conflict.Existing})
+				return
+			case err != nil:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			auditLog.RecordRequest(r, audit.Event{
+				User:   created.User,
+				Action: "reservation.create",
+				Target: created.ID,
+				Detail: fmt.Sprintf("GPUs %v from %s to %s", created.GPUs, created.Start.Format(time.RFC3339), created.End.Format(time.RFC3339)),
+			})
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(created)
+
+		case http.MethodDelete:
+			force := auth.RoleFrom(r.Context()) >= auth.RoleAdmin
+			id, user := r.URL.Query().Get("id"), requestUser(r, r.URL.Query().Get("user"))
+			err := store.Delete(id, user, force)
+			switch {
+			case errors.Is(err, ErrNotFound):
+				http.Error(w, err.Error(), http.StatusNotFound)
+			case errors.Is(err, ErrForbidden):
+				auditLog.RecordRequest(r, audit.Event{User: user, Action: "reservation.cancel", Target: id, Result: audit.Denied})
+				http.Error(w, err.Error(), http.StatusForbidden)
+			case err != nil:
+				http.Error(w, "Failed to delete reservation", http.StatusInternalServerError)
+			default:
+				auditLog.RecordRequest(r, audit.Event{User: user, Action: "reservation.cancel", Target: id})
+				w.WriteHeader(http.StatusNoContent)
+			}
+
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// visibleOverview hides the owners and PIDs of violating processes below
+// admin, as the GPU process list of /api/stats does. Members still see
+// their own.
+func visibleOverview(resp ListResponse, r *http.Request) ListResponse {
+	role := auth.RoleFrom(r.Context())
+	if role >= auth.RoleAdmin {
+		return resp
+	}
+
+	self := ""
+	if id := auth.IdentityFrom(r.Context()); id != nil && role >= auth.RoleMember {
+		self = id.Username
+	}
+	redact := func(violations []Violation) {
+		for i := range violations {
+			if self == "" || violations[i].User != self {
+				violations[i].User = ""
+				violations[i].PIDs = nil
+			}
+		}
+	}
+
+	// Overview builds fresh slices, so they can be changed in place
+	redact(resp.Violations)
+	for i := range resp.GPUs {
+		redact(resp.GPUs[i].Violations)
+	}
+	return resp
+}
+
+func requestUser(r *http.Request, claimed string) string {
+	if id := auth.IdentityFrom(r.Context()); id != nil {
+		return id.Username
+	}
+	return claimed
+}
+
+// Overview groups reservations by GPU index.
+func (s *Store) Overview(gpus []monitor.GPUStatsSeq, now time.Time) ListResponse {
+	reservations := s.List(now)
+	violations := s.Violations(gpus, now)
+
+	resp := ListResponse{
+		GPUs:         make([]GPUBookings, 0, len(gpus)),
+		Reservations: reservations,
+		Violations:   violations,
+	}
+	if resp.Violations == nil {
+		resp.Violations = []Violation{}
+	}
+
+	for _, gpu := range gpus {
+		bookings := GPUBookings{ID: gpu.ID, Name: gpu.Name, Upcoming: []Reservation{}}
+		for _, r := range reservations {
+			if !slices.Contains(r.GPUs, gpu.ID) {
+				continue
+			}
+			if now.Before(r.Start) {
+				bookings.Upcoming = append(bookings.Upcoming, r)
+			} else if bookings.Current == nil {
+				current := r
+				bookings.Current = &current
+			}
+		}
+		for _, v := range violations {
+			if v.GPU == gpu.ID {
+				bookings.Violations = append(bookings.Violations, v)
+			}
+		}
+		resp.GPUs = append(resp.GPUs, bookings)
+	}
+	return resp
+}
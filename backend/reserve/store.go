@@ -0,0 +1,248 @@
+package reserve
+
+import (
+	"LabMD-backend/monitor"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const maxNoteLength = 200
+
+type Reservation struct {
+	ID      string    `json:"id"`
+	User    string    `json:"user"`
+	GPUs    []int     `json:"gpus"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Note    string    `json:"note"`
+	Created time.Time `json:"created"`
+}
+
+type Violation struct {
+	GPU           int    `json:"gpu"`
+	ReservationID string `json:"reservationId"`
+	ReservedBy    string `json:"reservedBy"`
+	User          string `json:"user"`
+	PIDs          []int  `json:"pids"`
+}
+
+type Config struct {
+	Path        string
+	MaxDuration time.Duration
+	MaxAhead    time.Duration
+	ExemptUsers []string // Process owners never flagged, e.g. root
+}
+
+var (
+	ErrNotFound  = errors.New("reservation not found")
+	ErrForbidden = errors.New("reservation belongs to another user")
+
+	userPattern = regexp.MustCompile(`^[a-z_][a-z0-9_.-]{0,31}$`)
+)
+
+// ConflictError reports the existing reservation that overlaps a request.
+type ConflictError struct {
+	Existing Reservation
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("GPU already reserved by %s from %s to %s",
+		e.Existing.User, e.Existing.Start.Format(time.RFC3339), e.Existing.End.Format(time.RFC3339))
+}
+
+type Store struct {
+	mu     sync.Mutex
+	config Config
+	items  []Reservation
+}
+
+func Open(config Config) (*Store, error) {
+	s := &Store{config: config}
+
+	data, err := os.ReadFile(config.Path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.items); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", config.Path, err)
+	}
+	return s, nil
+}
+
+// Create validates r against the booking rules and existing reservations,
+// then persists it. GPU indices are checked against gpuCount when known.
+func (s *Store) Create(r Reservation, gpuCount int, now time.Time) (Reservation, error) {
+	if !userPattern.MatchString(r.User) {
+		return r, errors.New("invalid user name")
+	}
+	if len(r.GPUs) == 0 {
+		return r, errors.New("at least one GPU is required")
+	}
+	slices.Sort(r.GPUs)
+	r.GPUs = slices.Compact(r.GPUs)
+	for _, gpu := range r.GPUs {
+		if gpu < 0 || (gpuCount > 0 && gpu >= gpuCount) {
+			return r, fmt.Errorf("unknown GPU %d", gpu)
+		}
+	}
+	if !r.End.After(r.Start) {
+		return r, errors.New("end must be after start")
+	}
+	if !r.End.After(now) {
+		return r, errors.New("reservation is already over")
+	}
+	if s.config.MaxDuration > 0 && r.End.Sub(r.Start) > s.config.MaxDuration {
+		return r, fmt.Errorf("reservations are limited to %s", s.config.MaxDuration)
+	}
+	if s.config.MaxAhead > 0 && r.Start.Sub(now) > s.config.MaxAhead {
+		return r, fmt.Errorf("reservations can start at most %s ahead", s.config.MaxAhead)
+	}
+	if len([]rune(r.Note)) > maxNoteLength {
+		return r, fmt.Errorf("note is limited to %d characters", maxNoteLength)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(now)
+
+	for _, existing := range s.items {
+		if existing.Start.Before(r.End) && r.Start.Before(existing.End) && overlaps(existing.GPUs, r.GPUs) {
+			return r, &ConflictError{Existing: existing}
+		}
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	r.ID = hex.EncodeToString(id)
+	r.Created = now
+
+	s.items = append(s.items, r)
+	if err := s.save(); err != nil {
+		s.items = s.items[:len(s.items)-1]
+		return r, err
+	}
+	return r, nil
+}
+
+// Delete removes a reservation. Only its owner may delete it unless force
+// is set.
+func (s *Store) Delete(id, user string, force bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, existing := range s.items {
+		if existing.ID != id {
+			continue
+		}
+		if existing.User != user && !force {
+			return ErrForbidden
+		}
+
+		previous := s.items
+		s.items = slices.Delete(slices.Clone(s.items), i, i+1)
+		if err := s.save(); err != nil {
+			s.items = previous
+			return err
+		}
+		return nil
+	}
+	return ErrNotFound
+}
+
+// List returns active and upcoming reservations ordered by start time.
+func (s *Store) List(now time.Time) []Reservation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune(now)
+	items := slices.Clone(s.items)
+	sort.Slice(items, func(i, j int) bool { return items[i].Start.Before(items[j].Start) })
+	return items
+}
+
+// Violations reports GPUs that are reserved right now but run processes
+// of a different user.
+func (s *Store) Violations(gpus []monitor.GPUStatsSeq, now time.Time) []Violation {
+	var violations []Violation
+	for _, r := range s.List(now) {
+		if now.Before(r.Start) {
+			continue
+		}
+		for _, gpu := range gpus {
+			if !slices.Contains(r.GPUs, gpu.ID) {
+				continue
+			}
+
+			byUser := make(map[string][]int)
+			for _, proc := range gpu.Processes {
+				if proc.User == "" || proc.User == r.User || slices.Contains(s.config.ExemptUsers, proc.User) {
+					continue
+				}
+				byUser[proc.User] = append(byUser[proc.User], proc.PID)
+			}
+			for user, pids := range byUser {
+				violations = append(violations, Violation{
+					GPU:           gpu.ID,
+					ReservationID: r.ID,
+					ReservedBy:    r.User,
+					User:          user,
+					PIDs:          pids,
+				})
+			}
+		}
+	}
+
+	sort.Slice(violations, func(i, j int) bool {
+		if violations[i].GPU != violations[j].GPU {
+			return violations[i].GPU < violations[j].GPU
+		}
+		return violations[i].User < violations[j].User
+	})
+	return violations
+}
+
+// prune drops finished reservations; it must be called with s.mu held.
+// Pruned entries are persisted lazily with the next write.
+func (s *Store) prune(now time.Time) {
+	s.items = slices.DeleteFunc(s.items, func(r Reservation) bool {
+		return !r.End.After(now)
+	})
+}
+
+func (s *Store) save() error {
+	data, err := json.MarshalIndent(s.items, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.config.Path), 0o755); err != nil {
+		return err
+	}
+	tmp := s.config.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.config.Path)
+}
+
+func overlaps(a, b []int) bool {
+	for _, x := range a {
+		if slices.Contains(b, x) {
+			return true
+		}
+	}
+	return false
+}
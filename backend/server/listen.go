@@ -0,0 +1,141 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const unixPrefix = "unix:"
+
+// SocketOptions controls the permissions of unix domain sockets.
+type SocketOptions struct {
+	Mode  os.FileMode // e.g. 0660
+	Group string      // Group name or numeric ID; empty keeps the process group
+}
+
+// IsUnix reports whether addr names a unix domain socket ("unix:/path").
+func IsUnix(addr string) bool {
+	return strings.HasPrefix(addr, unixPrefix)
+}
+
+// Listen opens a TCP "host:port" address or a "unix:/path" socket.
+func Listen(addr string, opts SocketOptions) (net.Listener, error) {
+	if !IsUnix(addr) {
+		return net.Listen("tcp", addr)
+	}
+
+	path := strings.TrimPrefix(addr, unixPrefix)
+	if !filepath.IsAbs(path) {
+		return nil, fmt.Errorf("socket path %q must be absolute", path)
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := applySocketOptions(path, opts); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// ActivationListener returns the socket passed in by systemd socket
+// activation, or nil when the process was started without one.
+func ActivationListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	// Passed descriptors start at 3; only the first is used
+	file := os.NewFile(3, "systemd-socket")
+	defer file.Close()
+	syscall.CloseOnExec(3)
+	return net.FileListener(file)
+}
+
+// TCPPort returns the port of the first TCP address in addrs.
+func TCPPort(addrs []string) (int, bool) {
+	for _, addr := range addrs {
+		if IsUnix(addr) {
+			continue
+		}
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			continue
+		}
+		if n, err := strconv.Atoi(port); err == nil {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// removeStaleSocket deletes a socket left behind by a previous run. A
+// socket that still accepts connections belongs to a running instance and
+// anything that is not a socket is never touched.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+	return os.Remove(path)
+}
+
+func applySocketOptions(path string, opts SocketOptions) error {
+	if opts.Group != "" {
+		gid, err := lookupGroup(opts.Group)
+		if err != nil {
+			return err
+		}
+		if err := os.Chown(path, -1, gid); err != nil {
+			return err
+		}
+	}
+	if opts.Mode != 0 {
+		return os.Chmod(path, opts.Mode)
+	}
+	return nil
+}
+
+func lookupGroup(name string) (int, error) {
+	if gid, err := strconv.Atoi(name); err == nil {
+		return gid, nil
+	}
+	group, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(group.Gid)
+}
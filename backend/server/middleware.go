@@ -0,0 +1,226 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	corsAllowMethods = "GET, POST, PUT, DELETE, OPTIONS"
+	corsAllowHeaders = "Authorization, Content-Type, If-Match, If-None-Match, Last-Event-ID"
+	corsMaxAge       = "600"
+
+	limiterIdleTTL = 10 * time.Minute
+)
+
+// CORS allows cross-origin requests from origins. "*" admits any origin
+// without credentials; listed origins are echoed back and may send cookies.
+// Preflight requests from allowed origins are answered directly.
+func CORS(origins []string, next http.Handler) http.Handler {
+	if len(origins) == 0 {
+		return next
+	}
+	wildcard := slices.Contains(origins, "*")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		switch {
+		case wildcard:
+			h.Set("Access-Control-Allow-Origin", "*")
+		case slices.Contains(origins, origin):
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Set("Access-Control-Allow-Credentials", "true")
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", corsAllowMethods)
+			h.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			h.Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SecurityHeaders sets the Content-Security-Policy and frame options given
+// (each omitted when empty) plus nosniff and a same-origin referrer policy.
+// Browsers prefer the policy's frame-ancestors over X-Frame-Options, so a
+// policy without one gets the directive matching frameOptions.
+func SecurityHeaders(csp, frameOptions string, next http.Handler) http.Handler {
+	if csp != "" && !strings.Contains(csp, "frame-ancestors") {
+		switch frameOptions {
+		case "DENY":
+			csp += "; frame-ancestors 'none'"
+		case "SAMEORIGIN":
+			csp += "; frame-ancestors 'self'"
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "same-origin")
+		if csp != "" {
+			h.Set("Content-Security-Policy", csp)
+		}
+		if frameOptions != "" {
+			h.Set("X-Frame-Options", frameOptions)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RateLimiter is a per-client-IP token bucket. Requests over unix sockets
+// carry no client address and are not limited unless the socket is a
+// trusted proxy; otherwise the proxy in front of it is expected to do that.
+type RateLimiter struct {
+	rate  float64 // Tokens per second
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(perSec float64, burst int) *RateLimiter {
+	return &RateLimiter{rate: perSec, burst: float64(burst), buckets: make(map[string]*bucket)}
+}
+
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := ClientIP(r)
+		if net.ParseIP(ip) == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !l.allow(ip, time.Now()) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(1/l.rate)+1))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *RateLimiter) allow(ip string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.swept) > limiterIdleTTL {
+		for key, b := range l.buckets {
+			if now.Sub(b.last) > limiterIdleTTL {
+				delete(l.buckets, key)
+			}
+		}
+		l.swept = now
+	}
+
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+type accessEntryKey struct{}
+
+type accessEntry struct {
+	user string
+}
+
+// AccessLog writes one structured record per request with its status,
+// size and latency once the handler returns. Streaming requests are
+// logged when the client disconnects.
+func AccessLog(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		entry := &accessEntry{}
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), accessEntryKey{}, entry)))
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		logger.LogAttrs(r.Context(), slog.LevelInfo, "request",
+			slog.String("remote", ClientIP(r)),
+			slog.String("user", entry.user),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Int64("bytes", rec.bytes),
+			slog.Float64("durationMs", float64(time.Since(start).Microseconds())/1000),
+			slog.String("userAgent", r.UserAgent()),
+		)
+	})
+}
+
+// SetLogUser records the authenticated user in the request's access log
+// entry. It does nothing when access logging is off.
+func SetLogUser(r *http.Request, user string) {
+	if entry, ok := r.Context().Value(accessEntryKey{}).(*accessEntry); ok {
+		entry.user = user
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps the stats stream working through the recorder.
+func (w *statusRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustUnix stands for peers connecting over a unix domain socket in a
+// trusted proxy list.
+const TrustUnix = "unix"
+
+// TrustedProxies resolves the client address of requests relayed by
+// reverse proxies from their X-Forwarded-For header. The header is only
+// honored when the direct peer is a trusted proxy.
+type TrustedProxies struct {
+	nets []*net.IPNet
+	unix bool
+}
+
+type clientIPKey struct{}
+
+// ParseTrustedProxies accepts IP addresses, CIDR ranges and TrustUnix.
+func ParseTrustedProxies(list []string) (*TrustedProxies, error) {
+	p := &TrustedProxies{}
+	for _, entry := range list {
+		entry = strings.TrimSpace(entry)
+		if entry == TrustUnix {
+			p.unix = true
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			p.nets = append(p.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+		}
+		p.nets = append(p.nets, ipNet)
+	}
+	return p, nil
+}
+
+// Middleware records the resolved client address for ClientIP. It has to
+// wrap everything that reads the address, the access log included.
+func (p *TrustedProxies) Middleware(next http.Handler) http.Handler {
+	if len(p.nets) == 0 && !p.unix {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := p.resolve(r); ip != "" {
+			r = r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// resolve walks X-Forwarded-For from the nearest hop back and returns the
+// first address that is not a trusted proxy, or "" when the direct peer
+// is not trusted.
+func (p *TrustedProxies) resolve(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	switch {
+	case err != nil && !p.unix:
+		return ""
+	case err == nil && !p.trusts(net.ParseIP(host)):
+		return ""
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for hop := range strings.SplitSeq(header, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+
+	client := ""
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil {
+			// Anything before a malformed hop cannot be vouched for
+			break
+		}
+		client = ip.String()
+		if !p.trusts(ip) {
+			break
+		}
+	}
+	return client
+}
+
+func (p *TrustedProxies) trusts(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range p.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client behind a trusted proxy, or
+// else the host part of the request's remote address.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
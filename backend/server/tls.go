@@ -0,0 +1,237 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// certCheckInterval bounds how often handshakes stat the certificate files.
+const certCheckInterval = 10 * time.Second
+
+// CertReloader serves a certificate from disk and picks up renewed files
+// without a restart. A pair that fails to load (e.g. the renewal job has
+// written the certificate but not yet the key) keeps the old certificate
+// in use until a later check succeeds.
+type CertReloader struct {
+	certFile, keyFile string
+	logf              func(string, ...any)
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	certMod  time.Time
+	keyMod   time.Time
+	checked  time.Time
+	failedAt [2]time.Time // Modification times of the last pair that failed to load
+}
+
+func NewCertReloader(certFile, keyFile string, logf func(string, ...any)) (*CertReloader, error) {
+	c := &CertReloader{certFile: certFile, keyFile: keyFile, logf: logf}
+	certMod, keyMod, err := c.modTimes()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.load(certMod, keyMod); err != nil {
+		return nil, err
+	}
+	c.checked = time.Now()
+	return c, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now := time.Now(); now.Sub(c.checked) >= certCheckInterval {
+		c.checked = now
+		c.maybeReload()
+	}
+	return c.cert, nil
+}
+
+// TLSConfig returns a server configuration using the reloader.
+func (c *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.GetCertificate,
+	}
+}
+
+// maybeReload must be called with c.mu held.
+func (c *CertReloader) maybeReload() {
+	certMod, keyMod, err := c.modTimes()
+	if err != nil {
+		c.log("[WARN] Checking certificate: %v", err)
+		return
+	}
+	if certMod.Equal(c.certMod) && keyMod.Equal(c.keyMod) {
+		return
+	}
+	if certMod.Equal(c.failedAt[0]) && keyMod.Equal(c.failedAt[1]) {
+		return
+	}
+
+	if err := c.load(certMod, keyMod); err != nil {
+		c.failedAt = [2]time.Time{certMod, keyMod}
+		c.log("[WARN] Keeping current certificate, reload failed: %v", err)
+		return
+	}
+	c.log("Reloaded certificate %s (expires %s)", c.certFile, c.cert.Leaf.NotAfter.Format(time.DateOnly))
+}
+
+func (c *CertReloader) load(certMod, keyMod time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert = &cert
+	c.certMod, c.keyMod = certMod, keyMod
+	return nil
+}
+
+func (c *CertReloader) modTimes() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
+
+func (c *CertReloader) log(format string, args ...any) {
+	if c.logf != nil {
+		c.logf("[TLS] "+format, args...)
+	}
+}
+
+// GenerateSelfSigned writes an ECDSA P-256 certificate and key valid for
+// the local hostname, localhost and hosts. Existing files are left alone,
+// so it only has an effect on first boot.
+func GenerateSelfSigned(certFile, keyFile string, hosts []string, validFor time.Duration) (bool, error) {
+	if fileExists(certFile) && fileExists(keyFile) {
+		return false, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return false, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return false, err
+	}
+
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"LabMD"}, CommonName: hostname},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(validFor),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range append([]string{hostname, "localhost", "127.0.0.1", "::1"}, hosts...) {
+		if host == "" {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return false, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return false, err
+	}
+
+	for _, path := range []string{certFile, keyFile} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return false, err
+		}
+	}
+	// Key first: a reloader never sees a new certificate with the old key
+	if err := writePEM(keyFile, "PRIVATE KEY", keyDER, 0o600); err != nil {
+		return false, err
+	}
+	if err := writePEM(certFile, "CERTIFICATE", der, 0o644); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// RedirectHandler sends plain HTTP requests to the same host and path on
+// the HTTPS port.
+func RedirectHandler(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host == "" {
+			http.Error(w, "Missing Host header", http.StatusBadRequest)
+			return
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		} else if net.ParseIP(host) != nil && net.ParseIP(host).To4() == nil {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// HSTS adds a Strict-Transport-Security header to responses served over
+// TLS. maxAge of zero returns next unchanged.
+func HSTS(maxAge time.Duration, includeSubdomains bool, next http.Handler) http.Handler {
+	if maxAge <= 0 {
+		return next
+	}
+	value := fmt.Sprintf("max-age=%d", int(maxAge.Seconds()))
+	if includeSubdomains {
+		value += "; includeSubDomains"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -44,6 +44,16 @@ func GetJobs() ([]Job, error) {
 	return cloneJobs(jobState.jobs), nil
 }
 
+// CachedJobs returns the last polled job list without triggering a collection.
+func CachedJobs() ([]Job, bool) {
+	jobState.RLock()
+	defer jobState.RUnlock()
+	if !jobState.ready {
+		return nil, false
+	}
+	return cloneJobs(jobState.jobs), true
+}
+
 func UpdateJobs() error {
 	jobs, err := collectJobs()
 	if err != nil {
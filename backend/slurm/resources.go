@@ -28,8 +28,15 @@ var resourceState = struct {
 	summary ResourceSummary
 	ready   bool
 	once    sync.Once
+	onPoll  func()
 }{}
 
+// SetPollHook registers fn to run after every background poll. It must be
+// called before StartPolling.
+func SetPollHook(fn func()) {
+	resourceState.onPoll = fn
+}
+
 func GetResourceSummary() (ResourceSummary, error) {
 	resourceState.RLock()
 	if resourceState.ready {
@@ -48,6 +55,17 @@ func GetResourceSummary() (ResourceSummary, error) {
 	return cloneResourceSummary(resourceState.summary), nil
 }
 
+// CachedResourceSummary returns the last polled summary without triggering a
+// collection. The boolean is false until the first successful poll.
+func CachedResourceSummary() (ResourceSummary, bool) {
+	resourceState.RLock()
+	defer resourceState.RUnlock()
+	if !resourceState.ready {
+		return ResourceSummary{}, false
+	}
+	return cloneResourceSummary(resourceState.summary), true
+}
+
 func UpdateResourceSummary() error {
 	summary, err := collectResourceSummary()
 	if err != nil {
@@ -72,6 +90,7 @@ func StartPolling(intervalSec int, logf func(string, ...any)) {
 		if err := UpdateJobs(); err != nil {
 			logSlurmf(logf, "Initial Slurm job scan failed: %v", err)
 		}
+		runPollHook()
 
 		go func() {
 			ticker := time.NewTicker(time.Duration(intervalSec) * time.Second)
@@ -84,6 +103,7 @@ func StartPolling(intervalSec int, logf func(string, ...any)) {
 				if err := UpdateJobs(); err != nil {
 					logSlurmf(logf, "Slurm job scan failed: %v", err)
 				}
+				runPollHook()
 			}
 		}()
 	})
@@ -153,6 +173,12 @@ func cloneResourceSummary(summary ResourceSummary) ResourceSummary {
 	return summary
 }
 
+func runPollHook() {
+	if resourceState.onPoll != nil {
+		resourceState.onPoll()
+	}
+}
+
 func logSlurmf(logf func(string, ...any), format string, args ...any) {
 	if logf != nil {
 		logf(format, args...)
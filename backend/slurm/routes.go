@@ -1,6 +1,7 @@
 package slurm
 
 import (
+	"LabMD-backend/auth"
 	"encoding/json"
 	"net/http"
 )
@@ -11,7 +12,6 @@ type OverviewResponse struct {
 }
 
 func OverviewHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Content-Type", "application/json")
 
 	summary, err := GetResourceSummary()
@@ -28,6 +28,28 @@ func OverviewHandler(w http.ResponseWriter, r *http.Request) {
 
 	json.NewEncoder(w).Encode(OverviewResponse{
 		Resources: summary,
-		Jobs:      jobs,
+		Jobs:      visibleJobs(r, jobs),
 	})
 }
+
+// visibleJobs hides job owners from everyone below admin, except that
+// members still see their own name on their jobs.
+func visibleJobs(r *http.Request, jobs []Job) []Job {
+	if auth.RoleFrom(r.Context()) >= auth.RoleAdmin {
+		return jobs
+	}
+
+	self := ""
+	if id := auth.IdentityFrom(r.Context()); id != nil && auth.RoleFrom(r.Context()) >= auth.RoleMember {
+		self = id.Username
+	}
+
+	redacted := make([]Job, len(jobs))
+	for i, job := range jobs {
+		if job.User != self {
+			job.User = ""
+		}
+		redacted[i] = job
+	}
+	return redacted
+}
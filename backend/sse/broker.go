@@ -0,0 +1,186 @@
+package sse
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	subscriberBuffer  = 8
+	heartbeatInterval = 15 * time.Second
+)
+
+type Event struct {
+	ID   uint64
+	Name string
+	Data []byte
+}
+
+// Broker fans events out to Server-Sent Events subscribers and keeps the
+// last few events so reconnecting clients can resume via Last-Event-ID.
+type Broker struct {
+	mu          sync.Mutex
+	nextID      uint64
+	history     []Event
+	historySize int
+	subscribers map[chan Event]struct{}
+	onChange    func(count int)
+	filter      func(r *http.Request) func(data []byte) []byte
+}
+
+func NewBroker(historySize int) *Broker {
+	if historySize < 1 {
+		historySize = 1
+	}
+	return &Broker{
+		nextID:      1,
+		historySize: historySize,
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// OnSubscribersChange registers fn to run whenever a client connects or
+// disconnects. It must be set before the broker is served.
+func (b *Broker) OnSubscribersChange(fn func(count int)) {
+	b.onChange = fn
+}
+
+// SetFilter registers fn to pick a per-subscriber transform of event data,
+// e.g. to redact fields the client may not see. fn runs once per
+// connection; a nil transform sends events unchanged. It must be set
+// before the broker is served.
+func (b *Broker) SetFilter(fn func(r *http.Request) func(data []byte) []byte) {
+	b.filter = fn
+}
+
+func (b *Broker) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
+
+// Publish assigns the next event ID and delivers the event to every
+// subscriber. Subscribers that cannot keep up miss the event and catch up
+// with the next one.
+func (b *Broker) Publish(name string, data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	event := Event{ID: b.nextID, Name: name, Data: data}
+	b.nextID++
+
+	b.history = append(b.history, event)
+	if len(b.history) > b.historySize {
+		b.history = b.history[len(b.history)-b.historySize:]
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+func (b *Broker) subscribe(lastID uint64, resume bool) (chan Event, []Event) {
+	b.mu.Lock()
+	ch := make(chan Event, subscriberBuffer)
+	b.subscribers[ch] = struct{}{}
+	count := len(b.subscribers)
+
+	// Replay what the client missed; if its ID is unknown (too old or from
+	// before a restart) send the latest event so it starts from a full state.
+	var backlog []Event
+	if resume && len(b.history) > 0 && lastID >= b.history[0].ID-1 && lastID < b.nextID {
+		for _, event := range b.history {
+			if event.ID > lastID {
+				backlog = append(backlog, event)
+			}
+		}
+	} else if len(b.history) > 0 {
+		backlog = []Event{b.history[len(b.history)-1]}
+	}
+	b.mu.Unlock()
+
+	if b.onChange != nil {
+		b.onChange(count)
+	}
+	return ch, backlog
+}
+
+func (b *Broker) unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	delete(b.subscribers, ch)
+	count := len(b.subscribers)
+	b.mu.Unlock()
+
+	if b.onChange != nil {
+		b.onChange(count)
+	}
+}
+
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	lastHeader := r.Header.Get("Last-Event-ID")
+	lastID, err := strconv.ParseUint(lastHeader, 10, 64)
+	resume := lastHeader != "" && err == nil
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	var transform func([]byte) []byte
+	if b.filter != nil {
+		transform = b.filter(r)
+	}
+
+	ch, backlog := b.subscribe(lastID, resume)
+	defer b.unsubscribe(ch)
+
+	for _, event := range backlog {
+		writeEvent(w, event, transform)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-ch:
+			writeEvent(w, event, transform)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event Event, transform func([]byte) []byte) {
+	if transform != nil {
+		event.Data = transform(event.Data)
+	}
+
+	fmt.Fprintf(w, "id: %d\n", event.ID)
+	if event.Name != "" {
+		fmt.Fprintf(w, "event: %s\n", event.Name)
+	}
+	for _, line := range bytes.Split(event.Data, []byte("\n")) {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
@@ -3,7 +3,7 @@ import {
   Activity, BookOpen, Menu, X,
   CircuitBoard, Tag,
   Folder, FolderOpen, ChevronRight, ChevronDown, FileText,
-  LayoutPanelTop, Home, Workflow
+  LayoutPanelTop, Home, Workflow, LogOut, LogIn
 } from 'lucide-react';
 import { motion, AnimatePresence } from 'framer-motion';
 import { processStats, processConfig } from './utils/dataProcessing';
@@ -12,6 +12,7 @@ import ThemeToggle from './components/Common/ThemeToggle';
 import MonitorView from './components/Monitor/MonitorView';
 import DocsView from './components/Docs/DocsView';
 import SlurmView from './components/Slurm/SlurmView';
+import LoginView from './components/Auth/LoginView';
 
 const App = () => {
   const [activeTab, setActiveTab] = useState('monitor');
@@ -28,6 +29,15 @@ const App = () => {
   const [docLoading, setDocLoading] = useState(false);
   const [expandedFolders, setExpandedFolders] = useState({});
 
+  // Auth State (only used when the server has auth enabled)
+  const [authUser, setAuthUser] = useState(null);
+  const [authRole, setAuthRole] = useState('anonymous');
+  const [authChecked, setAuthChecked] = useState(false);
+  const [loginRequired, setLoginRequired] = useState(true);
+  const [showLogin, setShowLogin] = useState(false);
+  const needsLogin = config.authEnabled && !authUser && (loginRequired || showLogin);
+  const canReadDocs = !config.authEnabled || authRole === 'member' || authRole === 'admin';
+
   // Theme State
   const [themeMode, setThemeMode] = useState(() => localStorage.getItem('theme') || 'auto');
 
@@ -74,13 +84,75 @@ const App = () => {
     fetchConfig();
   }, []);
 
+  // Ask the server who we are; also used to notice expired sessions
+  const checkAuth = useCallback(async () => {
+    try {
+      const res = await fetch('/api/auth/me');
+      if (res.ok) {
+        const data = await res.json();
+        setAuthUser(data.authenticated ? data.user : null);
+        setAuthRole(data.role || 'anonymous');
+        setLoginRequired(data.loginRequired ?? true);
+      }
+    } catch (err) {
+      console.error('Failed to check login:', err);
+    } finally {
+      setAuthChecked(true);
+    }
+  }, []);
+
+  useEffect(() => {
+    if (config.authEnabled) {
+      checkAuth();
+    }
+  }, [config.authEnabled, checkAuth]);
+
+  const handleLogout = useCallback(async () => {
+    try {
+      await fetch('/api/auth/logout', { method: 'POST' });
+    } catch (err) {
+      console.error('Logout failed:', err);
+    }
+    setAuthUser(null);
+    setAuthRole('anonymous');
+    setActiveTab('monitor');
+    setFileTree(null);
+    setSelectedFile(null);
+    setContent("");
+  }, []);
+
   // --- Core Connection Logic (Data Engine) ---
+  // Prefer the server push stream; fall back to polling where EventSource is unavailable
   useEffect(() => {
+    if (needsLogin) return;
+
+    if (typeof EventSource !== 'undefined') {
+      // EventSource reconnects on its own and resumes via Last-Event-ID
+      const source = new EventSource('/api/stats/stream');
+      source.addEventListener('stats', (event) => {
+        try {
+          setStats(processStats(JSON.parse(event.data), config));
+          setIsLive(true);
+        } catch (err) {
+          console.error('Stream Parse Error:', err);
+        }
+      });
+      source.onerror = () => {
+        setIsLive(false);
+        if (config.authEnabled) checkAuth();
+      };
+      return () => source.close();
+    }
+
     const fetchData = async () => {
       try {
         const res = await fetch('/api/stats');
+        if (res.status === 401) {
+          setAuthUser(null);
+          return;
+        }
         if (!res.ok) throw new Error('API Error');
-        
+
         const rawData = await res.json();
         const cleanData = processStats(rawData, config);
 
@@ -94,20 +166,27 @@ const App = () => {
       }
     };
     const timer = setInterval(fetchData, config.intervalCRG * 1000);
-    fetchData(); 
+    fetchData();
     return () => clearInterval(timer);
-  }, [config.intervalCRG]);
+  }, [config.intervalCRG, config.authEnabled, needsLogin, authUser?.username, checkAuth]);
 
   const handleSelectFile = useCallback(async (node) => {
     setSelectedFile(node);
     setDocLoading(true);
     setMobileMenuOpen(false);
+    // Keep the document in the URL so it can be linked (/?doc=path/to/file.md)
+    const url = new URL(window.location.href);
+    url.searchParams.set('doc', node.path);
+    window.history.replaceState(null, '', url);
     try {
       const res = await fetch(`/api/docs/content?path=${encodeURIComponent(node.path)}`);
       
       if (res.ok) {
         const text = await res.text();
-        setContent(text);
+        // YAML front matter is not part of the document; the tree carries its
+        // title and tags. The server says how many lines it took as such.
+        const frontLines = Number(res.headers.get('X-Front-Matter-Lines')) || 0;
+        setContent(frontLines ? text.split('\n').slice(frontLines).join('\n') : text);
       } else {
         setContent("Error loading content: File not found.");
       }
@@ -126,11 +205,29 @@ const App = () => {
       const data = await res.json();
       setFileTree(data);
       
-      // Auto-select first document (backend already sorted: default doc first, then files, then dirs)
+      // Auto-select the document named in the URL, else the first one
+      // (backend already sorted: default doc first, then files, then dirs)
       if (data && data.children && data.children.length > 0 && !selectedFile) {
-        // Find first file (not directory)
-        const firstFile = data.children.find(node => node.type === 'file');
+        const linked = new URLSearchParams(window.location.search).get('doc');
+        const findFile = (nodes) => {
+          for (const node of nodes || []) {
+            if (node.type === 'file' && node.path === linked) return node;
+            const found = node.type === 'dir' && findFile(node.children);
+            if (found) return found;
+          }
+          return null;
+        };
+        const firstFile = (linked && findFile(data.children)) || data.children.find(node => node.type === 'file');
         if (firstFile) {
+          if (firstFile.path === linked) {
+            // Open the folders leading to it
+            const dirs = linked.split('/').slice(0, -1);
+            setExpandedFolders(prev => {
+              const next = { ...prev };
+              dirs.forEach((_, i) => { next[dirs.slice(0, i + 1).join('/')] = true; });
+              return next;
+            });
+          }
           handleSelectFile(firstFile);
         }
       }
@@ -140,10 +237,10 @@ const App = () => {
   }, [selectedFile, handleSelectFile]);
 
   useEffect(() => {
-    if (activeTab === 'docs') {
+    if (activeTab === 'docs' && !needsLogin && canReadDocs) {
       fetchTree();
     }
-  }, [activeTab, fetchTree]);
+  }, [activeTab, fetchTree, needsLogin, canReadDocs]);
 
   useEffect(() => {
     if (activeTab === 'slurm' && !config.slurm.available) {
@@ -204,12 +301,14 @@ const App = () => {
             onClick={() => { setActiveTab('slurm'); setMobileMenuOpen(false); }} 
           />
         )}
-        <NavItem 
-          icon={<BookOpen />} 
-          label="Lab Docs" 
-          active={activeTab === 'docs'} 
-          onClick={() => { setActiveTab('docs'); setMobileMenuOpen(false); }} 
-        />
+        {canReadDocs && (
+          <NavItem 
+            icon={<BookOpen />} 
+            label="Lab Docs" 
+            active={activeTab === 'docs'} 
+            onClick={() => { setActiveTab('docs'); setMobileMenuOpen(false); }} 
+          />
+        )}
       </nav>
       
       {/* File Tree - independent scrollable area */}
@@ -255,6 +354,26 @@ const App = () => {
               <span className="truncate">{config.admin.name}</span>
             </a>
           )}
+          {authUser && (
+            <button
+              onClick={handleLogout}
+              className="text-slate-500 dark:text-slate-400 hover:text-indigo-600 dark:hover:text-indigo-400 flex items-center gap-1.5 transition-colors group"
+              title={`Sign out ${authUser.username}`}
+            >
+              <LogOut size={12} className="text-slate-400 dark:text-slate-500 group-hover:text-indigo-500 dark:group-hover:text-indigo-400 shrink-0" />
+              <span className="truncate">{authUser.username}</span>
+            </button>
+          )}
+          {config.authEnabled && !authUser && (
+            <button
+              onClick={() => setShowLogin(true)}
+              className="text-slate-500 dark:text-slate-400 hover:text-indigo-600 dark:hover:text-indigo-400 flex items-center gap-1.5 transition-colors group"
+              title="Sign in for docs and per-user details"
+            >
+              <LogIn size={12} className="text-slate-400 dark:text-slate-500 group-hover:text-indigo-500 dark:group-hover:text-indigo-400 shrink-0" />
+              <span>Sign in</span>
+            </button>
+          )}
         </div>
       </div>
     </>
@@ -317,11 +436,24 @@ const App = () => {
         ) : (
           <FileText size={14} className={`shrink-0 ${isSelected ? "text-indigo-500 dark:text-indigo-400" : "text-slate-400 dark:text-slate-500"}`} />
         )}
-        <span className="truncate">{node.name.replace(/\.md$/i, '')}</span>
+        <span className="truncate">{node.title || node.name.replace(/\.md$/i, '')}</span>
       </div>
     );
   };
 
+  if (config.authEnabled && !authChecked) {
+    return <div className="min-h-screen bg-slate-50 dark:bg-[#0f172a]" />;
+  }
+  if (needsLogin) {
+    return (
+      <LoginView
+        config={config}
+        onLogin={() => { setShowLogin(false); checkAuth(); }}
+        onCancel={loginRequired ? null : () => setShowLogin(false)}
+      />
+    );
+  }
+
   return (
     <div className="min-h-screen bg-slate-50 dark:bg-[#0f172a] flex font-sans text-slate-800 dark:text-slate-200 selection:bg-indigo-100 dark:selection:bg-indigo-900/30 selection:text-indigo-700 dark:selection:text-indigo-400 transition-colors duration-300">
       
@@ -0,0 +1,112 @@
+import { useState } from 'react';
+import { CircuitBoard, LogIn, KeyRound } from 'lucide-react';
+
+const LoginView = ({ config, onLogin, onCancel }) => {
+  const [username, setUsername] = useState('');
+  const [password, setPassword] = useState('');
+  const [error, setError] = useState('');
+  const [submitting, setSubmitting] = useState(false);
+
+  const handleSubmit = async (e) => {
+    e.preventDefault();
+    setSubmitting(true);
+    setError('');
+    try {
+      const res = await fetch('/api/auth/login', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ username, password })
+      });
+      if (res.ok) {
+        onLogin(await res.json());
+        return;
+      }
+      setError((await res.text()).trim() || 'Login failed');
+    } catch (err) {
+      console.error('Login failed:', err);
+      setError('Server unreachable');
+    } finally {
+      setSubmitting(false);
+    }
+  };
+
+  const inputClass = "w-full px-4 py-2.5 rounded-xl border-2 border-slate-200 dark:border-slate-700 bg-white dark:bg-slate-900 text-sm text-slate-800 dark:text-slate-200 focus:outline-none focus:border-indigo-400 dark:focus:border-indigo-600 transition-colors";
+
+  return (
+    <div className="min-h-screen bg-slate-50 dark:bg-[#0f172a] flex items-center justify-center p-4 font-sans text-slate-800 dark:text-slate-200 transition-colors duration-300">
+      <form
+        onSubmit={handleSubmit}
+        className="w-full max-w-sm bg-white dark:bg-[#1e293b] rounded-2xl shadow-xl border border-slate-100 dark:border-slate-700/50 p-8 space-y-5"
+      >
+        <div className="flex items-center gap-4 mb-2">
+          <div className="w-12 h-12 shrink-0 bg-gradient-to-br from-indigo-600 to-violet-700 rounded-xl flex items-center justify-center text-white shadow-xl shadow-indigo-200 dark:shadow-indigo-900/30">
+            <CircuitBoard size={24} strokeWidth={2.5} />
+          </div>
+          <div className="min-w-0">
+            <h1 className="font-extrabold text-2xl text-slate-900 dark:text-slate-100 tracking-tight leading-none truncate">{config.projectName}</h1>
+            <span className="text-[10px] font-bold text-slate-400 dark:text-slate-500 tracking-wider mt-1 block leading-tight">{config.labName}</span>
+          </div>
+        </div>
+
+        <input
+          className={inputClass}
+          placeholder="Username"
+          autoComplete="username"
+          value={username}
+          onChange={(e) => setUsername(e.target.value)}
+          autoFocus
+          required
+        />
+        <input
+          className={inputClass}
+          type="password"
+          placeholder="Password"
+          autoComplete="current-password"
+          value={password}
+          onChange={(e) => setPassword(e.target.value)}
+          required
+        />
+
+        {error && <div className="text-xs font-medium text-rose-600 dark:text-rose-400">{error}</div>}
+
+        <button
+          type="submit"
+          disabled={submitting}
+          className="w-full flex items-center justify-center gap-2 px-4 py-2.5 rounded-xl bg-indigo-600 hover:bg-indigo-700 disabled:opacity-60 text-white text-sm font-bold transition-colors"
+        >
+          <LogIn size={16} />
+          {submitting ? 'Signing in...' : 'Sign in'}
+        </button>
+
+        {config.sso && (
+          <>
+            <div className="flex items-center gap-3 text-[10px] font-bold text-slate-400 dark:text-slate-500 uppercase tracking-wider">
+              <div className="flex-1 border-t border-slate-200 dark:border-slate-700" />
+              or
+              <div className="flex-1 border-t border-slate-200 dark:border-slate-700" />
+            </div>
+            <a
+              href={`/api/auth/oidc/login?redirect=${encodeURIComponent(window.location.pathname)}`}
+              className="w-full flex items-center justify-center gap-2 px-4 py-2.5 rounded-xl border-2 border-slate-200 dark:border-slate-700 hover:border-indigo-400 dark:hover:border-indigo-600 text-slate-700 dark:text-slate-300 text-sm font-bold transition-colors"
+            >
+              <KeyRound size={16} />
+              Sign in with {config.sso.name}
+            </a>
+          </>
+        )}
+
+        {onCancel && (
+          <button
+            type="button"
+            onClick={onCancel}
+            className="w-full text-xs font-medium text-slate-400 dark:text-slate-500 hover:text-indigo-600 dark:hover:text-indigo-400 transition-colors"
+          >
+            Continue without signing in
+          </button>
+        )}
+      </form>
+    </div>
+  );
+};
+
+export default LoginView;
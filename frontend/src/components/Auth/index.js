@@ -0,0 +1 @@
+export { default as LoginView } from './LoginView';
@@ -264,6 +264,12 @@ const DocsView = memo(({ selectedFile, content, loading }) => {
                         <Clock size={14} className="text-indigo-500 dark:text-indigo-400"/>
                         <span>Updated <span className="text-slate-700 dark:text-slate-300 font-bold">{selectedFile.modTime || 'Unknown'}</span></span>
                     </div>
+                    {selectedFile.draft && (
+                      <span className="px-3 py-1.5 rounded-full bg-amber-50 dark:bg-amber-950/30 text-amber-700 dark:text-amber-400 border border-amber-200 dark:border-amber-800/40">Draft</span>
+                    )}
+                    {selectedFile.tags?.map(tag => (
+                      <span key={tag} className="px-3 py-1.5 rounded-full bg-indigo-50 dark:bg-indigo-950/30 text-indigo-600 dark:text-indigo-400">#{tag}</span>
+                    ))}
                   </div>
               </div>
 
@@ -313,7 +313,7 @@ const SlurmView = ({ config }) => {
       if (!normalizedQuery) {
         return true;
       }
-      return job.name.toLowerCase().includes(normalizedQuery) || job.user.toLowerCase().includes(normalizedQuery);
+      return job.name.toLowerCase().includes(normalizedQuery) || (job.user || '').toLowerCase().includes(normalizedQuery);
     })
     .sort(compareJobsById);
 
@@ -390,7 +390,7 @@ const SlurmView = ({ config }) => {
                   <tr key={job.id} className="border-t border-slate-100 dark:border-slate-600/50 transition-colors hover:bg-slate-50 dark:hover:bg-slate-700/50">
                     <td className="px-4 py-3 font-mono text-slate-700 dark:text-slate-300 whitespace-nowrap">{job.id}</td>
                     <td className="px-4 py-3 text-slate-700 dark:text-slate-300 min-w-[320px] whitespace-nowrap">{job.name}</td>
-                    <td className="px-4 py-3 text-slate-500 dark:text-slate-400 whitespace-nowrap">{job.user}</td>
+                    <td className="px-4 py-3 text-slate-500 dark:text-slate-400 whitespace-nowrap">{job.user || '—'}</td>
                     <td className="px-4 py-3 text-slate-500 dark:text-slate-400 whitespace-nowrap">{job.partition}</td>
                     <td className="px-4 py-3 whitespace-nowrap">
                       <span className={`inline-flex px-2.5 py-1 rounded-full text-xs font-bold ${getStateClasses(job.state)}`}>
@@ -8,6 +8,10 @@ export const processConfig = (data) => ({
     historyGPU: data?.monitor?.historyGPU ?? 20,
     historyRAM: data?.monitor?.historyRAM ?? 20,
     defaultDoc: data?.defaultDoc || "index.md",
+    authEnabled: data?.auth?.enabled || false,
+    sso: data?.auth?.oidc?.enabled ? {
+        name: data.auth.oidc.providerName || "Single Sign-On"
+    } : null,
     slurm: {
         enabled: data?.slurm?.enabled || false,
         available: data?.slurm?.available || false,
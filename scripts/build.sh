@@ -99,8 +99,8 @@ prepare_releases() {
         # Copy default documentation
         cp templates/index.md "$pkg_dir/" 2>/dev/null || echo "# Welcome to LabMD" > "$pkg_dir/index.md"
         
-        # Copy systemd service file
-        cp templates/labmd.service "$pkg_dir/"
+        # Copy systemd service files
+        cp templates/labmd.service templates/labmd-helper.service templates/labmd-helper.socket "$pkg_dir/"
         
         # Copy install script
         cp scripts/install.sh "$pkg_dir/"
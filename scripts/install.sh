@@ -179,6 +179,12 @@ chmod +x /usr/share/labmd/uninstall.sh
 cp upgrade.sh /usr/share/labmd/
 chmod +x /usr/share/labmd/upgrade.sh
 
+# The server runs as the labmd user; only labmd-helper runs as root
+if ! id -u labmd > /dev/null 2>&1; then
+    useradd -r -d /home/labmd -s /bin/bash labmd
+    echo -e "${GREEN}[OK]${NC} Created labmd user"
+fi
+
 # Setup docs directory (only for fresh install)
 if [ "$INSTALL_MODE" = "fresh" ]; then
     # Create docs directory if it doesn't exist
@@ -189,12 +195,6 @@ if [ "$INSTALL_MODE" = "fresh" ]; then
     
     if [ "$DOCS_PATH" = "/home/labmd/docs" ]; then
         # Shared directory setup
-        # Create labmd user if needed
-        if ! id -u labmd > /dev/null 2>&1; then
-            useradd -r -d /home/labmd -s /bin/bash labmd
-            echo -e "${GREEN}[OK]${NC} Created labmd user"
-        fi
-        
         # Set shared directory permissions
         chown -R labmd:labmd /home/labmd
         chmod 1777 /home/labmd
@@ -213,9 +213,19 @@ if [ "$INSTALL_MODE" = "fresh" ]; then
     else
         # Personal directory setup
         chown -R $ACTUAL_USER:$ACTUAL_USER "$DOCS_PATH" 2>/dev/null || true
+        if command -v setfacl &> /dev/null; then
+            # Let the labmd service user reach the docs inside a private home
+            setfacl -m u:labmd:x "$ACTUAL_HOME" 2>/dev/null || true
+            setfacl -R -m u:labmd:rwX,d:u:labmd:rwX "$DOCS_PATH" 2>/dev/null || true
+        fi
         echo -e "${GREEN}[OK]${NC} Personal directory configured"
     fi
     
+    # labmd-helper gives documents saved from the web back to their owners,
+    # so it may change owners in the docs directory and nowhere else
+    mkdir -p /etc/systemd/system/labmd-helper.service.d
+    printf '[Service]\nReadWritePaths=%s\n' "$DOCS_PATH" > /etc/systemd/system/labmd-helper.service.d/docs.conf
+    
     # Copy default documentation if directory is empty
     if [ ! "$(ls -A $DOCS_PATH 2>/dev/null)" ]; then
         cp index.md "$DOCS_PATH/"
@@ -261,10 +271,21 @@ EOF
     echo -e "${GREEN}[OK]${NC} Configuration created"
 fi
 
+# Files the unprivileged server reads or writes under /etc/labmd
+chgrp labmd /etc/labmd
+chmod 750 /etc/labmd
+mkdir -p /etc/labmd/tls
+chown labmd:labmd /etc/labmd/tls
+if [ -f /etc/labmd/users.htpasswd ]; then
+    chgrp labmd /etc/labmd/users.htpasswd
+    chmod 640 /etc/labmd/users.htpasswd
+fi
+
 # Install and start systemd service
 if command -v systemctl &> /dev/null; then
-    cp labmd.service /etc/systemd/system/
+    cp labmd.service labmd-helper.service labmd-helper.socket /etc/systemd/system/
     systemctl daemon-reload
+    systemctl enable --now labmd-helper.socket
     echo -e "${GREEN}[OK]${NC} Service installed"
     
     echo
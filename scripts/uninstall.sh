@@ -69,12 +69,6 @@ if [ -n "$DOCS_PATH" ] && [ -d "$DOCS_PATH" ]; then
     fi
 fi
 
-# Remove labmd user if exists
-if id -u labmd > /dev/null 2>&1; then
-    userdel labmd 2>/dev/null || true
-    echo -e "${GREEN}[OK]${NC} Removed labmd user"
-fi
-
 # Stop service
 if systemctl is-active --quiet labmd 2>/dev/null; then
     echo -e "${YELLOW}Stopping LabMD service...${NC}"
@@ -83,13 +77,26 @@ if systemctl is-active --quiet labmd 2>/dev/null; then
     echo -e "${GREEN}[OK]${NC} Service stopped"
 fi
 
+# Stop root helper
+if systemctl is-enabled --quiet labmd-helper.socket 2>/dev/null; then
+    systemctl disable --now labmd-helper.socket labmd-helper.service 2>/dev/null || true
+fi
+
 # Remove systemd service
 if [ -f "/etc/systemd/system/labmd.service" ]; then
     rm -f /etc/systemd/system/labmd.service
+    rm -f /etc/systemd/system/labmd-helper.service /etc/systemd/system/labmd-helper.socket
+    rm -rf /etc/systemd/system/labmd-helper.service.d
     systemctl daemon-reload
     echo -e "${GREEN}[OK]${NC} Systemd service removed"
 fi
 
+# Remove labmd user if exists
+if id -u labmd > /dev/null 2>&1; then
+    userdel labmd 2>/dev/null || true
+    echo -e "${GREEN}[OK]${NC} Removed labmd user"
+fi
+
 # Remove binary
 if [ -f "/usr/local/bin/labmd" ]; then
     rm -f /usr/local/bin/labmd